@@ -204,3 +204,65 @@ func TestAmf3ByteArray(t *testing.T) {
 		t.Errorf("expected: %+v, got %+v", expect, buf)
 	}
 }
+
+func TestAmf3Struct(t *testing.T) {
+	type point struct {
+		X int32 `amf:"x"`
+		Y int32 `amf:"y"`
+	}
+
+	val := struct {
+		Name   string            `amf:"name"`
+		Origin point             `amf:"origin"`
+		Points []*point          `amf:"points"`
+		Labels map[string]string `amf:"labels"`
+	}{
+		Name:   "shape",
+		Origin: point{1, 2},
+		Points: []*point{{3, 4}, nil},
+		Labels: map[string]string{"color": "red"},
+	}
+
+	res, err := EncodeAndDecode(val, 3)
+	if err != nil {
+		t.Errorf("amf3 struct: %s", err)
+	}
+
+	expect := Object{
+		"name":   "shape",
+		"origin": Object{"x": int32(1), "y": int32(2)},
+		"points": Array{Object{"x": int32(3), "y": int32(4)}, nil},
+		"labels": Object{"color": "red"},
+	}
+
+	if !reflect.DeepEqual(expect, res) {
+		t.Errorf("amf3 struct: expected %+v, got %+v", expect, res)
+	}
+}
+
+func TestAmf3StringReferences(t *testing.T) {
+	var arr Array
+	for i := 0; i < 3; i++ {
+		to := *NewTypedObject()
+		to.Type = "org.amf.ASClass"
+		to.Object["name"] = "alfie"
+		arr = append(arr, to)
+	}
+
+	res, err := EncodeAndDecode(arr, 3)
+	if err != nil {
+		t.Fatalf("amf3 string references: %s", err)
+	}
+
+	result, ok := res.(Array)
+	if ok != true || len(result) != 3 {
+		t.Fatalf("amf3 string references: unexpected result %+v", res)
+	}
+
+	for i, v := range result {
+		to, ok := v.(TypedObject)
+		if ok != true || to.Type != "org.amf.ASClass" || to.Object["name"] != "alfie" {
+			t.Errorf("amf3 string references: element %d decoded as %+v", i, v)
+		}
+	}
+}
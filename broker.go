@@ -0,0 +1,379 @@
+package amf
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Broker routes flex messages between producers and consumers, like the
+// message service of BlazeDS. Consumers subscribe to a destination, narrowed
+// to a subtopic and a selector on message headers if they choose, and
+// collect the messages published to it by polling. Messages are queued per
+// flex client (the DSId header), so a single poll returns the messages of
+// all consumers of a client.
+type Broker struct {
+	// PollWait is how long a poll waits for a message when none are queued,
+	// for long polling. Polls return immediately when it is zero.
+	PollWait time.Duration
+
+	// MaxQueued bounds the messages queued for a flex client, dropping the
+	// oldest. The queue is unbounded when it is zero.
+	MaxQueued int
+
+	mu           sync.Mutex
+	destinations map[string]*brokerDestination
+	clients      map[string]*brokerClient
+}
+
+type brokerDestination struct {
+	// subscriptions by flex client and consumer client id, so that clients
+	// cannot reach the consumers of others
+	consumers map[consumerKey][]*subscription
+}
+
+type consumerKey struct {
+	flexClientId string
+	consumerId   string
+}
+
+type subscription struct {
+	subtopic     string
+	selectorText string
+	selector     selector
+}
+
+type brokerClient struct {
+	queue []*AsyncMessage
+
+	// wake is closed and replaced when messages are queued, waking polls
+	wake chan struct{}
+
+	// destinations of each consumer of the client
+	consumers map[string]map[string]bool
+}
+
+func NewBroker() *Broker {
+	return new(Broker)
+}
+
+// AddDestination allows consumers to subscribe and producers to publish to
+// destination name.
+func (b *Broker) AddDestination(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.destinations == nil {
+		b.destinations = make(map[string]*brokerDestination)
+	}
+
+	if b.destinations[name] == nil {
+		b.destinations[name] = &brokerDestination{consumers: make(map[consumerKey][]*subscription)}
+	}
+}
+
+// Publish queues msg for every consumer subscribed to its destination, and
+// to its subtopic (the DSSubtopic header) if it has one, whose selector
+// matches its headers. A message id and timestamp are set when missing.
+func (b *Broker) Publish(msg *AsyncMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d := b.destinations[msg.Destination]
+	if d == nil {
+		return NewStatusError(STATUS_RESOURCE_UNAVAILABLE, "no destination %s", msg.Destination)
+	}
+
+	if msg.MessageId == "" {
+		msg.MessageId = newUUID()
+	}
+
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	subtopic, _ := msg.Headers[MESSAGE_SUBTOPIC_HEADER].(string)
+
+	for key, subs := range d.consumers {
+		for _, sub := range subs {
+			if !subtopicMatches(sub.subtopic, subtopic) || !sub.selector.matches(msg.Headers) {
+				continue
+			}
+
+			// consumers tell their messages apart by client id
+			m := *msg
+			m.ClientId = key.consumerId
+			m.Headers = make(Object, len(msg.Headers))
+			for k, v := range msg.Headers {
+				m.Headers[k] = v
+			}
+
+			b.enqueue(key.flexClientId, &m)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (b *Broker) enqueue(flexClientId string, msg *AsyncMessage) {
+	c := b.clients[flexClientId]
+	if c == nil {
+		return
+	}
+
+	c.queue = append(c.queue, msg)
+	if b.MaxQueued > 0 && len(c.queue) > b.MaxQueued {
+		c.queue = c.queue[len(c.queue)-b.MaxQueued:]
+	}
+
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// subscribe subscribes the consumer m.ClientId of a flex client to
+// m.Destination, replacing its previous subscriptions.
+func (b *Broker) subscribe(flexClientId string, m *CommandMessage) error {
+	subtopic, _ := m.Headers[MESSAGE_SUBTOPIC_HEADER].(string)
+	selectorText, _ := m.Headers[COMMAND_SELECTOR_HEADER].(string)
+
+	sub, err := newSubscription(subtopic, selectorText)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, err := b.consumerDestination(m)
+	if err != nil {
+		return err
+	}
+
+	d.consumers[consumerKey{flexClientId, m.ClientId}] = []*subscription{sub}
+	b.addConsumer(flexClientId, m.Destination, m.ClientId)
+
+	return nil
+}
+
+// multiSubscribe adds and removes subscriptions of the consumer m.ClientId,
+// listed as "subtopic_;_selector" in the DSAddSub and DSRemSub headers.
+func (b *Broker) multiSubscribe(flexClientId string, m *CommandMessage) error {
+	var add, remove []*subscription
+
+	for _, list := range []struct {
+		header string
+		subs   *[]*subscription
+	}{
+		{COMMAND_ADD_SUBSCRIPTIONS_HEADER, &add},
+		{COMMAND_REMOVE_SUBSCRIPTIONS_HEADER, &remove},
+	} {
+		var entries []string
+		if v := m.Headers[list.header]; v != nil {
+			if err := Convert(v, &entries); err != nil {
+				return NewStatusError(STATUS_PROCESSING, "invalid %s header: %s", list.header, err)
+			}
+		}
+
+		for _, entry := range entries {
+			subtopic, selectorText := entry, ""
+			if i := strings.Index(entry, COMMAND_SUBTOPIC_SEPARATOR); i >= 0 {
+				subtopic, selectorText = entry[:i], entry[i+len(COMMAND_SUBTOPIC_SEPARATOR):]
+			}
+
+			sub, err := newSubscription(subtopic, selectorText)
+			if err != nil {
+				return err
+			}
+
+			*list.subs = append(*list.subs, sub)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, err := b.consumerDestination(m)
+	if err != nil {
+		return err
+	}
+
+	key := consumerKey{flexClientId, m.ClientId}
+	subs := d.consumers[key]
+
+	for _, sub := range remove {
+		for i := range subs {
+			if subs[i].subtopic == sub.subtopic && subs[i].selectorText == sub.selectorText {
+				subs = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, sub := range add {
+		found := false
+		for i := range subs {
+			found = found || (subs[i].subtopic == sub.subtopic && subs[i].selectorText == sub.selectorText)
+		}
+
+		if !found {
+			subs = append(subs, sub)
+		}
+	}
+
+	if len(subs) == 0 {
+		b.removeConsumer(flexClientId, m.Destination, m.ClientId)
+		return nil
+	}
+
+	d.consumers[key] = subs
+	b.addConsumer(flexClientId, m.Destination, m.ClientId)
+
+	return nil
+}
+
+// unsubscribe removes the subscriptions of the consumer m.ClientId.
+func (b *Broker) unsubscribe(flexClientId string, m *CommandMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeConsumer(flexClientId, m.Destination, m.ClientId)
+}
+
+// disconnect removes the subscriptions and queued messages of a flex client.
+func (b *Broker) disconnect(flexClientId string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.clients[flexClientId]
+	if c == nil {
+		return
+	}
+
+	for consumerId, destinations := range c.consumers {
+		for destination := range destinations {
+			b.removeConsumer(flexClientId, destination, consumerId)
+		}
+	}
+}
+
+// poll returns the messages queued for a flex client, waiting up to
+// PollWait for one if there are none.
+func (b *Broker) poll(flexClientId string) []*AsyncMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.clients[flexClientId]
+	if c == nil {
+		return nil
+	}
+
+	if len(c.queue) == 0 && b.PollWait > 0 {
+		wake := c.wake
+		b.mu.Unlock()
+
+		timer := time.NewTimer(b.PollWait)
+		select {
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		b.mu.Lock()
+	}
+
+	msgs := c.queue
+	c.queue = nil
+
+	return msgs
+}
+
+func newSubscription(subtopic, selectorText string) (*subscription, error) {
+	sel, err := parseSelector(selectorText)
+	if err != nil {
+		return nil, NewStatusError(STATUS_PROCESSING, "%s", err)
+	}
+
+	return &subscription{
+		subtopic:     subtopic,
+		selectorText: selectorText,
+		selector:     sel,
+	}, nil
+}
+
+func (b *Broker) consumerDestination(m *CommandMessage) (*brokerDestination, error) {
+	if m.ClientId == "" {
+		return nil, NewStatusError(STATUS_PROCESSING, "no consumer id to subscribe to %s", m.Destination)
+	}
+
+	d := b.destinations[m.Destination]
+	if d == nil {
+		return nil, NewStatusError(STATUS_RESOURCE_UNAVAILABLE, "no destination %s", m.Destination)
+	}
+
+	return d, nil
+}
+
+func (b *Broker) addConsumer(flexClientId, destination, consumerId string) {
+	if b.clients == nil {
+		b.clients = make(map[string]*brokerClient)
+	}
+
+	c := b.clients[flexClientId]
+	if c == nil {
+		c = &brokerClient{wake: make(chan struct{}), consumers: make(map[string]map[string]bool)}
+		b.clients[flexClientId] = c
+	}
+
+	if c.consumers[consumerId] == nil {
+		c.consumers[consumerId] = make(map[string]bool)
+	}
+
+	c.consumers[consumerId][destination] = true
+}
+
+// removeConsumer drops the subscriptions of a consumer, and the flex client
+// once it has no consumers left.
+func (b *Broker) removeConsumer(flexClientId, destination, consumerId string) {
+	if d := b.destinations[destination]; d != nil {
+		delete(d.consumers, consumerKey{flexClientId, consumerId})
+	}
+
+	c := b.clients[flexClientId]
+	if c == nil {
+		return
+	}
+
+	delete(c.consumers[consumerId], destination)
+	if len(c.consumers[consumerId]) == 0 {
+		delete(c.consumers, consumerId)
+	}
+
+	if len(c.consumers) == 0 {
+		delete(b.clients, flexClientId)
+		close(c.wake)
+	}
+}
+
+// subtopicMatches reports whether subtopic matches pattern, whose "*"
+// segments match any segment, and a trailing "*" any remaining segments.
+// Subscriptions without a subtopic only receive messages without one.
+func subtopicMatches(pattern, subtopic string) bool {
+	if pattern == "" || subtopic == "" {
+		return pattern == subtopic
+	}
+
+	p, s := strings.Split(pattern, "."), strings.Split(subtopic, ".")
+
+	for i, seg := range p {
+		if i >= len(s) || (seg != "*" && seg != s[i]) {
+			return false
+		}
+
+		if seg == "*" && i == len(p)-1 {
+			return true
+		}
+	}
+
+	return len(p) == len(s)
+}
@@ -0,0 +1,225 @@
+package amf
+
+import (
+	"testing"
+	"time"
+)
+
+func brokerCommand(e *Endpoint, flexClientId, consumerId string, op int32, headers Object) interface{} {
+	m := new(CommandMessage)
+	m.Operation = op
+	m.Destination = "chat"
+	m.ClientId = consumerId
+	m.Headers = Object{MESSAGE_FLEX_CLIENT_ID_HEADER: flexClientId}
+	for k, v := range headers {
+		m.Headers[k] = v
+	}
+
+	return e.serveFlex(m)
+}
+
+func brokerPoll(t *testing.T, e *Endpoint, flexClientId string) []*AsyncMessage {
+	var msgs []*AsyncMessage
+
+	switch reply := brokerCommand(e, flexClientId, "", COMMAND_POLL_OPERATION, nil).(type) {
+	case *AcknowledgeMessage:
+	case *CommandMessage:
+		if reply.Operation != COMMAND_CLIENT_SYNC_OPERATION {
+			t.Errorf("expected sync command, got operation %d", reply.Operation)
+		}
+		for _, msg := range reply.Body.(Array) {
+			msgs = append(msgs, msg.(*AsyncMessage))
+		}
+	default:
+		t.Fatalf("unexpected poll reply %#v", reply)
+	}
+
+	return msgs
+}
+
+func TestBroker(t *testing.T) {
+	e := NewEndpoint()
+	e.Broker = NewBroker()
+	e.Broker.AddDestination("chat")
+
+	subs := []struct {
+		flexClientId, consumerId string
+		headers                  Object
+	}{
+		{"A", "all", nil},
+		{"A", "euw", Object{MESSAGE_SUBTOPIC_HEADER: "lobby.euw"}},
+		{"B", "lobbies", Object{MESSAGE_SUBTOPIC_HEADER: "lobby.*", COMMAND_SELECTOR_HEADER: "priority > 1"}},
+	}
+
+	for _, sub := range subs {
+		if reply, ok := brokerCommand(e, sub.flexClientId, sub.consumerId, COMMAND_SUBSCRIBE_OPERATION, sub.headers).(*AcknowledgeMessage); !ok {
+			t.Fatalf("subscribe %s: %#v", sub.consumerId, reply)
+		}
+	}
+
+	if _, ok := e.serveFlex(&CommandMessage{AsyncMessage: AsyncMessage{AbstractMessage: AbstractMessage{Destination: "missing", ClientId: "x"}}, Operation: COMMAND_SUBSCRIBE_OPERATION}).(*ErrorMessage); !ok {
+		t.Errorf("expected error subscribing to missing destination")
+	}
+
+	publish := func(subtopic string, priority int) {
+		msg := new(AsyncMessage)
+		msg.Destination = "chat"
+		msg.Headers = Object{"priority": priority}
+		if subtopic != "" {
+			msg.Headers[MESSAGE_SUBTOPIC_HEADER] = subtopic
+		}
+
+		if reply, ok := e.serveFlex(msg).(*AcknowledgeMessage); !ok {
+			t.Fatalf("publish: %#v", reply)
+		}
+	}
+
+	publish("", 1)
+	publish("lobby.euw", 2)
+	publish("lobby.na", 1)
+
+	a := brokerPoll(t, e, "A")
+	if len(a) != 2 || a[0].ClientId != "all" || a[1].ClientId != "euw" {
+		t.Errorf("unexpected messages for A: %#v", a)
+	}
+
+	b := brokerPoll(t, e, "B")
+	if len(b) != 1 || b[0].ClientId != "lobbies" || b[0].Headers["priority"] != 2 {
+		t.Errorf("unexpected messages for B: %#v", b)
+	}
+
+	if msgs := brokerPoll(t, e, "A"); len(msgs) != 0 {
+		t.Errorf("expected drained queue, got %#v", msgs)
+	}
+
+	brokerCommand(e, "A", "euw", COMMAND_UNSUBSCRIBE_OPERATION, nil)
+	publish("lobby.euw", 2)
+
+	if a = brokerPoll(t, e, "A"); len(a) != 0 {
+		t.Errorf("expected no messages after unsubscribe, got %#v", a)
+	}
+
+	brokerCommand(e, "B", "", COMMAND_DISCONNECT_OPERATION, nil)
+	if len(e.Broker.clients) != 1 {
+		t.Errorf("expected disconnected client to be removed, got %d clients", len(e.Broker.clients))
+	}
+}
+
+func TestBrokerMultiSubscribe(t *testing.T) {
+	e := NewEndpoint()
+	e.Broker = NewBroker()
+	e.Broker.AddDestination("chat")
+
+	brokerCommand(e, "A", "multi", COMMAND_MULTI_SUBSCRIBE_OPERATION, Object{
+		COMMAND_ADD_SUBSCRIPTIONS_HEADER: Array{"euw", "na" + COMMAND_SUBTOPIC_SEPARATOR + "priority = 1"},
+	})
+
+	for _, subtopic := range []string{"euw", "na", "kr"} {
+		msg := new(AsyncMessage)
+		msg.Destination = "chat"
+		msg.Headers = Object{MESSAGE_SUBTOPIC_HEADER: subtopic, "priority": 1}
+		e.Broker.Publish(msg)
+	}
+
+	if msgs := brokerPoll(t, e, "A"); len(msgs) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(msgs))
+	}
+
+	brokerCommand(e, "A", "multi", COMMAND_MULTI_SUBSCRIBE_OPERATION, Object{
+		COMMAND_REMOVE_SUBSCRIPTIONS_HEADER: Array{"euw"},
+	})
+
+	msg := new(AsyncMessage)
+	msg.Destination = "chat"
+	msg.Headers = Object{MESSAGE_SUBTOPIC_HEADER: "euw"}
+	e.Broker.Publish(msg)
+
+	if msgs := brokerPoll(t, e, "A"); len(msgs) != 0 {
+		t.Errorf("expected no messages after removing subscription, got %d", len(msgs))
+	}
+}
+
+func TestBrokerConsumerOfOtherClient(t *testing.T) {
+	e := NewEndpoint()
+	e.Broker = NewBroker()
+	e.Broker.AddDestination("chat")
+
+	brokerCommand(e, "A", "consumer", COMMAND_SUBSCRIBE_OPERATION, nil)
+
+	// another flex client using the same consumer id neither replaces nor
+	// removes the subscription of A
+	brokerCommand(e, "B", "consumer", COMMAND_SUBSCRIBE_OPERATION, Object{MESSAGE_SUBTOPIC_HEADER: "other"})
+	brokerCommand(e, "B", "consumer", COMMAND_UNSUBSCRIBE_OPERATION, nil)
+	brokerCommand(e, "B", "consumer", COMMAND_MULTI_SUBSCRIBE_OPERATION, Object{
+		COMMAND_REMOVE_SUBSCRIPTIONS_HEADER: Array{""},
+	})
+
+	msg := new(AsyncMessage)
+	msg.Destination = "chat"
+	e.Broker.Publish(msg)
+
+	if msgs := brokerPoll(t, e, "A"); len(msgs) != 1 || msgs[0].ClientId != "consumer" {
+		t.Errorf("expected message for A, got %#v", msgs)
+	}
+
+	if msgs := brokerPoll(t, e, "B"); len(msgs) != 0 {
+		t.Errorf("expected no messages for B, got %#v", msgs)
+	}
+}
+
+func TestBrokerLongPoll(t *testing.T) {
+	b := NewBroker()
+	b.PollWait = 5 * time.Second
+	b.AddDestination("chat")
+
+	sub := new(CommandMessage)
+	sub.Destination = "chat"
+	sub.ClientId = "consumer"
+	if err := b.subscribe("A", sub); err != nil {
+		t.Fatalf("subscribe: %s", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+
+		msg := new(AsyncMessage)
+		msg.Destination = "chat"
+		msg.Body = "hello"
+		b.Publish(msg)
+	}()
+
+	start := time.Now()
+	msgs := b.poll("A")
+
+	if len(msgs) != 1 || msgs[0].Body != "hello" {
+		t.Errorf("unexpected messages %#v", msgs)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("long poll did not wake on publish")
+	}
+}
+
+func TestSubtopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern, subtopic string
+		matches           bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"a", "", false},
+		{"a.b", "a.b", true},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", true},
+		{"a.*", "a", false},
+		{"*.b", "a.b", true},
+		{"*.b", "a.c", false},
+		{"a.b", "a.b.c", false},
+	}
+
+	for _, test := range tests {
+		if subtopicMatches(test.pattern, test.subtopic) != test.matches {
+			t.Errorf("%q against %q: expected %v", test.pattern, test.subtopic, test.matches)
+		}
+	}
+}
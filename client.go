@@ -0,0 +1,279 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Client calls services behind a flash remoting gateway, like a
+// NetConnection in actionscript. Calls made while a request is in flight
+// are batched into the next packet.
+type Client struct {
+	// Version is the version of packets sent, AMF0 unless set.
+	Version Version
+
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	url     string
+	headers []Header
+	pending []*clientCall
+	sending bool
+	seq     int
+}
+
+type clientCall struct {
+	ctx    context.Context
+	msg    Message
+	result interface{}
+	err    error
+	done   chan struct{}
+}
+
+// the value of a RequestPersistentHeader header
+type persistentHeader struct {
+	Name           string      `amf:"name"`
+	MustUnderstand bool        `amf:"mustUnderstand"`
+	Data           interface{} `amf:"data"`
+}
+
+// NewClient returns a client for the gateway at url. If httpClient is nil,
+// http.DefaultClient is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{url: url, httpClient: httpClient}
+}
+
+// URL returns the gateway url, as changed by the gateway through
+// AppendToGatewayUrl and ReplaceGatewayUrl headers.
+func (c *Client) URL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.url
+}
+
+// AddHeader sends a header with every following request, replacing any
+// header of the same name.
+func (c *Client) AddHeader(name string, mustUnderstand bool, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addHeader(Header{name, mustUnderstand, value})
+}
+
+func (c *Client) addHeader(h Header) {
+	for i := range c.headers {
+		if c.headers[i].Name == h.Name {
+			c.headers[i] = h
+			return
+		}
+	}
+
+	c.headers = append(c.headers, h)
+}
+
+// Call calls method target, e.g. "SummonerService.getSummoner", with args
+// and returns its decoded result. Faults sent to onStatus are returned as a
+// *StatusError. The request carrying the call is canceled once ctx and the
+// contexts of the calls batched with it are all done.
+func (c *Client) Call(ctx context.Context, target string, args ...interface{}) (interface{}, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	call := &clientCall{
+		ctx:  ctx,
+		done: make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	c.seq++
+	call.msg = Message{TargetUri: target, ResponseUri: "/" + strconv.Itoa(c.seq), Body: Array(args)}
+	c.pending = append(c.pending, call)
+
+	if !c.sending {
+		c.sending = true
+		go c.flush()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush sends pending calls until there are none left.
+func (c *Client) flush() {
+	for {
+		c.mu.Lock()
+		batch := c.pending
+		c.pending = nil
+
+		if len(batch) == 0 {
+			c.sending = false
+			c.mu.Unlock()
+			return
+		}
+
+		req := &Packet{Version: c.Version, Headers: append([]Header(nil), c.headers...)}
+		url := c.url
+		c.mu.Unlock()
+
+		for _, call := range batch {
+			req.Messages = append(req.Messages, call.msg)
+		}
+
+		ctx, cancel := batchContext(batch)
+		res, err := c.send(ctx, url, req)
+		cancel()
+
+		c.finish(batch, res, err)
+	}
+}
+
+// batchContext returns a context that is canceled once the contexts of all
+// calls of batch are done, so that a request no caller waits for anymore
+// does not hold up the calls after it.
+func batchContext(batch []*clientCall) (context.Context, context.CancelFunc) {
+	if len(batch) == 1 {
+		return context.WithCancel(batch[0].ctx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mu sync.Mutex
+	remaining := len(batch)
+
+	for _, call := range batch {
+		go func(done <-chan struct{}) {
+			select {
+			case <-done:
+				mu.Lock()
+				remaining--
+				if remaining == 0 {
+					cancel()
+				}
+				mu.Unlock()
+			case <-ctx.Done():
+			}
+		}(call.ctx.Done())
+	}
+
+	return ctx, cancel
+}
+
+func (c *Client) send(ctx context.Context, url string, req *Packet) (*Packet, error) {
+	buf := new(bytes.Buffer)
+	if _, err := WritePacket(buf, req); err != nil {
+		return nil, Error("amf client: %s", err)
+	}
+
+	hr, err := http.NewRequest("POST", url, buf)
+	if err != nil {
+		return nil, Error("amf client: %s", err)
+	}
+
+	hr = hr.WithContext(ctx)
+	hr.Header.Set("Content-Type", AMF_CONTENT_TYPE)
+
+	resp, err := c.httpClient.Do(hr)
+	if err != nil {
+		return nil, Error("amf client: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, Error("amf client: gateway responded with %s", resp.Status)
+	}
+
+	res, err := ReadPacket(resp.Body)
+	if err != nil {
+		return nil, Error("amf client: %s", err)
+	}
+
+	return res, nil
+}
+
+// finish applies the headers of res and hands each call its result.
+func (c *Client) finish(batch []*clientCall, res *Packet, err error) {
+	if err == nil {
+		err = c.applyHeaders(res.Headers)
+	}
+
+	results := make(map[string]Message)
+	if res != nil {
+		for _, msg := range res.Messages {
+			results[msg.TargetUri] = msg
+		}
+	}
+
+	for _, call := range batch {
+		if err != nil {
+			call.err = err
+		} else {
+			call.result, call.err = callResult(results, call.msg.ResponseUri)
+		}
+
+		close(call.done)
+	}
+}
+
+func callResult(results map[string]Message, responseUri string) (interface{}, error) {
+	if msg, ok := results[responseUri+"/onResult"]; ok {
+		return msg.Body, nil
+	}
+
+	if msg, ok := results[responseUri+"/onStatus"]; ok {
+		status := new(StatusError)
+		if err := Convert(msg.Body, status); err != nil {
+			return nil, Error("amf client: unable to read status for %s: %s", responseUri, err)
+		}
+
+		return nil, status
+	}
+
+	return nil, Error("amf client: no response for %s", responseUri)
+}
+
+func (c *Client) applyHeaders(headers []Header) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, h := range headers {
+		switch h.Name {
+		case "AppendToGatewayUrl":
+			s, ok := h.Value.(string)
+			if !ok {
+				return Error("amf client: expected string for %s, got %T", h.Name, h.Value)
+			}
+			c.url += s
+
+		case "ReplaceGatewayUrl":
+			s, ok := h.Value.(string)
+			if !ok || strings.TrimSpace(s) == "" {
+				return Error("amf client: expected url for %s, got %#v", h.Name, h.Value)
+			}
+			c.url = s
+
+		case "RequestPersistentHeader":
+			var ph persistentHeader
+			if err := Convert(h.Value, &ph); err != nil || ph.Name == "" {
+				return Error("amf client: invalid %s: %#v", h.Name, h.Value)
+			}
+			c.addHeader(Header{ph.Name, ph.MustUnderstand, ph.Data})
+		}
+	}
+
+	return nil
+}
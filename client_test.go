@@ -0,0 +1,246 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientCall(t *testing.T) {
+	g := NewGateway()
+	if err := g.Register("SummonerService", new(gatewayService)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, nil)
+	c.Version = AMF3
+
+	result, err := c.Call(context.Background(), "SummonerService.getSummoner", "alfie", 30)
+	if err != nil {
+		t.Fatalf("call: %s", err)
+	}
+
+	var summoner gatewaySummoner
+	if err = Convert(result, &summoner); err != nil || summoner.Name != "alfie" || summoner.Level != 30 {
+		t.Errorf("unexpected result %#v: %v", result, err)
+	}
+
+	_, err = c.Call(context.Background(), "SummonerService.getSummoner", "")
+	if status, ok := err.(*StatusError); !ok || status.Code != STATUS_PROCESSING {
+		t.Errorf("expected status error, got %#v", err)
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var headers [][]Header
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ReadPacket(r.Body)
+		if err != nil {
+			t.Errorf("read: %s", err)
+			return
+		}
+
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		headers = append(headers, req.Headers)
+		mu.Unlock()
+
+		res := &Packet{
+			Headers: []Header{
+				{Name: "AppendToGatewayUrl", Value: ";jsessionid=1"},
+				{Name: "RequestPersistentHeader", Value: Object{"name": "Credentials", "mustUnderstand": false, "data": "token"}},
+			},
+			Messages: []Message{{TargetUri: req.Messages[0].ResponseUri + "/onResult", ResponseUri: "null"}},
+		}
+
+		buf := new(bytes.Buffer)
+		WritePacket(buf, res)
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL+"/gateway", nil)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Call(context.Background(), "Service.method"); err != nil {
+			t.Fatalf("call: %s", err)
+		}
+	}
+
+	if len(paths) != 2 || paths[0] != "/gateway" || paths[1] != "/gateway;jsessionid=1" {
+		t.Errorf("unexpected request paths %v", paths)
+	}
+
+	if len(headers[0]) != 0 || len(headers[1]) != 1 || headers[1][0].Name != "Credentials" || headers[1][0].Value != "token" {
+		t.Errorf("unexpected request headers %#v", headers)
+	}
+}
+
+func TestClientBatching(t *testing.T) {
+	release := make(chan struct{})
+	counts := make(chan int, 2)
+
+	g := NewGateway()
+	if err := g.Register("SummonerService", new(gatewayService)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ReadPacket(r.Body)
+		if err != nil {
+			t.Errorf("read: %s", err)
+			return
+		}
+
+		if len(counts) == 0 {
+			<-release
+		}
+		counts <- len(req.Messages)
+
+		buf := new(bytes.Buffer)
+		WritePacket(buf, g.Serve(req))
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, nil)
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 4)
+	call := func(i int) {
+		defer wg.Done()
+
+		var err error
+		results[i], err = c.Call(context.Background(), "SummonerService.sum", i, 1)
+		if err != nil {
+			t.Errorf("call %d: %s", i, err)
+		}
+	}
+
+	wg.Add(1)
+	go call(0)
+
+	// wait for the first call to be in flight, then queue up three more
+	for c.pendingCount() != 0 || !c.isSending() {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg.Add(3)
+	for i := 1; i < 4; i++ {
+		go call(i)
+	}
+
+	for c.pendingCount() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if first, second := <-counts, <-counts; first != 1 || second != 3 {
+		t.Errorf("expected batches of 1 and 3 calls, got %d and %d", first, second)
+	}
+
+	for i, result := range results {
+		if result != float64(i+1) {
+			t.Errorf("expected result %d to be %d, got %#v", i, i+1, result)
+		}
+	}
+}
+
+func TestClientBatchCanceled(t *testing.T) {
+	var mu sync.Mutex
+	hung := 0
+
+	g := NewGateway()
+	if err := g.Register("SummonerService", new(gatewayService)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+
+	// the first two requests only end when they are canceled
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ReadPacket(r.Body)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		hang := hung < 2
+		hung++
+		mu.Unlock()
+
+		if hang {
+			<-r.Context().Done()
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		WritePacket(buf, g.Serve(req))
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, nil)
+
+	first, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 3)
+	go func() {
+		_, err := c.Call(first, "SummonerService.sum", 1, 1)
+		errs <- err
+	}()
+
+	for c.pendingCount() != 0 || !c.isSending() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := c.Call(ctx, "SummonerService.sum", 1, 1)
+			errs <- err
+		}()
+	}
+
+	for c.pendingCount() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err == nil {
+			t.Errorf("expected calls given up on to fail")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if result, err := c.Call(ctx, "SummonerService.sum", 1, 1); err != nil || result != float64(2) {
+		t.Errorf("expected 2 after canceled batches, got %#v: %v", result, err)
+	}
+}
+
+func (c *Client) pendingCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending)
+}
+
+func (c *Client) isSending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sending
+}
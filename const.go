@@ -2,6 +2,7 @@ package amf
 
 import (
 	"io"
+	"reflect"
 )
 
 const (
@@ -38,29 +39,52 @@ const (
 )
 
 const (
-	AMF3_UNDEFINED_MARKER = 0x00
-	AMF3_NULL_MARKER      = 0x01
-	AMF3_FALSE_MARKER     = 0x02
-	AMF3_TRUE_MARKER      = 0x03
-	AMF3_INTEGER_MARKER   = 0x04
-	AMF3_DOUBLE_MARKER    = 0x05
-	AMF3_STRING_MARKER    = 0x06
-	AMF3_XMLDOC_MARKER    = 0x07
-	AMF3_DATE_MARKER      = 0x08
-	AMF3_ARRAY_MARKER     = 0x09
-	AMF3_OBJECT_MARKER    = 0x0a
-	AMF3_XMLSTRING_MARKER = 0x0b
-	AMF3_BYTEARRAY_MARKER = 0x0c
+	AMF3_UNDEFINED_MARKER     = 0x00
+	AMF3_NULL_MARKER          = 0x01
+	AMF3_FALSE_MARKER         = 0x02
+	AMF3_TRUE_MARKER          = 0x03
+	AMF3_INTEGER_MARKER       = 0x04
+	AMF3_DOUBLE_MARKER        = 0x05
+	AMF3_STRING_MARKER        = 0x06
+	AMF3_XMLDOC_MARKER        = 0x07
+	AMF3_DATE_MARKER          = 0x08
+	AMF3_ARRAY_MARKER         = 0x09
+	AMF3_OBJECT_MARKER        = 0x0a
+	AMF3_XMLSTRING_MARKER     = 0x0b
+	AMF3_BYTEARRAY_MARKER     = 0x0c
+	AMF3_VECTOR_INT_MARKER    = 0x0d
+	AMF3_VECTOR_UINT_MARKER   = 0x0e
+	AMF3_VECTOR_DOUBLE_MARKER = 0x0f
+	AMF3_VECTOR_OBJECT_MARKER = 0x10
+	AMF3_DICTIONARY_MARKER    = 0x11
 )
 
 type ExternalHandler func(*Decoder, io.Reader) (interface{}, error)
 
+// ExternalEncodeHandler writes the body of an externalizable object, the
+// counterpart of ExternalHandler. The trait naming the class has already
+// been written when it is called.
+type ExternalEncodeHandler func(*Encoder, io.Writer, interface{}) (int, error)
+
+// Externalizable is implemented by types that serialize themselves, like
+// classes implementing IExternalizable in actionscript. See
+// RegisterExternalizable.
+type Externalizable interface {
+	WriteExternal(e *Encoder, w io.Writer) (int, error)
+	ReadExternal(d *Decoder, r io.Reader) error
+}
+
 type Decoder struct {
 	refCache         []interface{}
 	stringRefs       []string
 	objectRefs       []interface{}
 	traitRefs        []Trait
 	externalHandlers map[string]ExternalHandler
+
+	// PreserveCollections decodes flex ArrayCollection and ObjectProxy
+	// objects to ArrayCollection and ObjectProxy, instead of the array or
+	// object they wrap.
+	PreserveCollections bool
 }
 
 func NewDecoder() *Decoder {
@@ -69,11 +93,93 @@ func NewDecoder() *Decoder {
 	}
 }
 
+// RegisterExternalHandler decodes externalizable objects of class name with
+// f on this decoder, taking precedence over handlers registered with the
+// package level RegisterExternalHandler.
 func (d *Decoder) RegisterExternalHandler(name string, f ExternalHandler) {
+	if d.externalHandlers == nil {
+		d.externalHandlers = make(map[string]ExternalHandler)
+	}
+
 	d.externalHandlers[name] = f
 }
 
+// Reset clears the reference tables of the decoder, the counterpart of
+// Encoder.Reset. Handlers and options are kept.
+func (d *Decoder) Reset() {
+	d.refCache = nil
+	d.stringRefs = nil
+	d.objectRefs = nil
+	d.traitRefs = nil
+}
+
+func (d *Decoder) externalHandler(name string) (ExternalHandler, bool) {
+	if f, ok := d.externalHandlers[name]; ok {
+		return f, true
+	}
+
+	return defaultExternalHandler(name)
+}
+
 type Encoder struct {
+	stringRefs  map[string]int
+	objectRefs  map[interface{}]int
+	objectCount int
+	traitRefs   map[string]int
+
+	amf0Refs     map[interface{}]int
+	amf0RefCount int
+
+	externalHandlers map[reflect.Type]externalEncoder
+}
+
+type externalEncoder struct {
+	name string
+	f    ExternalEncodeHandler
+}
+
+// RegisterExternalHandler encodes values of the type of v as externalizable
+// objects of class name, with f writing their body. A handler for a struct
+// type also covers pointers to it. Handlers registered on the encoder take
+// precedence over those registered with RegisterExternalEncodeHandler.
+func (e *Encoder) RegisterExternalHandler(name string, v interface{}, f ExternalEncodeHandler) {
+	if e.externalHandlers == nil {
+		e.externalHandlers = make(map[reflect.Type]externalEncoder)
+	}
+
+	e.externalHandlers[reflect.TypeOf(v)] = externalEncoder{name, f}
+}
+
+// externalHandler finds the handler for v, following pointers until a
+// registered type is found. the value of that type is returned with it.
+func (e *Encoder) externalHandler(v reflect.Value) (externalEncoder, reflect.Value, bool) {
+	for {
+		if ext, ok := e.externalHandlers[v.Type()]; ok {
+			return ext, v, true
+		}
+
+		if ext, ok := defaultExternalEncoder(v.Type()); ok {
+			return ext, v, true
+		}
+
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return externalEncoder{}, v, false
+		}
+
+		v = v.Elem()
+	}
+}
+
+// Reset clears the reference tables of the encoder. References span every
+// value written by an Encoder, so Reset should be called (or a new Encoder
+// used) at each message boundary the peer's decoder starts afresh at.
+func (e *Encoder) Reset() {
+	e.stringRefs = nil
+	e.objectRefs = nil
+	e.objectCount = 0
+	e.traitRefs = nil
+	e.amf0Refs = nil
+	e.amf0RefCount = 0
 }
 
 type Version uint8
@@ -81,9 +187,68 @@ type Version uint8
 type Array []interface{}
 type Object map[string]interface{}
 
+// VectorInt is an amf3 Vector.<int>. Fixed is set for vectors whose length
+// cannot change.
+type VectorInt struct {
+	Fixed  bool
+	Values []int32
+}
+
+// VectorUint is an amf3 Vector.<uint>.
+type VectorUint struct {
+	Fixed  bool
+	Values []uint32
+}
+
+// VectorDouble is an amf3 Vector.<Number>.
+type VectorDouble struct {
+	Fixed  bool
+	Values []float64
+}
+
+// VectorObject is an amf3 Vector.<T> of objects. Type holds the class name of
+// T, or "*" for untyped vectors.
+type VectorObject struct {
+	Fixed  bool
+	Type   string
+	Values Array
+}
+
+// Dictionary is an amf3 flash.utils.Dictionary. Its keys may be of any type,
+// including objects, so it is kept as an ordered list of entries.
+type Dictionary struct {
+	WeakKeys bool
+	Entries  []DictionaryEntry
+}
+
+type DictionaryEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// ArrayCollection is a flex.messaging.io.ArrayCollection, an externalizable
+// wrapper around an array that flex collections are usually sent as.
+type ArrayCollection Array
+
+// ObjectProxy is a flex.messaging.io.ObjectProxy, an externalizable wrapper
+// around an object.
+type ObjectProxy Object
+
+// MixedArray is an amf3 array carrying named members alongside its dense,
+// index based portion (an ecma array in actionscript terms).
+type MixedArray struct {
+	Dense       Array
+	Associative Object
+}
+
 type TypedObject struct {
 	Type   string
 	Object Object
+
+	// Trait, when set, holds the sealed property order and dynamic flag the
+	// object was decoded with. The amf3 encoder writes sealed properties in
+	// that order and any other keys as dynamic members.
+	Trait *Trait
 }
 
 type Trait struct {
@@ -28,7 +28,7 @@ func (d *Decoder) DecodeAmf0(r io.Reader) (interface{}, error) {
 	case AMF0_UNDEFINED_MARKER:
 		return d.DecodeAmf0Undefined(r, false)
 	case AMF0_REFERENCE_MARKER:
-		return nil, Error("decode amf0: unsupported type reference")
+		return d.DecodeAmf0Reference(r, false)
 	case AMF0_ECMA_ARRAY_MARKER:
 		return d.DecodeAmf0EcmaArray(r, false)
 	case AMF0_STRICT_ARRAY_MARKER:
@@ -44,7 +44,7 @@ func (d *Decoder) DecodeAmf0(r io.Reader) (interface{}, error) {
 	case AMF0_XML_DOCUMENT_MARKER:
 		return d.DecodeAmf0XmlDocument(r, false)
 	case AMF0_TYPED_OBJECT_MARKER:
-		return d.DecodeAmf0TypedObject(r, false)
+		return d.decodeAmf0TypedObject(r)
 	case AMF0_ACMPLUS_OBJECT_MARKER:
 		return d.DecodeAmf3(r)
 	}
@@ -103,8 +103,8 @@ func (d *Decoder) DecodeAmf0String(r io.Reader, decodeMarker bool) (result strin
 		return "", Error("decode amf0: unable to decode string length: %s", err)
 	}
 
-	var bytes = make([]byte, length)
-	if bytes, err = ReadBytes(r, int(length)); err != nil {
+	bytes, err := ReadBytes(r, int(length))
+	if err != nil {
 		return "", Error("decode amf0: unable to decode string value: %s", err)
 	}
 
@@ -120,18 +120,35 @@ func (d *Decoder) DecodeAmf0Object(r io.Reader, decodeMarker bool) (Object, erro
 		return nil, err
 	}
 
+	// register the object before its values, which may refer back to it
 	result := make(Object)
 	d.refCache = append(d.refCache, result)
 
+	err := d.decodeAmf0Properties(r, func(key string, value interface{}) error {
+		result[key] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+
+}
+
+// format:
+// - loop encoded string followed by encoded value
+// - terminated with empty string followed by 1 byte 0x09
+func (d *Decoder) decodeAmf0Properties(r io.Reader, set func(key string, value interface{}) error) error {
 	for {
 		key, err := d.DecodeAmf0String(r, false)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if key == "" {
 			if err = AssertMarker(r, true, AMF0_OBJECT_END_MARKER); err != nil {
-				return nil, Error("decode amf0: expected object end marker: %s", err)
+				return Error("decode amf0: expected object end marker: %s", err)
 			}
 
 			break
@@ -139,14 +156,15 @@ func (d *Decoder) DecodeAmf0Object(r io.Reader, decodeMarker bool) (Object, erro
 
 		value, err := d.DecodeAmf0(r)
 		if err != nil {
-			return nil, Error("decode amf0: unable to decode object value: %s", err)
+			return Error("decode amf0: unable to decode object value: %s", err)
 		}
 
-		result[key] = value
+		if err = set(key, value); err != nil {
+			return Error("decode amf0: unable to set object value %s: %s", key, err)
+		}
 	}
 
-	return result, nil
-
+	return nil
 }
 
 // marker: 1 byte 0x05
@@ -165,7 +183,6 @@ func (d *Decoder) DecodeAmf0Undefined(r io.Reader, decodeMarker bool) (result in
 
 // marker: 1 byte 0x07
 // format: 2 byte big endian uint16
-/*
 func (d *Decoder) DecodeAmf0Reference(r io.Reader, decodeMarker bool) (interface{}, error) {
 	if err := AssertMarker(r, decodeMarker, AMF0_REFERENCE_MARKER); err != nil {
 		return nil, err
@@ -179,7 +196,7 @@ func (d *Decoder) DecodeAmf0Reference(r io.Reader, decodeMarker bool) (interface
 		return nil, Error("decode amf0: unable to decode reference id: %s", err)
 	}
 
-	if int(ref) > len(d.refCache) {
+	if int(ref) >= len(d.refCache) {
 		return nil, Error("decode amf0: bad reference %d (current length %d)", ref, len(d.refCache))
 	}
 
@@ -187,7 +204,6 @@ func (d *Decoder) DecodeAmf0Reference(r io.Reader, decodeMarker bool) (interface
 
 	return result, nil
 }
-*/
 
 // marker: 1 byte 0x08
 // format:
@@ -202,6 +218,9 @@ func (d *Decoder) DecodeAmf0EcmaArray(r io.Reader, decodeMarker bool) (Object, e
 
 	var length uint32
 	err := binary.Read(r, binary.BigEndian, &length)
+	if err != nil {
+		return nil, Error("decode amf0: unable to decode ecma array length: %s", err)
+	}
 
 	result, err := d.DecodeAmf0Object(r, false)
 	if err != nil {
@@ -226,6 +245,17 @@ func (d *Decoder) DecodeAmf0StrictArray(r io.Reader, decodeMarker bool) (result
 		return nil, Error("decode amf0: unable to decode strict array length: %s", err)
 	}
 
+	// register the array before its elements, which may refer back to it,
+	// so they are filled in place. when the length cannot be checked
+	// against the input, elements past the preallocated ones are appended
+	// and the array registered again once they are read.
+	size, err := lengthFor(r, length)
+	if err != nil {
+		return nil, Error("decode amf0: unable to decode strict array: %s", err)
+	}
+
+	result = make(Array, size)
+	index := len(d.refCache)
 	d.refCache = append(d.refCache, result)
 
 	for i := uint32(0); i < length; i++ {
@@ -233,8 +263,14 @@ func (d *Decoder) DecodeAmf0StrictArray(r io.Reader, decodeMarker bool) (result
 		if err != nil {
 			return nil, Error("decode amf0: unable to decode strict array object: %s", err)
 		}
-		result = append(result, tmp)
+
+		if int(i) < size {
+			result[i] = tmp
+		} else {
+			result = append(result, tmp)
+		}
 	}
+	d.refCache[index] = result
 
 	return result, nil
 }
@@ -275,8 +311,8 @@ func (d *Decoder) DecodeAmf0LongString(r io.Reader, decodeMarker bool) (result s
 		return "", Error("decode amf0: unable to decode long string length: %s", err)
 	}
 
-	var bytes = make([]byte, length)
-	if bytes, err = ReadBytes(r, int(length)); err != nil {
+	bytes, err := ReadBytes(r, int(length))
+	if err != nil {
 		return "", Error("decode amf0: unable to decode long string value: %s", err)
 	}
 
@@ -312,24 +348,68 @@ func (d *Decoder) DecodeAmf0XmlDocument(r io.Reader, decodeMarker bool) (result
 //   - loop encoded string followed by encoded value
 //   - terminated with empty string followed by 1 byte 0x09
 func (d *Decoder) DecodeAmf0TypedObject(r io.Reader, decodeMarker bool) (TypedObject, error) {
-	result := *new(TypedObject)
+	result := *NewTypedObject()
 
 	err := AssertMarker(r, decodeMarker, AMF0_TYPED_OBJECT_MARKER)
 	if err != nil {
 		return result, err
 	}
 
-	d.refCache = append(d.refCache, result)
-
 	result.Type, err = d.DecodeAmf0String(r, false)
 	if err != nil {
 		return result, Error("decode amf0: typed object unable to determine type: %s", err)
 	}
 
-	result.Object, err = d.DecodeAmf0Object(r, false)
+	return d.decodeAmf0TypedObjectValues(r, result.Type)
+}
+
+// decodeAmf0TypedObjectValues decodes the values of a typed object of
+// class cls, once the class name is read.
+func (d *Decoder) decodeAmf0TypedObjectValues(r io.Reader, cls string) (TypedObject, error) {
+	result := *NewTypedObject()
+	result.Type = cls
+
+	// register the typed object before its values, which may refer back to it
+	d.refCache = append(d.refCache, result)
+
+	err := d.decodeAmf0Properties(r, func(key string, value interface{}) error {
+		result.Object[key] = value
+		return nil
+	})
 	if err != nil {
 		return result, Error("decode amf0: typed object unable to determine object: %s", err)
 	}
 
 	return result, nil
 }
+
+// same format as DecodeAmf0TypedObject, but classes registered with
+// RegisterClassAlias decode to a pointer to their go type
+func (d *Decoder) decodeAmf0TypedObject(r io.Reader) (interface{}, error) {
+	cls, err := d.DecodeAmf0String(r, false)
+	if err != nil {
+		return nil, Error("decode amf0: typed object unable to determine type: %s", err)
+	}
+
+	ptr, ok := newClassValue(cls)
+	if !ok {
+		result, err := d.decodeAmf0TypedObjectValues(r, cls)
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	d.refCache = append(d.refCache, ptr.Interface())
+
+	var conv converter
+	err = d.decodeAmf0Properties(r, func(key string, value interface{}) error {
+		return conv.assignField(ptr.Elem(), key, value, "")
+	})
+	if err != nil {
+		return nil, Error("decode amf0: typed object unable to populate %s: %s", cls, err)
+	}
+
+	return ptr.Interface(), nil
+}
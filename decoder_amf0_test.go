@@ -2,6 +2,8 @@ package amf
 
 import (
 	"bytes"
+	"io"
+	"runtime"
 	"testing"
 )
 
@@ -246,7 +248,6 @@ func TestDecodeAmf0Undefined(t *testing.T) {
 	}
 }
 
-/*
 func TestDecodeReference(t *testing.T) {
 	buf := bytes.NewReader([]byte{0x03, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x07, 0x00, 0x00, 0x00, 0x00, 0x09})
 
@@ -266,7 +267,6 @@ func TestDecodeReference(t *testing.T) {
 		t.Errorf("expected foo value to cast to object")
 	}
 }
-*/
 
 func TestDecodeAmf0EcmaArray(t *testing.T) {
 	buf := bytes.NewReader([]byte{0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x02, 0x00, 0x03, 0x62, 0x61, 0x72, 0x00, 0x00, 0x09})
@@ -380,6 +380,57 @@ func TestDecodeAmf0StrictArray(t *testing.T) {
 	}
 }
 
+func TestDecodeAmf0StrictArrayLengthBeyondInput(t *testing.T) {
+	// the length claims 2^32 - 1 elements, but none follow
+	buf := bytes.NewReader([]byte{0x0a, 0xff, 0xff, 0xff, 0xff})
+
+	dec := new(Decoder)
+	if got, err := dec.DecodeAmf0(buf); err == nil {
+		t.Errorf("expected error, got %#v", got)
+	}
+}
+
+func TestDecodeAmf0StrictArraySelfReference(t *testing.T) {
+	data := []byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x07, 0x00, 0x00}
+
+	// from a buffer, and from a stream that cannot tell its length
+	for _, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		dec := new(Decoder)
+		got, err := dec.DecodeAmf0(r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		arr, ok := got.(Array)
+		if !ok || len(arr) != 1 {
+			t.Fatalf("expected array of 1, got %T", got)
+		}
+
+		if inner, ok := arr[0].(Array); !ok || len(inner) != 1 || &inner[0] != &arr[0] {
+			t.Errorf("expected element to be the array itself")
+		}
+	}
+}
+
+func TestDecodeAmf0LongStringLengthBeyondInput(t *testing.T) {
+	// the length claims 2^32 - 1 bytes, but few follow
+	data := []byte{0x0c, 0xff, 0xff, 0xff, 0xff, 'a', 'b', 'c'}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	dec := new(Decoder)
+	if got, err := dec.DecodeAmf0(struct{ io.Reader }{bytes.NewReader(data)}); err == nil {
+		t.Errorf("expected error, got %#v", got)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("expected allocation to follow the input, allocated %d bytes", alloc)
+	}
+}
+
 func TestDecodeAmf0Date(t *testing.T) {
 	buf := bytes.NewReader([]byte{0x0b, 0x40, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
 	expect := float64(5)
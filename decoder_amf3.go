@@ -3,6 +3,7 @@ package amf
 import (
 	"encoding/binary"
 	"io"
+	"reflect"
 	"time"
 )
 
@@ -40,6 +41,16 @@ func (d *Decoder) DecodeAmf3(r io.Reader) (interface{}, error) {
 		return d.DecodeAmf3Xml(r, false)
 	case AMF3_BYTEARRAY_MARKER:
 		return d.DecodeAmf3ByteArray(r, false)
+	case AMF3_VECTOR_INT_MARKER:
+		return d.DecodeAmf3VectorInt(r, false)
+	case AMF3_VECTOR_UINT_MARKER:
+		return d.DecodeAmf3VectorUint(r, false)
+	case AMF3_VECTOR_DOUBLE_MARKER:
+		return d.DecodeAmf3VectorDouble(r, false)
+	case AMF3_VECTOR_OBJECT_MARKER:
+		return d.DecodeAmf3VectorObject(r, false)
+	case AMF3_DICTIONARY_MARKER:
+		return d.DecodeAmf3Dictionary(r, false)
 	}
 
 	return nil, Error("decode amf3: unsupported type %d", marker)
@@ -126,12 +137,19 @@ func (d *Decoder) DecodeAmf3String(r io.Reader, decodeMarker bool) (result strin
 	}
 
 	if isRef {
+		if int(refVal) >= len(d.stringRefs) {
+			return "", Error("amf3 decode: bad string reference %d (current length %d)", refVal, len(d.stringRefs))
+		}
 		result = d.stringRefs[refVal]
 		return
 	}
 
-	buf := make([]byte, refVal)
-	_, err = r.Read(buf)
+	// the empty string is never sent by reference and has no bytes to read
+	if refVal == 0 {
+		return "", nil
+	}
+
+	buf, err := ReadBytes(r, int(refVal))
 	if err != nil {
 		return "", Error("amf3 decode: unable to read string: %s", err)
 	}
@@ -161,7 +179,12 @@ func (d *Decoder) DecodeAmf3Date(r io.Reader, decodeMarker bool) (result time.Ti
 	}
 
 	if isRef {
-		res, ok := d.objectRefs[refVal].(time.Time)
+		var ref interface{}
+		if ref, err = d.objectRef(refVal); err != nil {
+			return
+		}
+
+		res, ok := ref.(time.Time)
 		if ok != true {
 			return result, Error("amf3 decode: unable to extract time from date object references")
 		}
@@ -187,7 +210,9 @@ func (d *Decoder) DecodeAmf3Date(r io.Reader, decodeMarker bool) (result time.Ti
 // - u29 reference int. if reference, no more data.
 // - string representing associative array if present
 // - n values (length of u29)
-func (d *Decoder) DecodeAmf3Array(r io.Reader, decodeMarker bool) (result Array, err error) {
+//
+// arrays without named members decode to Array, others to MixedArray.
+func (d *Decoder) DecodeAmf3Array(r io.Reader, decodeMarker bool) (result interface{}, err error) {
 	if err = AssertMarker(r, decodeMarker, AMF3_ARRAY_MARKER); err != nil {
 		return
 	}
@@ -200,35 +225,78 @@ func (d *Decoder) DecodeAmf3Array(r io.Reader, decodeMarker bool) (result Array,
 	}
 
 	if isRef {
-		objRefId := refVal >> 1
+		var ref interface{}
+		if ref, err = d.objectRef(refVal); err != nil {
+			return
+		}
 
-		res, ok := d.objectRefs[objRefId].(Array)
-		if ok != true {
-			return result, Error("amf3 decode: unable to extract array from object references")
+		switch ref.(type) {
+		case Array, MixedArray:
+			return ref, nil
 		}
 
-		return res, err
+		return nil, Error("amf3 decode: unable to extract array from object references")
 	}
 
 	var key string
 	key, err = d.DecodeAmf3String(r, false)
 	if err != nil {
-		return result, Error("amf3 decode: unable to read key for array: %s", err)
+		return nil, Error("amf3 decode: unable to read key for array: %s", err)
 	}
 
-	if key != "" {
-		return result, Error("amf3 decode: array key is not empty, can't handle associative array")
+	// register the array before its elements, which may refer back to it,
+	// so they are filled in place. when the length cannot be checked
+	// against the input, elements past the preallocated ones are appended
+	// and the array registered again once they are read.
+	size, err := lengthFor(r, refVal)
+	if err != nil {
+		return nil, Error("amf3 decode: unable to decode array: %s", err)
+	}
+
+	dense := make(Array, size)
+	index := len(d.objectRefs)
+
+	var mixed MixedArray
+	if key == "" {
+		d.objectRefs = append(d.objectRefs, dense)
+	} else {
+		mixed = MixedArray{Dense: dense, Associative: make(Object)}
+		d.objectRefs = append(d.objectRefs, mixed)
+
+		// named members come first, terminated by an empty string
+		for key != "" {
+			mixed.Associative[key], err = d.DecodeAmf3(r)
+			if err != nil {
+				return nil, Error("amf3 decode: array member %s could not be decoded: %s", key, err)
+			}
+
+			key, err = d.DecodeAmf3String(r, false)
+			if err != nil {
+				return nil, Error("amf3 decode: unable to read key for array: %s", err)
+			}
+		}
 	}
 
 	for i := uint32(0); i < refVal; i++ {
 		tmp, err := d.DecodeAmf3(r)
 		if err != nil {
-			return result, Error("amf3 decode: array element could not be decoded: %s", err)
+			return nil, Error("amf3 decode: array element could not be decoded: %s", err)
+		}
+
+		if int(i) < size {
+			dense[i] = tmp
+		} else {
+			dense = append(dense, tmp)
 		}
-		result = append(result, tmp)
 	}
 
-	d.objectRefs = append(d.objectRefs, result)
+	if mixed.Associative != nil {
+		mixed.Dense = dense
+		result = mixed
+	} else {
+		result = dense
+	}
+	d.objectRefs[index] = result
 
 	return
 }
@@ -248,9 +316,7 @@ func (d *Decoder) DecodeAmf3Object(r io.Reader, decodeMarker bool) (result inter
 
 	// if this is a object reference only, grab it and return it
 	if isRef {
-		objRefId := refVal >> 1
-
-		return d.objectRefs[objRefId], nil
+		return d.objectRef(refVal)
 	}
 
 	// each type has traits that are cached, if the peer sent a reference
@@ -261,6 +327,9 @@ func (d *Decoder) DecodeAmf3Object(r io.Reader, decodeMarker bool) (result inter
 
 	if traitIsRef {
 		traitRef := refVal >> 1
+		if int(traitRef) >= len(d.traitRefs) {
+			return nil, Error("amf3 decode: bad trait reference %d (current length %d)", traitRef, len(d.traitRefs))
+		}
 		trait = d.traitRefs[traitRef]
 
 	} else {
@@ -289,34 +358,29 @@ func (d *Decoder) DecodeAmf3Object(r io.Reader, decodeMarker bool) (result inter
 		d.traitRefs = append(d.traitRefs, trait)
 	}
 
-	d.objectRefs = append(d.objectRefs, result)
+	// claim a slot in the object reference table before reading any members,
+	// so that indexes of nested objects line up with the encoder's
+	refIndex := len(d.objectRefs)
+	d.objectRefs = append(d.objectRefs, nil)
 
 	// objects can be externalizable, meaning that the system has no concrete understanding of
 	// their properties or how they are encoded. in that case, we need to find and delegate behavior
 	// to the right object.
 	if trait.Externalizable {
 		switch trait.Type {
-		case "DSA": // AsyncMessageExt
-			result, err = d.decodeAsyncMessageExt(r)
-			if err != nil {
-				return result, Error("amf3 decode: unable to decode dsa: %s", err)
-			}
-		case "DSK": // AcknowledgeMessageExt
-			result, err = d.decodeAcknowledgeMessageExt(r)
-			if err != nil {
-				return result, Error("amf3 decode: unable to decode dsk: %s", err)
-			}
 		case "flex.messaging.io.ArrayCollection":
 			result, err = d.decodeArrayCollection(r)
 			if err != nil {
 				return result, Error("amf3 decode: unable to decode ac: %s", err)
 			}
-
-			// store an extra reference to array collection container
-			d.objectRefs = append(d.objectRefs, result)
+		case "flex.messaging.io.ObjectProxy":
+			result, err = d.decodeObjectProxy(r)
+			if err != nil {
+				return result, Error("amf3 decode: unable to decode object proxy: %s", err)
+			}
 
 		default:
-			fn, ok := d.externalHandlers[trait.Type]
+			fn, ok := d.externalHandler(trait.Type)
 			if ok {
 				result, err = fn(d, r)
 				if err != nil {
@@ -327,14 +391,47 @@ func (d *Decoder) DecodeAmf3Object(r io.Reader, decodeMarker bool) (result inter
 			}
 		}
 
+		d.objectRefs[refIndex] = result
+
 		return result, err
 	}
 
+	// build the container up front and register it, then fill it in place so
+	// that members referring back to it (or to an ancestor) resolve correctly.
+	// classes registered with RegisterClassAlias decode to their go type,
+	// other typed objects keep their class name and trait so they can be
+	// re-encoded with the same shape.
+	var obj Object
+	var inst reflect.Value
+	var conv converter
+
+	if ptr, ok := newClassValue(trait.Type); ok {
+		inst = ptr.Elem()
+		result = ptr.Interface()
+	} else if trait.Type != "" {
+		to := *NewTypedObject()
+		to.Type = trait.Type
+		to.Trait = &trait
+		obj = to.Object
+		result = to
+	} else {
+		obj = make(Object)
+		result = obj
+	}
+
+	d.objectRefs[refIndex] = result
+
 	var key string
 	var val interface{}
-	var obj Object
 
-	obj = make(Object)
+	setProperty := func(k string, v interface{}) error {
+		if inst.IsValid() {
+			return conv.assignField(inst, k, v, "")
+		}
+
+		obj[k] = v
+		return nil
+	}
 
 	// non-externalizable objects have property keys in traits, iterate through them
 	// and add the read values to the object
@@ -344,7 +441,9 @@ func (d *Decoder) DecodeAmf3Object(r io.Reader, decodeMarker bool) (result inter
 			return result, Error("amf3 decode: unable to decode object property: %s", err)
 		}
 
-		obj[key] = val
+		if err = setProperty(key, val); err != nil {
+			return result, Error("amf3 decode: unable to set property %s of %s: %s", key, trait.Type, err)
+		}
 	}
 
 	// if an object is dynamic, it can have extra key/value data at the end. in this case,
@@ -363,12 +462,12 @@ func (d *Decoder) DecodeAmf3Object(r io.Reader, decodeMarker bool) (result inter
 				return result, Error("amf3 decode: unable to decode dynamic value: %s", err)
 			}
 
-			obj[key] = val
+			if err = setProperty(key, val); err != nil {
+				return result, Error("amf3 decode: unable to set property %s of %s: %s", key, trait.Type, err)
+			}
 		}
 	}
 
-	result = obj
-
 	return
 }
 
@@ -398,7 +497,10 @@ func (d *Decoder) DecodeAmf3Xml(r io.Reader, decodeMarker bool) (result string,
 
 	if isRef {
 		var ok bool
-		buf := d.objectRefs[refVal]
+		var buf interface{}
+		if buf, err = d.objectRef(refVal); err != nil {
+			return
+		}
 		result, ok = buf.(string)
 		if ok != true {
 			return "", Error("amf3 decode: cannot coerce object reference into xml string")
@@ -407,8 +509,7 @@ func (d *Decoder) DecodeAmf3Xml(r io.Reader, decodeMarker bool) (result string,
 		return
 	}
 
-	buf := make([]byte, refVal)
-	_, err = r.Read(buf)
+	buf, err := ReadBytes(r, int(refVal))
 	if err != nil {
 		return "", Error("amf3 decode: unable to read xml string: %s", err)
 	}
@@ -440,7 +541,11 @@ func (d *Decoder) DecodeAmf3ByteArray(r io.Reader, decodeMarker bool) (result []
 
 	if isRef {
 		var ok bool
-		result, ok = d.objectRefs[refVal].([]byte)
+		var ref interface{}
+		if ref, err = d.objectRef(refVal); err != nil {
+			return
+		}
+		result, ok = ref.([]byte)
 		if ok != true {
 			return result, Error("amf3 decode: unable to convert object ref to bytes")
 		}
@@ -448,8 +553,7 @@ func (d *Decoder) DecodeAmf3ByteArray(r io.Reader, decodeMarker bool) (result []
 		return
 	}
 
-	result = make([]byte, refVal)
-	_, err = r.Read(result)
+	result, err = ReadBytes(r, int(refVal))
 	if err != nil {
 		return result, Error("amf3 decode: unable to read bytearray: %s", err)
 	}
@@ -459,6 +563,291 @@ func (d *Decoder) DecodeAmf3ByteArray(r io.Reader, decodeMarker bool) (result []
 	return
 }
 
+// marker: 1 byte 0x0d
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - n (length of u29) 4 byte big endian int32 values
+func (d *Decoder) DecodeAmf3VectorInt(r io.Reader, decodeMarker bool) (result VectorInt, err error) {
+	if err = AssertMarker(r, decodeMarker, AMF3_VECTOR_INT_MARKER); err != nil {
+		return
+	}
+
+	isRef, ref, length, fixed, err := d.decodeAmf3VectorHeader(r)
+	if err != nil {
+		return
+	}
+
+	if isRef {
+		res, ok := ref.(VectorInt)
+		if ok != true {
+			return result, Error("amf3 decode: unable to extract int vector from object references")
+		}
+
+		return res, nil
+	}
+
+	// the length comes from the input, so values are appended as they are
+	// read rather than preallocated
+	result = VectorInt{Fixed: fixed, Values: make([]int32, 0, capacityFor(length))}
+	for i := uint32(0); i < length; i++ {
+		var v int32
+		if err = binary.Read(r, binary.BigEndian, &v); err != nil {
+			return result, Error("amf3 decode: unable to read int vector values: %s", err)
+		}
+		result.Values = append(result.Values, v)
+	}
+
+	d.objectRefs = append(d.objectRefs, result)
+
+	return
+}
+
+// marker: 1 byte 0x0e
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - n (length of u29) 4 byte big endian uint32 values
+func (d *Decoder) DecodeAmf3VectorUint(r io.Reader, decodeMarker bool) (result VectorUint, err error) {
+	if err = AssertMarker(r, decodeMarker, AMF3_VECTOR_UINT_MARKER); err != nil {
+		return
+	}
+
+	isRef, ref, length, fixed, err := d.decodeAmf3VectorHeader(r)
+	if err != nil {
+		return
+	}
+
+	if isRef {
+		res, ok := ref.(VectorUint)
+		if ok != true {
+			return result, Error("amf3 decode: unable to extract uint vector from object references")
+		}
+
+		return res, nil
+	}
+
+	// the length comes from the input, so values are appended as they are
+	// read rather than preallocated
+	result = VectorUint{Fixed: fixed, Values: make([]uint32, 0, capacityFor(length))}
+	for i := uint32(0); i < length; i++ {
+		var v uint32
+		if err = binary.Read(r, binary.BigEndian, &v); err != nil {
+			return result, Error("amf3 decode: unable to read uint vector values: %s", err)
+		}
+		result.Values = append(result.Values, v)
+	}
+
+	d.objectRefs = append(d.objectRefs, result)
+
+	return
+}
+
+// marker: 1 byte 0x0f
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - n (length of u29) 8 byte big endian float64 values
+func (d *Decoder) DecodeAmf3VectorDouble(r io.Reader, decodeMarker bool) (result VectorDouble, err error) {
+	if err = AssertMarker(r, decodeMarker, AMF3_VECTOR_DOUBLE_MARKER); err != nil {
+		return
+	}
+
+	isRef, ref, length, fixed, err := d.decodeAmf3VectorHeader(r)
+	if err != nil {
+		return
+	}
+
+	if isRef {
+		res, ok := ref.(VectorDouble)
+		if ok != true {
+			return result, Error("amf3 decode: unable to extract double vector from object references")
+		}
+
+		return res, nil
+	}
+
+	// the length comes from the input, so values are appended as they are
+	// read rather than preallocated
+	result = VectorDouble{Fixed: fixed, Values: make([]float64, 0, capacityFor(length))}
+	for i := uint32(0); i < length; i++ {
+		var v float64
+		if err = binary.Read(r, binary.BigEndian, &v); err != nil {
+			return result, Error("amf3 decode: unable to read double vector values: %s", err)
+		}
+		result.Values = append(result.Values, v)
+	}
+
+	d.objectRefs = append(d.objectRefs, result)
+
+	return
+}
+
+// marker: 1 byte 0x10
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - string with the class name of the elements, "*" if untyped
+// - n (length of u29) values
+func (d *Decoder) DecodeAmf3VectorObject(r io.Reader, decodeMarker bool) (result VectorObject, err error) {
+	if err = AssertMarker(r, decodeMarker, AMF3_VECTOR_OBJECT_MARKER); err != nil {
+		return
+	}
+
+	isRef, ref, length, fixed, err := d.decodeAmf3VectorHeader(r)
+	if err != nil {
+		return
+	}
+
+	if isRef {
+		res, ok := ref.(VectorObject)
+		if ok != true {
+			return result, Error("amf3 decode: unable to extract object vector from object references")
+		}
+
+		return res, nil
+	}
+
+	result = VectorObject{Fixed: fixed}
+	result.Type, err = d.DecodeAmf3String(r, false)
+	if err != nil {
+		return result, Error("amf3 decode: unable to read object vector type: %s", err)
+	}
+
+	// register the vector before its elements, which may refer back to it,
+	// so they are filled in place. when the length cannot be checked
+	// against the input, elements past the preallocated ones are appended
+	// and the vector registered again once they are read.
+	size, err := lengthFor(r, length)
+	if err != nil {
+		return result, Error("amf3 decode: unable to decode object vector: %s", err)
+	}
+
+	result.Values = make(Array, size)
+	index := len(d.objectRefs)
+	d.objectRefs = append(d.objectRefs, result)
+
+	for i := uint32(0); i < length; i++ {
+		v, err := d.DecodeAmf3(r)
+		if err != nil {
+			return result, Error("amf3 decode: object vector element could not be decoded: %s", err)
+		}
+
+		if int(i) < size {
+			result.Values[i] = v
+		} else {
+			result.Values = append(result.Values, v)
+		}
+	}
+	d.objectRefs[index] = result
+
+	return
+}
+
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+//
+// if the vector was sent before, isRef is set and ref holds it.
+func (d *Decoder) decodeAmf3VectorHeader(r io.Reader) (isRef bool, ref interface{}, length uint32, fixed bool, err error) {
+	var refVal uint32
+	isRef, refVal, err = d.decodeReferenceInt(r)
+	if err != nil {
+		return false, nil, 0, false, Error("amf3 decode: unable to decode vector reference and length: %s", err)
+	}
+
+	if isRef {
+		ref, err = d.objectRef(refVal)
+		return
+	}
+
+	b, err := ReadByte(r)
+	if err != nil {
+		return false, nil, 0, false, Error("amf3 decode: unable to read vector fixed flag: %s", err)
+	}
+
+	return false, nil, refVal, b != 0x00, nil
+}
+
+// marker: 1 byte 0x11
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte weak keys flag
+// - n (length of u29) encoded keys, each followed by an encoded value
+func (d *Decoder) DecodeAmf3Dictionary(r io.Reader, decodeMarker bool) (result Dictionary, err error) {
+	if err = AssertMarker(r, decodeMarker, AMF3_DICTIONARY_MARKER); err != nil {
+		return
+	}
+
+	isRef, refVal, err := d.decodeReferenceInt(r)
+	if err != nil {
+		return result, Error("amf3 decode: unable to decode dictionary reference and length: %s", err)
+	}
+
+	if isRef {
+		var ref interface{}
+		if ref, err = d.objectRef(refVal); err != nil {
+			return
+		}
+
+		res, ok := ref.(Dictionary)
+		if ok != true {
+			return result, Error("amf3 decode: unable to extract dictionary from object references")
+		}
+
+		return res, nil
+	}
+
+	b, err := ReadByte(r)
+	if err != nil {
+		return result, Error("amf3 decode: unable to read dictionary weak keys flag: %s", err)
+	}
+
+	// register the dictionary before its entries, which may refer back to it,
+	// so they are filled in place. when the length cannot be checked
+	// against the input, entries past the preallocated ones are appended
+	// and the dictionary registered again once they are read.
+	size, err := lengthFor(r, refVal)
+	if err != nil {
+		return result, Error("amf3 decode: unable to decode dictionary: %s", err)
+	}
+
+	result = Dictionary{WeakKeys: b != 0x00, Entries: make([]DictionaryEntry, size)}
+	index := len(d.objectRefs)
+	d.objectRefs = append(d.objectRefs, result)
+
+	for i := uint32(0); i < refVal; i++ {
+		var entry DictionaryEntry
+
+		entry.Key, err = d.DecodeAmf3(r)
+		if err != nil {
+			return result, Error("amf3 decode: dictionary key could not be decoded: %s", err)
+		}
+
+		entry.Value, err = d.DecodeAmf3(r)
+		if err != nil {
+			return result, Error("amf3 decode: dictionary value could not be decoded: %s", err)
+		}
+
+		if int(i) < size {
+			result.Entries[i] = entry
+		} else {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	d.objectRefs[index] = result
+
+	return
+}
+
+func (d *Decoder) objectRef(ref uint32) (interface{}, error) {
+	if int(ref) >= len(d.objectRefs) {
+		return nil, Error("amf3 decode: bad object reference %d (current length %d)", ref, len(d.objectRefs))
+	}
+
+	return d.objectRefs[ref], nil
+}
+
 func (d *Decoder) decodeU29(r io.Reader) (result uint32, err error) {
 	var b byte
 
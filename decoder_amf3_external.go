@@ -1,72 +1,59 @@
 package amf
 
 import (
-	"fmt"
 	"io"
-	"math"
 )
 
-// Abstract external boilerplate
-func (d *Decoder) decodeAbstractMessage(r io.Reader) (result Object, err error) {
-	result = make(Object)
-
-	if err = d.decodeExternal(r, &result,
-		[]string{"body", "clientId", "destination", "headers", "messageId", "timeStamp", "timeToLive"},
-		[]string{"clientIdBytes", "messageIdBytes"}); err != nil {
-		return result, Error("unable to decode abstract external: %s", err)
-	}
-
-	return
-}
-
-// DSA
-func (d *Decoder) decodeAsyncMessageExt(r io.Reader) (result Object, err error) {
-	return d.decodeAsyncMessage(r)
-}
-func (d *Decoder) decodeAsyncMessage(r io.Reader) (result Object, err error) {
-	result, err = d.decodeAbstractMessage(r)
+// flex.messaging.io.ArrayCollection
+func (d *Decoder) decodeArrayCollection(r io.Reader) (interface{}, error) {
+	result, err := d.DecodeAmf3(r)
 	if err != nil {
-		return result, Error("unable to decode abstract for async: %s", err)
+		return result, Error("cannot decode child of array collection: %s", err)
 	}
 
-	if err = d.decodeExternal(r, &result, []string{"correlationId", "correlationIdBytes"}); err != nil {
-		return result, Error("unable to decode async external: %s", err)
+	if d.PreserveCollections {
+		var ac ArrayCollection
+		if err = Convert(result, &ac); err != nil {
+			return result, Error("cannot convert child of array collection: %s", err)
+		}
+
+		return ac, nil
 	}
 
-	return
+	return result, nil
 }
 
-// DSK
-func (d *Decoder) decodeAcknowledgeMessageExt(r io.Reader) (result Object, err error) {
-	return d.decodeAcknowledgeMessage(r)
-}
-func (d *Decoder) decodeAcknowledgeMessage(r io.Reader) (result Object, err error) {
-	result, err = d.decodeAsyncMessage(r)
+// flex.messaging.io.ObjectProxy
+func (d *Decoder) decodeObjectProxy(r io.Reader) (interface{}, error) {
+	result, err := d.DecodeAmf3(r)
 	if err != nil {
-		return result, Error("unable to decode async for ack: %s", err)
-	}
-
-	if err = d.decodeExternal(r, &result); err != nil {
-		return result, Error("unable to decode ack external: %s", err)
+		return result, Error("cannot decode child of object proxy: %s", err)
 	}
 
-	return
-}
+	if d.PreserveCollections {
+		var op ObjectProxy
+		if err = Convert(result, &op); err != nil {
+			return result, Error("cannot convert child of object proxy: %s", err)
+		}
 
-// flex.messaging.io.ArrayCollection
-func (d *Decoder) decodeArrayCollection(r io.Reader) (interface{}, error) {
-	result, err := d.DecodeAmf3(r)
-	if err != nil {
-		return result, Error("cannot decode child of array collection: %s", err)
+		return op, nil
 	}
 
 	return result, nil
 }
 
-func (d *Decoder) decodeExternal(r io.Reader, obj *Object, fieldSets ...[]string) (err error) {
+// decodeMessageFields reads one level of a flex message written by
+// writeExternal. fields holds, for each flag byte, pointers to the members
+// stored under its bits in order. values under bits without a member are
+// read and discarded, as newer peers may send members we don't know about.
+//
+// format:
+// - flag bytes, the high bit of each set if another follows
+// - for each set bit, an encoded value
+func (d *Decoder) decodeMessageFields(r io.Reader, fields ...[]interface{}) (err error) {
 	var flagSet []uint8
 	var reservedPosition uint8
-	var fieldNames []string
+	var fieldPtrs []interface{}
 
 	flagSet, err = readFlags(r)
 	if err != nil {
@@ -74,34 +61,33 @@ func (d *Decoder) decodeExternal(r io.Reader, obj *Object, fieldSets ...[]string
 	}
 
 	for i, flags := range flagSet {
-		if i < len(fieldSets) {
-			fieldNames = fieldSets[i]
+		if i < len(fields) {
+			fieldPtrs = fields[i]
 		} else {
-			fieldNames = []string{}
+			fieldPtrs = nil
 		}
 
-		reservedPosition = uint8(len(fieldNames))
+		reservedPosition = uint8(len(fieldPtrs))
 
-		for p, field := range fieldNames {
-			flagBit := uint8(math.Exp2(float64(p)))
-			if (flags & flagBit) != 0 {
-				tmp, err := d.DecodeAmf3(r)
-				if err != nil {
-					return Error("unable to decode external field %s %d %d (%#v): %s", field, i, p, flagSet, err)
-				}
-				(*obj)[field] = tmp
+		for p, ptr := range fieldPtrs {
+			if (flags>>uint(p))&0x01 == 0 {
+				continue
+			}
+
+			tmp, err := d.DecodeAmf3(r)
+			if err != nil {
+				return Error("unable to decode external field %d %d (%#v): %s", i, p, flagSet, err)
+			}
+
+			if err = Convert(tmp, ptr); err != nil {
+				return Error("unable to set external field %d %d: %s", i, p, err)
 			}
 		}
 
-		if (flags >> reservedPosition) != 0 {
-			for j := reservedPosition; j < 6; j++ {
-				if ((flags >> j) & 0x01) != 0 {
-					field := fmt.Sprintf("extra_%d_%d", i, j)
-					tmp, err := d.DecodeAmf3(r)
-					if err != nil {
-						return Error("unable to decode post-external field %d %d (%#v): %s", i, j, flagSet, err)
-					}
-					(*obj)[field] = tmp
+		for j := reservedPosition; j < 6; j++ {
+			if ((flags >> j) & 0x01) != 0 {
+				if _, err := d.DecodeAmf3(r); err != nil {
+					return Error("unable to decode post-external field %d %d (%#v): %s", i, j, flagSet, err)
 				}
 			}
 		}
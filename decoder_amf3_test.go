@@ -2,6 +2,9 @@ package amf
 
 import (
 	"bytes"
+	"io"
+	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -179,11 +182,16 @@ func TestDecodeAmf3Array(t *testing.T) {
 
 	dec := new(Decoder)
 	expect := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
-	got, err := dec.DecodeAmf3Array(buf, true)
+	res, err := dec.DecodeAmf3Array(buf, true)
 	if err != nil {
 		t.Errorf("err: %s", err)
 	}
 
+	got, ok := res.(Array)
+	if !ok {
+		t.Fatalf("expected Array, got %T", res)
+	}
+
 	for i, v := range expect {
 		if got[i] != v {
 			t.Error("expected array element %d to be %v, got %v", i, v, got[i])
@@ -191,6 +199,182 @@ func TestDecodeAmf3Array(t *testing.T) {
 	}
 }
 
+func TestDecodeAmf3MixedArray(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x09, 0x03,
+		0x03, 'a', 0x04, 0x01,
+		0x01,
+		0x06, 0x03, 'x',
+	})
+
+	dec := new(Decoder)
+	got, err := dec.DecodeAmf3Array(buf, true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expect := MixedArray{Dense: Array{"x"}, Associative: Object{"a": int32(1)}}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+}
+
+func TestDecodeAmf3ArrayLengthBeyondInput(t *testing.T) {
+	// the length claims 2^28 - 1 elements, but none follow
+	buf := bytes.NewReader([]byte{0x09, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	dec := new(Decoder)
+	if got, err := dec.DecodeAmf3(buf); err == nil {
+		t.Errorf("expected error, got %#v", got)
+	}
+}
+
+func TestDecodeAmf3ArraySelfReference(t *testing.T) {
+	data := []byte{0x09, 0x03, 0x01, 0x09, 0x00}
+
+	// from a buffer, and from a stream that cannot tell its length
+	for _, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		dec := new(Decoder)
+		got, err := dec.DecodeAmf3(r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		arr, ok := got.(Array)
+		if !ok || len(arr) != 1 {
+			t.Fatalf("expected array of 1, got %T", got)
+		}
+
+		if inner, ok := arr[0].(Array); !ok || len(inner) != 1 || &inner[0] != &arr[0] {
+			t.Errorf("expected element to be the array itself")
+		}
+	}
+}
+
+func TestDecodeAmf3StringLengthBeyondInput(t *testing.T) {
+	// each length claims 2^28 - 1 bytes, but few follow
+	for _, marker := range []byte{0x06, 0x07, 0x0b, 0x0c} {
+		data := []byte{marker, 0xff, 0xff, 0xff, 0xff, 'a', 'b', 'c'}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		dec := new(Decoder)
+		if got, err := dec.DecodeAmf3(struct{ io.Reader }{bytes.NewReader(data)}); err == nil {
+			t.Errorf("expected error for marker 0x%02x, got %#v", marker, got)
+		}
+
+		runtime.ReadMemStats(&after)
+
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+			t.Errorf("expected allocation to follow the input for marker 0x%02x, allocated %d bytes", marker, alloc)
+		}
+	}
+}
+
+func TestDecodeAmf3Vector(t *testing.T) {
+	buf := bytes.NewReader([]byte{
+		0x0d, 0x05, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff,
+		0x0e, 0x03, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x0f, 0x03, 0x00, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x10, 0x03, 0x00, 0x03, '*', 0x06, 0x03, 'x',
+		0x0d, 0x00,
+	})
+
+	dec := new(Decoder)
+	expect := []interface{}{
+		VectorInt{Fixed: true, Values: []int32{1, -1}},
+		VectorUint{Values: []uint32{0xffffffff}},
+		VectorDouble{Values: []float64{1.5}},
+		VectorObject{Type: "*", Values: Array{"x"}},
+		VectorInt{Fixed: true, Values: []int32{1, -1}},
+	}
+
+	for i, v := range expect {
+		got, err := dec.DecodeAmf3(buf)
+		if err != nil {
+			t.Fatalf("err decoding vector %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(v, got) {
+			t.Errorf("expected vector %d to be %#v, got %#v", i, v, got)
+		}
+	}
+}
+
+func TestDecodeAmf3VectorLengthBeyondInput(t *testing.T) {
+	// each length claims 2^28 - 1 elements, but none follow
+	for _, marker := range []byte{0x0d, 0x0e, 0x0f, 0x10, 0x11} {
+		buf := bytes.NewReader([]byte{marker, 0xff, 0xff, 0xff, 0xff, 0x00})
+
+		dec := new(Decoder)
+		if got, err := dec.DecodeAmf3(buf); err == nil {
+			t.Errorf("expected error for marker 0x%02x, got %#v", marker, got)
+		}
+	}
+}
+
+func TestDecodeAmf3Dictionary(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x11, 0x05, 0x01,
+		0x04, 0x01, 0x06, 0x03, 'a',
+		0x0a, 0x0b, 0x01, 0x01, 0x02,
+	})
+
+	dec := new(Decoder)
+	got, err := dec.DecodeAmf3(buf)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expect := Dictionary{WeakKeys: true, Entries: []DictionaryEntry{
+		{Key: int32(1), Value: "a"},
+		{Key: Object{}, Value: false},
+	}}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+}
+
+func TestDecodeAmf3VectorSelfReference(t *testing.T) {
+	data := []byte{0x10, 0x03, 0x00, 0x01, 0x10, 0x00}
+
+	for _, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		dec := new(Decoder)
+		got, err := dec.DecodeAmf3(r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		vec, ok := got.(VectorObject)
+		if !ok || len(vec.Values) != 1 {
+			t.Fatalf("expected object vector of 1, got %T", got)
+		}
+
+		if inner, ok := vec.Values[0].(VectorObject); !ok || len(inner.Values) != 1 || &inner.Values[0] != &vec.Values[0] {
+			t.Errorf("expected element to be the vector itself")
+		}
+	}
+}
+
+func TestDecodeAmf3DictionarySelfReference(t *testing.T) {
+	data := []byte{0x11, 0x03, 0x00, 0x04, 0x01, 0x11, 0x00}
+
+	for _, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		dec := new(Decoder)
+		got, err := dec.DecodeAmf3(r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		dict, ok := got.(Dictionary)
+		if !ok || len(dict.Entries) != 1 {
+			t.Fatalf("expected dictionary of 1, got %T", got)
+		}
+
+		if inner, ok := dict.Entries[0].Value.(Dictionary); !ok || len(inner.Entries) != 1 || &inner.Entries[0] != &dict.Entries[0] {
+			t.Errorf("expected value to be the dictionary itself")
+		}
+	}
+}
+
 func TestDecodeAmf3Object(t *testing.T) {
 	buf := bytes.NewReader([]byte{
 		0x0a, 0x23, 0x1f, 'o', 'r', 'g', '.', 'a',
@@ -205,16 +389,198 @@ func TestDecodeAmf3Object(t *testing.T) {
 		t.Errorf("err: %s", err)
 	}
 
-	to, ok := got.(Object)
+	to, ok := got.(TypedObject)
 	if ok != true {
 		t.Error("unable to cast object as typed object")
 	}
 
-	if to["foo"] != "bar" {
-		t.Error("expected foo to be bar, got: %+v", to["foo"])
+	if to.Type != "org.amf.ASClass" {
+		t.Errorf("expected type to be org.amf.ASClass, got: %s", to.Type)
+	}
+
+	if to.Object["foo"] != "bar" {
+		t.Errorf("expected foo to be bar, got: %+v", to.Object["foo"])
+	}
+
+	if to.Object["baz"] != nil {
+		t.Errorf("expected baz to be nil, got: %+v", to.Object["baz"])
+	}
+}
+
+func TestDecodeAmf3TypedObjectTrait(t *testing.T) {
+	expect := []byte{
+		0x0a, 0x2b, 0x1f, 'o', 'r', 'g', '.', 'a',
+		'm', 'f', '.', 'A', 'S', 'C', 'l', 'a',
+		's', 's', 0x07, 'f', 'o', 'o', 0x07, 'b',
+		'a', 'z', 0x06, 0x07, 'b', 'a', 'r', 0x01,
+		0x07, 'q', 'u', 'x', 0x03, 0x01,
+	}
+
+	dec := new(Decoder)
+	got, err := dec.DecodeAmf3(bytes.NewReader(expect))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	to, ok := got.(TypedObject)
+	if ok != true {
+		t.Fatalf("unable to cast object as typed object: %#v", got)
+	}
+
+	if to.Trait == nil || !to.Trait.Dynamic {
+		t.Fatalf("expected dynamic trait, got: %+v", to.Trait)
+	}
+
+	if len(to.Trait.Properties) != 2 || to.Trait.Properties[0] != "foo" || to.Trait.Properties[1] != "baz" {
+		t.Errorf("expected sealed properties [foo baz], got: %+v", to.Trait.Properties)
+	}
+
+	if to.Object["qux"] != true {
+		t.Errorf("expected dynamic qux to be true, got: %+v", to.Object["qux"])
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = new(Encoder).EncodeAmf3(buf, to); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected re-encoded buffer:\n%#v\ngot:\n%#v", expect, buf.Bytes())
+	}
+}
+
+type decodeAmf3Node struct {
+	Name     string            `amf:"name"`
+	Parent   *decodeAmf3Node   `amf:"parent"`
+	Children []*decodeAmf3Node `amf:"children"`
+}
+
+func init() {
+	RegisterClassAlias("org.amf.Node", decodeAmf3Node{})
+}
+
+func TestDecodeAmf3SelfReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{
+		0x0a, 0x13, 0x01, 0x09, 's', 'e', 'l', 'f',
+		0x0a, 0x00,
+	})
+
+	dec := new(Decoder)
+	got, err := dec.DecodeAmf3(buf)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj, ok := got.(Object)
+	if ok != true {
+		t.Fatalf("expected object, got %#v", got)
+	}
+
+	self, ok := obj["self"].(Object)
+	if ok != true || reflect.ValueOf(self).Pointer() != reflect.ValueOf(obj).Pointer() {
+		t.Errorf("expected self to refer to the object itself, got %#v", obj["self"])
+	}
+
+	type selfRef struct {
+		Self *selfRef `amf:"self"`
+	}
+
+	var s selfRef
+	if err = Convert(obj, &s); err != nil {
+		t.Fatalf("convert: %s", err)
+	}
+
+	if s.Self == nil || s.Self.Self != s.Self {
+		t.Errorf("expected converted struct to refer to itself")
+	}
+}
+
+func TestDecodeAmf3SharedReference(t *testing.T) {
+	obj := Object{"a": "b"}
+	list := Array{"x"}
+
+	res, err := EncodeAndDecode(Array{obj, list, obj, list}, 3)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	arr, ok := res.(Array)
+	if ok != true || len(arr) != 4 {
+		t.Fatalf("expected array of 4, got %#v", res)
+	}
+
+	if reflect.ValueOf(arr[0]).Pointer() != reflect.ValueOf(arr[2]).Pointer() {
+		t.Errorf("expected elements 0 and 2 to be the same object")
+	}
+
+	if !reflect.DeepEqual(arr[1], Array{"x"}) || !reflect.DeepEqual(arr[3], Array{"x"}) {
+		t.Errorf("expected elements 1 and 3 to be the shared array, got %#v", arr)
+	}
+}
+
+func TestDecodeAmf3CyclicClass(t *testing.T) {
+	root := &decodeAmf3Node{Name: "root"}
+	root.Children = []*decodeAmf3Node{
+		{Name: "a", Parent: root},
+		{Name: "b", Parent: root},
+	}
+
+	res, err := EncodeAndDecode(root, 3)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	got, ok := res.(*decodeAmf3Node)
+	if ok != true {
+		t.Fatalf("expected *decodeAmf3Node, got %#v", res)
+	}
+
+	if len(got.Children) != 2 || got.Children[1].Name != "b" {
+		t.Fatalf("unexpected children %#v", got.Children)
+	}
+
+	for _, child := range got.Children {
+		if child.Parent != got {
+			t.Errorf("expected child %s to point back to root", child.Name)
+		}
+	}
+}
+
+func TestDecodeAmf3BadReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x02})
+
+	_, err := new(Decoder).DecodeAmf3(buf)
+	if err == nil {
+		t.Errorf("expected error for dangling object reference")
+	}
+}
+
+func TestDecodeAmf3PreserveCollections(t *testing.T) {
+	val := Array{ArrayCollection{"a"}, ObjectProxy{"b": "c"}}
+
+	buf := new(bytes.Buffer)
+	if _, err := new(Encoder).EncodeAmf3(buf, val); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+	data := buf.Bytes()
+
+	got, err := new(Decoder).DecodeAmf3(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	expect := Array{Array{"a"}, Object{"b": "c"}}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+
+	dec := &Decoder{PreserveCollections: true}
+	got, err = dec.DecodeAmf3(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %s", err)
 	}
 
-	if to["baz"] != nil {
-		t.Error("expected baz to be nil, got: %+v", to["baz"])
+	if !reflect.DeepEqual(val, got) {
+		t.Errorf("expected %#v, got %#v", val, got)
 	}
 }
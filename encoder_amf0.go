@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"io"
 	"reflect"
+	"strconv"
+	"time"
 )
 
 // amf0 polymorphic router
@@ -17,6 +19,42 @@ func (e *Encoder) EncodeAmf0(w io.Writer, val interface{}) (int, error) {
 		return e.EncodeAmf0Null(w, true)
 	}
 
+	if tm, ok := val.(time.Time); ok {
+		return e.EncodeAmf0Date(w, tm, true)
+	}
+
+	if to, ok := val.(TypedObject); ok {
+		return e.EncodeAmf0TypedObject(w, to, true)
+	}
+
+	// amf0 has no dense portion in ecma arrays, so indexes become names
+	if mixed, ok := val.(MixedArray); ok {
+		obj := make(Object, len(mixed.Dense)+len(mixed.Associative))
+		for k, v := range mixed.Associative {
+			obj[k] = v
+		}
+		for i, v := range mixed.Dense {
+			obj[strconv.Itoa(i)] = v
+		}
+		return e.EncodeAmf0EcmaArray(w, obj, true)
+	}
+
+	// types without an amf0 counterpart switch to amf3
+	_, _, switchToAmf3 := e.externalHandler(v)
+	switch val.(type) {
+	case VectorInt, VectorUint, VectorDouble, VectorObject, Dictionary, ArrayCollection, ObjectProxy:
+		switchToAmf3 = true
+	}
+
+	if switchToAmf3 {
+		if err := e.EncodeAmf0Amf3Marker(w); err != nil {
+			return 0, err
+		}
+
+		n, err := e.EncodeAmf3(w, val)
+		return n + 1, err
+	}
+
 	switch v.Kind() {
 	case reflect.String:
 		str := v.String()
@@ -39,17 +77,29 @@ func (e *Encoder) EncodeAmf0(w io.Writer, val interface{}) (int, error) {
 		for i := 0; i < length; i++ {
 			arr[i] = v.Index(int(i)).Interface()
 		}
-		return e.EncodeAmf0StrictArray(w, arr, true)
+		return e.encodeAmf0StrictArray(w, arr, identityOf(v), true)
 	case reflect.Map:
 		obj, ok := val.(Object)
 		if ok != true {
-			return 0, Error("encode amf0: unable to create object from map")
+			if obj, ok = mapToObject(v); ok != true {
+				return 0, Error("encode amf0: unable to create object from map")
+			}
+		}
+		return e.encodeAmf0Object(w, obj, identityOf(v), true)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return e.EncodeAmf0Null(w, true)
+		}
+
+		// pointers to structs are referenced by their address
+		elem := v.Elem()
+		if marshaledAsStruct(elem.Type()) {
+			return e.encodeAmf0Struct(w, elem, identityOf(v), true)
 		}
-		return e.EncodeAmf0Object(w, obj, true)
-	}
 
-	if _, ok := val.(TypedObject); ok {
-		return 0, Error("encode amf0: unsupported type typed object")
+		return e.EncodeAmf0(w, elem.Interface())
+	case reflect.Struct:
+		return e.encodeAmf0Struct(w, v, nil, true)
 	}
 
 	return 0, Error("encode amf0: unsupported type %s", v.Type())
@@ -135,6 +185,14 @@ func (e *Encoder) EncodeAmf0String(w io.Writer, val string, encodeMarker bool) (
 // - loop encoded string followed by encoded value
 // - terminated with empty string followed by 1 byte 0x09
 func (e *Encoder) EncodeAmf0Object(w io.Writer, val Object, encodeMarker bool) (n int, err error) {
+	return e.encodeAmf0Object(w, val, identityOf(reflect.ValueOf(val)), encodeMarker)
+}
+
+func (e *Encoder) encodeAmf0Object(w io.Writer, val Object, key interface{}, encodeMarker bool) (n int, err error) {
+	if ref, ok := e.amf0Ref(key, encodeMarker); ok {
+		return e.EncodeAmf0Reference(w, uint16(ref), true)
+	}
+
 	if encodeMarker {
 		if err = WriteMarker(w, AMF0_OBJECT_MARKER); err != nil {
 			return
@@ -143,14 +201,82 @@ func (e *Encoder) EncodeAmf0Object(w io.Writer, val Object, encodeMarker bool) (
 	}
 
 	var m int
+	m, err = e.encodeAmf0ObjectBody(w, val)
+	n += m
+
+	return
+}
+
+// format:
+// - loop encoded string followed by encoded value
+// - terminated with empty string followed by 1 byte 0x09
+func (e *Encoder) encodeAmf0ObjectBody(w io.Writer, val Object) (n int, err error) {
+	keys := make([]string, 0, len(val))
+	values := make([]interface{}, 0, len(val))
 	for k, v := range val {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+
+	var m int
+	m, err = e.encodeAmf0Properties(w, keys, values)
+	n += m
+
+	return
+}
+
+// marker: 1 byte 0x03, or 0x10 if the type has a registered class alias
+// format: same as object or typed object, with the exported fields of the
+// struct as keys
+func (e *Encoder) encodeAmf0Struct(w io.Writer, v reflect.Value, key interface{}, encodeMarker bool) (n int, err error) {
+	if ref, ok := e.amf0Ref(key, encodeMarker); ok {
+		return e.EncodeAmf0Reference(w, uint16(ref), true)
+	}
+
+	alias, typed := classAliasForType(v.Type())
+
+	if encodeMarker {
+		marker := byte(AMF0_OBJECT_MARKER)
+		if typed {
+			marker = AMF0_TYPED_OBJECT_MARKER
+		}
+
+		if err = WriteMarker(w, marker); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	var m int
+	if typed {
+		m, err = e.EncodeAmf0String(w, alias, false)
+		if err != nil {
+			return n, Error("encode amf0: unable to encode typed object class name: %s", err)
+		}
+		n += m
+	}
+
+	keys, values := structProperties(v)
+
+	m, err = e.encodeAmf0Properties(w, keys, values)
+	n += m
+
+	return
+}
+
+// format:
+// - loop encoded string followed by encoded value
+// - terminated with empty string followed by 1 byte 0x09
+func (e *Encoder) encodeAmf0Properties(w io.Writer, keys []string, values []interface{}) (n int, err error) {
+	var m int
+	for i, k := range keys {
 		m, err = e.EncodeAmf0String(w, k, false)
 		if err != nil {
 			return n, Error("encode amf0: unable to encode object key: %s", err)
 		}
 		n += m
 
-		m, err = e.EncodeAmf0(w, v)
+		m, err = e.EncodeAmf0(w, values[i])
 		if err != nil {
 			return n, Error("encode amf0: unable to encode object value: %s", err)
 		}
@@ -198,6 +324,26 @@ func (e *Encoder) EncodeAmf0Undefined(w io.Writer, encodeMarker bool) (n int, er
 	return
 }
 
+// marker: 1 byte 0x07
+// format: 2 byte big endian uint16 index into the table of complex values
+// (objects, typed objects, ecma and strict arrays) written so far
+func (e *Encoder) EncodeAmf0Reference(w io.Writer, ref uint16, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF0_REFERENCE_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	err = binary.Write(w, binary.BigEndian, ref)
+	if err != nil {
+		return n, Error("encode amf0: unable to encode reference: %s", err)
+	}
+	n += 2
+
+	return
+}
+
 // marker: 1 byte 0x08
 // format:
 // - 4 byte big endian uint32 with length of associative array
@@ -205,6 +351,8 @@ func (e *Encoder) EncodeAmf0Undefined(w io.Writer, encodeMarker bool) (n int, er
 //   - loop encoded string followed by encoded value
 //   - terminated with empty string followed by 1 byte 0x09
 func (e *Encoder) EncodeAmf0EcmaArray(w io.Writer, val Object, encodeMarker bool) (n int, err error) {
+	e.amf0Ref(nil, false)
+
 	if encodeMarker {
 		if err = WriteMarker(w, AMF0_ECMA_ARRAY_MARKER); err != nil {
 			return
@@ -220,7 +368,7 @@ func (e *Encoder) EncodeAmf0EcmaArray(w io.Writer, val Object, encodeMarker bool
 	}
 	n += 4
 
-	m, err = e.EncodeAmf0Object(w, val, false)
+	m, err = e.encodeAmf0ObjectBody(w, val)
 	if err != nil {
 		return n, Error("encode amf0: unable to encode ecma array object: %s", err)
 	}
@@ -234,6 +382,14 @@ func (e *Encoder) EncodeAmf0EcmaArray(w io.Writer, val Object, encodeMarker bool
 // - 4 byte big endian uint32 to determine length of associative array
 // - n (length) encoded values
 func (e *Encoder) EncodeAmf0StrictArray(w io.Writer, val Array, encodeMarker bool) (n int, err error) {
+	return e.encodeAmf0StrictArray(w, val, identityOf(reflect.ValueOf(val)), encodeMarker)
+}
+
+func (e *Encoder) encodeAmf0StrictArray(w io.Writer, val Array, key interface{}, encodeMarker bool) (n int, err error) {
+	if ref, ok := e.amf0Ref(key, encodeMarker); ok {
+		return e.EncodeAmf0Reference(w, uint16(ref), true)
+	}
+
 	if encodeMarker {
 		if err = WriteMarker(w, AMF0_STRICT_ARRAY_MARKER); err != nil {
 			return
@@ -260,6 +416,77 @@ func (e *Encoder) EncodeAmf0StrictArray(w io.Writer, val Array, encodeMarker boo
 	return
 }
 
+// marker: 1 byte 0x10
+// format:
+// - normal string format:
+//   - 2 byte big endian uint16 header to determine size
+//   - n (size) byte utf8 string
+// - normal object format:
+//   - loop encoded string followed by encoded value
+//   - terminated with empty string followed by 1 byte 0x09
+func (e *Encoder) EncodeAmf0TypedObject(w io.Writer, val TypedObject, encodeMarker bool) (n int, err error) {
+	var key interface{}
+	if val.Object != nil {
+		key = refKey{typedObjectType, reflect.ValueOf(val.Object).Pointer(), 0}
+	}
+
+	if ref, ok := e.amf0Ref(key, encodeMarker); ok {
+		return e.EncodeAmf0Reference(w, uint16(ref), true)
+	}
+
+	if encodeMarker {
+		if err = WriteMarker(w, AMF0_TYPED_OBJECT_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	var m int
+	m, err = e.EncodeAmf0String(w, val.Type, false)
+	if err != nil {
+		return n, Error("encode amf0: unable to encode typed object class name: %s", err)
+	}
+	n += m
+
+	m, err = e.encodeAmf0ObjectBody(w, val.Object)
+	if err != nil {
+		return n, Error("encode amf0: unable to encode typed object object: %s", err)
+	}
+	n += m
+
+	return
+}
+
+// marker: 1 byte 0x0b
+// format:
+// - normal number format:
+//   - 8 byte big endian float64, milliseconds since epoch
+// - 2 byte timezone, always 0x0000
+func (e *Encoder) EncodeAmf0Date(w io.Writer, val time.Time, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF0_DATE_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	var m int
+	ms := float64(val.UnixNano() / int64(time.Millisecond))
+	m, err = e.EncodeAmf0Number(w, ms, false)
+	if err != nil {
+		return n, Error("encode amf0: unable to encode date value: %s", err)
+	}
+	n += m
+
+	m, err = w.Write([]byte{0x00, 0x00})
+	if err != nil {
+		return n, Error("encode amf0: unable to encode date timezone: %s", err)
+	}
+	n += m
+
+	return
+}
+
 // marker: 1 byte 0x0c
 // format:
 // - 4 byte big endian uint32 header to determine size
@@ -306,3 +533,28 @@ func (e *Encoder) EncodeAmf0Unsupported(w io.Writer, encodeMarker bool) (n int,
 func (e *Encoder) EncodeAmf0Amf3Marker(w io.Writer) error {
 	return WriteMarker(w, AMF0_ACMPLUS_OBJECT_MARKER)
 }
+
+// amf0Ref looks key up in the table of complex values. if the value was
+// written before and a reference may be used in its place, its index is
+// returned with ok set. otherwise the next index is claimed for it, which
+// must happen for every complex value so indexes match the peer's table.
+func (e *Encoder) amf0Ref(key interface{}, allowRef bool) (ref int, ok bool) {
+	if key != nil && allowRef {
+		if ref, ok = e.amf0Refs[key]; ok {
+			return
+		}
+	}
+
+	ref = e.amf0RefCount
+	e.amf0RefCount++
+
+	// references are limited to 16 bits
+	if key != nil && ref <= 0xffff {
+		if e.amf0Refs == nil {
+			e.amf0Refs = make(map[interface{}]int)
+		}
+		e.amf0Refs[key] = ref
+	}
+
+	return ref, false
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"testing"
+	"time"
 )
 
 func TestEncodeAmf0Number(t *testing.T) {
@@ -210,3 +211,66 @@ func TestEncodeAmf0LongString(t *testing.T) {
 		counter++
 	}
 }
+
+func TestEncodeAmf0Struct(t *testing.T) {
+	buf := new(bytes.Buffer)
+	expect := []byte{0x03, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x02, 0x00, 0x03, 0x62, 0x61, 0x72, 0x00, 0x00, 0x09}
+
+	enc := new(Encoder)
+
+	val := struct {
+		Foo     string `amf:"foo"`
+		Skipped int    `amf:"-"`
+		Empty   string `amf:"empty,omitempty"`
+		private int
+	}{Foo: "bar", Skipped: 1}
+
+	n, err := enc.EncodeAmf0(buf, &val)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if n != 15 {
+		t.Errorf("expected to write 15 bytes, actual %d", n)
+	}
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf0Date(t *testing.T) {
+	buf := new(bytes.Buffer)
+	expect := []byte{0x0b, 0x42, 0x6d, 0x1a, 0x94, 0xa2, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	enc := new(Encoder)
+
+	_, err := enc.EncodeAmf0(buf, time.Unix(1000000000, 0))
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %#v, got: %#v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf0Reference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x03, 0x00, 0x01, 0x61, 0x02, 0x00, 0x01, 0x62, 0x00, 0x00, 0x09,
+		0x07, 0x00, 0x01,
+	}
+
+	enc := new(Encoder)
+
+	obj := Object{"a": "b"}
+
+	_, err := enc.EncodeAmf0(buf, Array{obj, obj})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %#v, got: %#v", expect, buf.Bytes())
+	}
+}
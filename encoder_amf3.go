@@ -2,6 +2,7 @@ package amf
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"reflect"
 	"sort"
@@ -20,6 +21,17 @@ func (e *Encoder) EncodeAmf3(w io.Writer, val interface{}) (int, error) {
 		return e.EncodeAmf3Null(w, true)
 	}
 
+	if ext, ev, ok := e.externalHandler(v); ok {
+		return e.encodeAmf3External(w, ext, ev.Interface(), identityOf(v), true)
+	}
+
+	switch val := val.(type) {
+	case ArrayCollection:
+		return e.EncodeAmf3ArrayCollection(w, val, true)
+	case ObjectProxy:
+		return e.EncodeAmf3ObjectProxy(w, val, true)
+	}
+
 	switch v.Kind() {
 	case reflect.String:
 		return e.EncodeAmf3String(w, v.String(), true)
@@ -50,30 +62,69 @@ func (e *Encoder) EncodeAmf3(w io.Writer, val interface{}) (int, error) {
 	case reflect.Float32, reflect.Float64:
 		return e.EncodeAmf3Double(w, float64(v.Float()), true)
 	case reflect.Array, reflect.Slice:
+		if bytes, ok := val.([]byte); ok {
+			return e.EncodeAmf3ByteArray(w, bytes, true)
+		}
+
+		if arr, ok := val.(Array); ok {
+			return e.EncodeAmf3Array(w, arr, true)
+		}
+
 		length := v.Len()
 		arr := make(Array, length)
 		for i := 0; i < length; i++ {
 			arr[i] = v.Index(int(i)).Interface()
 		}
-		return e.EncodeAmf3Array(w, arr, true)
+		return e.encodeAmf3Array(w, arr, nil, identityOf(v), true)
 	case reflect.Map:
 		obj, ok := val.(Object)
 		if ok != true {
-			return 0, Error("encode amf3: unable to create object from map")
+			if obj, ok = mapToObject(v); ok != true {
+				return 0, Error("encode amf3: unable to create object from map")
+			}
 		}
 
 		to := *new(TypedObject)
 		to.Object = obj
 
-		return e.EncodeAmf3Object(w, to, true)
-	}
+		return e.encodeAmf3Object(w, to, identityOf(v), true)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return e.EncodeAmf3Null(w, true)
+		}
 
-	if tm, ok := val.(time.Time); ok {
-		return e.EncodeAmf3Date(w, tm, true)
-	}
+		// pointers to structs are referenced by their address
+		elem := v.Elem()
+		if marshaledAsStruct(elem.Type()) {
+			return e.encodeAmf3Struct(w, elem, identityOf(v), true)
+		}
+
+		return e.EncodeAmf3(w, elem.Interface())
+	case reflect.Struct:
+		if tm, ok := val.(time.Time); ok {
+			return e.EncodeAmf3Date(w, tm, true)
+		}
+
+		if to, ok := val.(TypedObject); ok {
+			return e.EncodeAmf3Object(w, to, true)
+		}
 
-	if to, ok := val.(TypedObject); ok {
-		return e.EncodeAmf3Object(w, to, true)
+		switch vec := val.(type) {
+		case MixedArray:
+			return e.EncodeAmf3Array(w, vec, true)
+		case VectorInt:
+			return e.EncodeAmf3VectorInt(w, vec, true)
+		case VectorUint:
+			return e.EncodeAmf3VectorUint(w, vec, true)
+		case VectorDouble:
+			return e.EncodeAmf3VectorDouble(w, vec, true)
+		case VectorObject:
+			return e.EncodeAmf3VectorObject(w, vec, true)
+		case Dictionary:
+			return e.EncodeAmf3Dictionary(w, vec, true)
+		}
+
+		return e.encodeAmf3Struct(w, v, nil, true)
 	}
 
 	return 0, Error("encode amf3: unsupported type %s", v.Type())
@@ -203,12 +254,20 @@ func (e *Encoder) EncodeAmf3Date(w io.Writer, val time.Time, encodeMarker bool)
 		n += 1
 	}
 
+	var m int
+	u64 := float64(val.Unix()) * 1000.0
+
+	if ref, ok := e.amf3ObjectRef(dateRefKey(u64)); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
+
 	if err = WriteMarker(w, 0x01); err != nil {
-		return n, Error("amf3 encode: cannot encode u29 for array: %s", err)
+		return n, Error("amf3 encode: cannot encode u29 for date: %s", err)
 	}
 	n += 1
 
-	u64 := float64(val.Unix()) * 1000.0
 	err = binary.Write(w, binary.BigEndian, &u64)
 	if err != nil {
 		return n, Error("amf3 encode: unable to write date double: %s", err)
@@ -223,7 +282,26 @@ func (e *Encoder) EncodeAmf3Date(w io.Writer, val time.Time, encodeMarker bool)
 // - u29 reference int. if reference, no more data.
 // - string representing associative array if present
 // - n values (length of u29)
-func (e *Encoder) EncodeAmf3Array(w io.Writer, val Array, encodeMarker bool) (n int, err error) {
+//
+// val must be an Array or a MixedArray.
+func (e *Encoder) EncodeAmf3Array(w io.Writer, val interface{}, encodeMarker bool) (n int, err error) {
+	switch arr := val.(type) {
+	case Array:
+		return e.encodeAmf3Array(w, arr, nil, identityOf(reflect.ValueOf(arr)), encodeMarker)
+	case MixedArray:
+		var key interface{}
+		if arr.Associative != nil {
+			key = mixedRefKey{reflect.ValueOf(arr.Associative).Pointer(), reflect.ValueOf(arr.Dense).Pointer(), len(arr.Dense)}
+		} else {
+			key = identityOf(reflect.ValueOf(arr.Dense))
+		}
+		return e.encodeAmf3Array(w, arr.Dense, arr.Associative, key, encodeMarker)
+	}
+
+	return 0, Error("amf3 encode: cannot encode %T as array", val)
+}
+
+func (e *Encoder) encodeAmf3Array(w io.Writer, val Array, assoc Object, key interface{}, encodeMarker bool) (n int, err error) {
 	if encodeMarker {
 		if err = WriteMarker(w, AMF3_ARRAY_MARKER); err != nil {
 			return
@@ -232,6 +310,12 @@ func (e *Encoder) EncodeAmf3Array(w io.Writer, val Array, encodeMarker bool) (n
 	}
 
 	var m int
+	if ref, ok := e.amf3ObjectRef(key); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
+
 	length := uint32(len(val))
 	u29 := uint32(length<<1) | 0x01
 
@@ -241,6 +325,30 @@ func (e *Encoder) EncodeAmf3Array(w io.Writer, val Array, encodeMarker bool) (n
 	}
 	n += m
 
+	// named members are written in sorted order, ahead of the dense portion
+	names := make([]string, 0, len(assoc))
+	for k := range assoc {
+		if k == "" {
+			return n, Error("amf3 encode: cannot encode array member with empty name")
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		m, err = e.encodeAmf3Utf8(w, k)
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode array member name %s: %s", k, err)
+		}
+		n += m
+
+		m, err = e.EncodeAmf3(w, assoc[k])
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode array member %s: %s", k, err)
+		}
+		n += m
+	}
+
 	m, err = e.encodeAmf3Utf8(w, "")
 	if err != nil {
 		return n, Error("amf3 encode: cannot encode empty string for array: %s", err)
@@ -261,6 +369,15 @@ func (e *Encoder) EncodeAmf3Array(w io.Writer, val Array, encodeMarker bool) (n
 // marker: 1 byte 0x0a
 // format: ugh
 func (e *Encoder) EncodeAmf3Object(w io.Writer, val TypedObject, encodeMarker bool) (n int, err error) {
+	var key interface{}
+	if val.Object != nil {
+		key = refKey{typedObjectType, reflect.ValueOf(val.Object).Pointer(), 0}
+	}
+
+	return e.encodeAmf3Object(w, val, key, encodeMarker)
+}
+
+func (e *Encoder) encodeAmf3Object(w io.Writer, val TypedObject, key interface{}, encodeMarker bool) (n int, err error) {
 	if encodeMarker {
 		if err = WriteMarker(w, AMF3_OBJECT_MARKER); err != nil {
 			return
@@ -269,17 +386,157 @@ func (e *Encoder) EncodeAmf3Object(w io.Writer, val TypedObject, encodeMarker bo
 	}
 
 	m := 0
+	if ref, ok := e.amf3ObjectRef(key); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
 
 	trait := *NewTrait()
 	trait.Type = val.Type
 	trait.Dynamic = false
 	trait.Externalizable = false
 
+	if val.Trait != nil {
+		trait.Dynamic = val.Trait.Dynamic
+		trait.Externalizable = val.Trait.Externalizable
+		trait.Properties = val.Trait.Properties
+	} else {
+		for k, _ := range val.Object {
+			trait.Properties = append(trait.Properties, k)
+		}
+
+		sort.Strings(trait.Properties)
+	}
+
+	// anything not covered by the sealed properties is a dynamic member
+	var dynamicKeys []string
 	for k, _ := range val.Object {
-		trait.Properties = append(trait.Properties, k)
+		var foundProp bool = false
+		for _, prop := range trait.Properties {
+			if prop == k {
+				foundProp = true
+				break
+			}
+		}
+
+		if foundProp != true {
+			dynamicKeys = append(dynamicKeys, k)
+		}
+	}
+
+	sort.Strings(dynamicKeys)
+
+	if len(dynamicKeys) > 0 && !trait.Dynamic {
+		return n, Error("amf3 encode: property %s is not part of sealed trait %s", dynamicKeys[0], trait.Type)
+	}
+
+	m, err = e.encodeAmf3Trait(w, trait)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	if trait.Externalizable {
+		return n, Error("amf3 encode: cannot encode externalizable object")
+	}
+
+	for _, prop := range trait.Properties {
+		m, err = e.EncodeAmf3(w, val.Object[prop])
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode sealed object value: %s", err)
+		}
+		n += m
+	}
+
+	if trait.Dynamic {
+		for _, k := range dynamicKeys {
+			m, err = e.encodeAmf3Utf8(w, k)
+			if err != nil {
+				return n, Error("amf3 encode: cannot encode dynamic object property key: %s", err)
+			}
+			n += m
+
+			m, err = e.EncodeAmf3(w, val.Object[k])
+			if err != nil {
+				return n, Error("amf3 encode: cannot encode dynamic object value: %s", err)
+			}
+			n += m
+		}
+
+		m, err = e.encodeAmf3Utf8(w, "")
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode dynamic object ending marker string: %s", err)
+		}
+		n += m
+	}
+
+	return
+}
+
+// marker: 1 byte 0x0a
+// format: a sealed object, with the exported fields of the struct as
+// trait properties in declaration order and the registered class alias
+// of the type, if any, as the class name
+func (e *Encoder) encodeAmf3Struct(w io.Writer, v reflect.Value, key interface{}, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_OBJECT_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	var m int
+	if ref, ok := e.amf3ObjectRef(key); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
+
+	var values []interface{}
+
+	trait := *NewTrait()
+	trait.Type, _ = classAliasForType(v.Type())
+	trait.Properties, values = structProperties(v)
+
+	m, err = e.encodeAmf3Trait(w, trait)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	for i, val := range values {
+		m, err = e.EncodeAmf3(w, val)
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode struct field %s: %s", trait.Properties[i], err)
+		}
+		n += m
+	}
+
+	return
+}
+
+// format:
+// - u29 object header with inline trait flags and sealed property count
+// - class name string
+// - n property name strings
+func (e *Encoder) encodeAmf3Trait(w io.Writer, trait Trait) (n int, err error) {
+	// traits that were written before are sent as a reference:
+	// u29 with the trait index shifted left by two, and the low bits 0x01
+	sig := traitSignature(trait)
+	if ref, ok := e.traitRefs[sig]; ok {
+		n, err = e.encodeAmf3Uint29(w, uint32(ref<<2)|0x01)
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode trait reference for object: %s", err)
+		}
+
+		return
 	}
 
-	sort.Strings(trait.Properties)
+	if e.traitRefs == nil {
+		e.traitRefs = make(map[string]int)
+	}
+	e.traitRefs[sig] = len(e.traitRefs)
 
 	var u29 uint32 = 0x03
 	if trait.Dynamic {
@@ -292,6 +549,7 @@ func (e *Encoder) EncodeAmf3Object(w io.Writer, val TypedObject, encodeMarker bo
 
 	u29 |= uint32(len(trait.Properties)) << 4
 
+	var m int
 	m, err = e.encodeAmf3Uint29(w, u29)
 	if err != nil {
 		return n, Error("amf3 encode: cannot encode trait header for object: %s", err)
@@ -312,50 +570,6 @@ func (e *Encoder) EncodeAmf3Object(w io.Writer, val TypedObject, encodeMarker bo
 		n += m
 	}
 
-	if trait.Externalizable {
-		return n, Error("amf3 encode: cannot encode externalizable object")
-	}
-
-	for _, prop := range trait.Properties {
-		m, err = e.EncodeAmf3(w, val.Object[prop])
-		if err != nil {
-			return n, Error("amf3 encode: cannot encode sealed object value: %s", err)
-		}
-		n += m
-	}
-
-	if trait.Dynamic {
-		for k, v := range val.Object {
-			var foundProp bool = false
-			for _, prop := range trait.Properties {
-				if prop == k {
-					foundProp = true
-					break
-				}
-			}
-
-			if foundProp != true {
-				m, err = e.encodeAmf3Utf8(w, k)
-				if err != nil {
-					return n, Error("amf3 encode: cannot encode dynamic object property key: %s", err)
-				}
-				n += m
-
-				m, err = e.EncodeAmf3(w, v)
-				if err != nil {
-					return n, Error("amf3 encode: cannot encode dynamic object value: %s", err)
-				}
-				n += m
-			}
-
-			m, err = e.encodeAmf3Utf8(w, "")
-			if err != nil {
-				return n, Error("amf3 encode: cannot encode dynamic object ending marker string: %s", err)
-			}
-			n += m
-		}
-	}
-
 	return
 }
 
@@ -372,6 +586,11 @@ func (e *Encoder) EncodeAmf3ByteArray(w io.Writer, val []byte, encodeMarker bool
 	}
 
 	var m int
+	if ref, ok := e.amf3ObjectRef(identityOf(reflect.ValueOf(val))); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
 
 	length := uint32(len(val))
 	u29 := (length << 1) | 1
@@ -391,7 +610,280 @@ func (e *Encoder) EncodeAmf3ByteArray(w io.Writer, val []byte, encodeMarker bool
 	return
 }
 
+// marker: 1 byte 0x0a
+// format:
+// - u29 reference int. if reference, no more data.
+// - trait with the externalizable flag set and no properties
+// - body as written by the handler
+func (e *Encoder) encodeAmf3External(w io.Writer, ext externalEncoder, val interface{}, key interface{}, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_OBJECT_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	var m int
+	if ref, ok := e.amf3ObjectRef(key); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
+
+	trait := *NewTrait()
+	trait.Type = ext.name
+	trait.Externalizable = true
+
+	m, err = e.encodeAmf3Trait(w, trait)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	m, err = ext.f(e, w, val)
+	if err != nil {
+		return n, Error("amf3 encode: unable to call external encoder for type %s: %s", ext.name, err)
+	}
+	n += m
+
+	return
+}
+
+// marker: 1 byte 0x0d
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - n (length of u29) 4 byte big endian int32 values
+func (e *Encoder) EncodeAmf3VectorInt(w io.Writer, val VectorInt, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_VECTOR_INT_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	key := identityAs(vectorIntType, reflect.ValueOf(val.Values))
+	m, done, err := e.encodeAmf3VectorHeader(w, key, len(val.Values), val.Fixed)
+	n += m
+	if err != nil || done {
+		return
+	}
+
+	err = binary.Write(w, binary.BigEndian, val.Values)
+	if err != nil {
+		return n, Error("amf3 encode: unable to encode int vector values: %s", err)
+	}
+	n += 4 * len(val.Values)
+
+	return
+}
+
+// marker: 1 byte 0x0e
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - n (length of u29) 4 byte big endian uint32 values
+func (e *Encoder) EncodeAmf3VectorUint(w io.Writer, val VectorUint, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_VECTOR_UINT_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	key := identityAs(vectorUintType, reflect.ValueOf(val.Values))
+	m, done, err := e.encodeAmf3VectorHeader(w, key, len(val.Values), val.Fixed)
+	n += m
+	if err != nil || done {
+		return
+	}
+
+	err = binary.Write(w, binary.BigEndian, val.Values)
+	if err != nil {
+		return n, Error("amf3 encode: unable to encode uint vector values: %s", err)
+	}
+	n += 4 * len(val.Values)
+
+	return
+}
+
+// marker: 1 byte 0x0f
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - n (length of u29) 8 byte big endian float64 values
+func (e *Encoder) EncodeAmf3VectorDouble(w io.Writer, val VectorDouble, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_VECTOR_DOUBLE_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	key := identityAs(vectorDoubleType, reflect.ValueOf(val.Values))
+	m, done, err := e.encodeAmf3VectorHeader(w, key, len(val.Values), val.Fixed)
+	n += m
+	if err != nil || done {
+		return
+	}
+
+	err = binary.Write(w, binary.BigEndian, val.Values)
+	if err != nil {
+		return n, Error("amf3 encode: unable to encode double vector values: %s", err)
+	}
+	n += 8 * len(val.Values)
+
+	return
+}
+
+// marker: 1 byte 0x10
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+// - string with the class name of the elements, "*" if untyped
+// - n (length of u29) values
+func (e *Encoder) EncodeAmf3VectorObject(w io.Writer, val VectorObject, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_VECTOR_OBJECT_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	key := identityAs(vectorObjectType, reflect.ValueOf(val.Values))
+	m, done, err := e.encodeAmf3VectorHeader(w, key, len(val.Values), val.Fixed)
+	n += m
+	if err != nil || done {
+		return
+	}
+
+	typ := val.Type
+	if typ == "" {
+		typ = "*"
+	}
+
+	m, err = e.encodeAmf3Utf8(w, typ)
+	if err != nil {
+		return n, Error("amf3 encode: unable to encode object vector type: %s", err)
+	}
+	n += m
+
+	for _, v := range val.Values {
+		m, err = e.EncodeAmf3(w, v)
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode object vector element: %s", err)
+		}
+		n += m
+	}
+
+	return
+}
+
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte fixed length flag
+//
+// done is set if a reference was written in place of the vector.
+func (e *Encoder) encodeAmf3VectorHeader(w io.Writer, key interface{}, length int, fixed bool) (n int, done bool, err error) {
+	if ref, ok := e.amf3ObjectRef(key); ok {
+		n, err = e.encodeAmf3ObjectRef(w, ref)
+		return n, true, err
+	}
+
+	n, err = e.encodeAmf3Uint29(w, uint32(length<<1)|0x01)
+	if err != nil {
+		return n, false, Error("amf3 encode: cannot encode u29 for vector: %s", err)
+	}
+
+	var flag byte
+	if fixed {
+		flag = 0x01
+	}
+
+	if err = WriteByte(w, flag); err != nil {
+		return n, false, Error("amf3 encode: cannot encode vector fixed flag: %s", err)
+	}
+	n += 1
+
+	return
+}
+
+// marker: 1 byte 0x11
+// format:
+// - u29 reference int. if reference, no more data.
+// - 1 byte weak keys flag
+// - n (length of u29) encoded keys, each followed by an encoded value
+func (e *Encoder) EncodeAmf3Dictionary(w io.Writer, val Dictionary, encodeMarker bool) (n int, err error) {
+	if encodeMarker {
+		if err = WriteMarker(w, AMF3_DICTIONARY_MARKER); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	var m int
+	if ref, ok := e.amf3ObjectRef(identityAs(dictionaryType, reflect.ValueOf(val.Entries))); ok {
+		m, err = e.encodeAmf3ObjectRef(w, ref)
+		n += m
+		return
+	}
+
+	length := uint32(len(val.Entries))
+	m, err = e.encodeAmf3Uint29(w, (length<<1)|0x01)
+	if err != nil {
+		return n, Error("amf3 encode: cannot encode u29 for dictionary: %s", err)
+	}
+	n += m
+
+	var flag byte
+	if val.WeakKeys {
+		flag = 0x01
+	}
+
+	if err = WriteByte(w, flag); err != nil {
+		return n, Error("amf3 encode: cannot encode dictionary weak keys flag: %s", err)
+	}
+	n += 1
+
+	for _, entry := range val.Entries {
+		m, err = e.EncodeAmf3(w, entry.Key)
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode dictionary key: %s", err)
+		}
+		n += m
+
+		m, err = e.EncodeAmf3(w, entry.Value)
+		if err != nil {
+			return n, Error("amf3 encode: cannot encode dictionary value: %s", err)
+		}
+		n += m
+	}
+
+	return
+}
+
+// format:
+// - u29 reference int. if the string was written before, its index in the
+//   string table shifted left by one, and no more data. otherwise the length
+//   shifted left by one with the low bit set, followed by the bytes.
+// - the empty string is always written inline and never referenced.
 func (e *Encoder) encodeAmf3Utf8(w io.Writer, val string) (n int, err error) {
+	if val != "" {
+		if ref, ok := e.stringRefs[val]; ok {
+			n, err = e.encodeAmf3Uint29(w, uint32(ref<<1))
+			if err != nil {
+				return n, Error("amf3 encode: cannot encode u29 for string reference: %s", err)
+			}
+
+			return
+		}
+
+		if e.stringRefs == nil {
+			e.stringRefs = make(map[string]int)
+		}
+		e.stringRefs[val] = len(e.stringRefs)
+	}
+
 	length := uint32(len(val))
 	u29 := uint32(length<<1) | 0x01
 
@@ -429,3 +921,43 @@ func (e *Encoder) encodeAmf3Uint29(w io.Writer, val uint32) (n int, err error) {
 
 	return
 }
+
+// amf3ObjectRef looks key up in the object reference table. if the value was
+// written before, its index is returned with ok set. otherwise the next index
+// is claimed for it, which must happen for every complex value written inline
+// so that indexes match the peer's table, even those without a key.
+func (e *Encoder) amf3ObjectRef(key interface{}) (ref int, ok bool) {
+	if key != nil {
+		if ref, ok = e.objectRefs[key]; ok {
+			return
+		}
+	}
+
+	ref = e.objectCount
+	e.objectCount++
+
+	if key != nil {
+		if e.objectRefs == nil {
+			e.objectRefs = make(map[interface{}]int)
+		}
+		e.objectRefs[key] = ref
+	}
+
+	return ref, false
+}
+
+// format: u29 with the object index shifted left by one, low bit unset
+func (e *Encoder) encodeAmf3ObjectRef(w io.Writer, ref int) (n int, err error) {
+	n, err = e.encodeAmf3Uint29(w, uint32(ref<<1))
+	if err != nil {
+		return n, Error("amf3 encode: cannot encode object reference: %s", err)
+	}
+
+	return
+}
+
+// traitSignature keys the trait cache, quoting the names so that no two
+// traits share one.
+func traitSignature(trait Trait) string {
+	return fmt.Sprintf("%q|%t|%t|%q", trait.Type, trait.Dynamic, trait.Externalizable, trait.Properties)
+}
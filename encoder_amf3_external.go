@@ -0,0 +1,106 @@
+package amf
+
+import (
+	"io"
+	"reflect"
+)
+
+// marker: 1 byte 0x0a
+// format:
+// - u29 reference int. if reference, no more data.
+// - externalizable trait for flex.messaging.io.ArrayCollection
+// - the wrapped array
+func (e *Encoder) EncodeAmf3ArrayCollection(w io.Writer, val ArrayCollection, encodeMarker bool) (n int, err error) {
+	ext := externalEncoder{"flex.messaging.io.ArrayCollection", func(e *Encoder, w io.Writer, _ interface{}) (int, error) {
+		return e.EncodeAmf3Array(w, Array(val), true)
+	}}
+
+	return e.encodeAmf3External(w, ext, val, identityOf(reflect.ValueOf(val)), encodeMarker)
+}
+
+// marker: 1 byte 0x0a
+// format:
+// - u29 reference int. if reference, no more data.
+// - externalizable trait for flex.messaging.io.ObjectProxy
+// - the wrapped object
+func (e *Encoder) EncodeAmf3ObjectProxy(w io.Writer, val ObjectProxy, encodeMarker bool) (n int, err error) {
+	ext := externalEncoder{"flex.messaging.io.ObjectProxy", func(e *Encoder, w io.Writer, _ interface{}) (int, error) {
+		return e.EncodeAmf3(w, Object(val))
+	}}
+
+	return e.encodeAmf3External(w, ext, val, identityOf(reflect.ValueOf(val)), encodeMarker)
+}
+
+// encodeMessageFields writes one level of a flex message the way
+// writeExternal does. fields holds, for each flag byte, the members stored
+// under its bits in order. members with their zero value are left out.
+//
+// format:
+// - flag bytes, the high bit of each set if another follows
+// - for each set bit, an encoded value
+func (e *Encoder) encodeMessageFields(w io.Writer, fields ...[]interface{}) (n int, err error) {
+	if len(fields) == 0 {
+		fields = [][]interface{}{nil}
+	}
+
+	flagSet := make([]uint8, len(fields))
+	for i, fieldVals := range fields {
+		if len(fieldVals) > 7 {
+			return n, Error("unable to encode %d external fields in one flag byte", len(fieldVals))
+		}
+
+		for p, val := range fieldVals {
+			if !isEmptyMessageField(val) {
+				flagSet[i] |= 1 << uint(p)
+			}
+		}
+	}
+
+	// trailing empty flag bytes are left out, but there is always one
+	for len(flagSet) > 1 && flagSet[len(flagSet)-1] == 0 {
+		flagSet = flagSet[:len(flagSet)-1]
+	}
+
+	for i, flags := range flagSet {
+		if i < len(flagSet)-1 {
+			flags |= 0x80
+		}
+
+		if err = WriteByte(w, flags); err != nil {
+			return n, Error("unable to encode flags: %s", err)
+		}
+		n += 1
+	}
+
+	for i, flags := range flagSet {
+		for p, val := range fields[i] {
+			if (flags>>uint(p))&0x01 == 0 {
+				continue
+			}
+
+			m, err := e.EncodeAmf3(w, val)
+			if err != nil {
+				return n, Error("unable to encode external field %d %d: %s", i, p, err)
+			}
+			n += m
+		}
+	}
+
+	return
+}
+
+// isEmptyMessageField reports whether val is left out of a flex message.
+// unlike omitempty, empty but non-nil bodies are still sent.
+func isEmptyMessageField(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return isEmptyValue(v)
+}
@@ -2,7 +2,9 @@ package amf
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestEncodeAmf3EmptyString(t *testing.T) {
@@ -173,6 +175,93 @@ func TestEncodeAmf3Array(t *testing.T) {
 	}
 }
 
+func TestEncodeAmf3MixedArray(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{0x09, 0x03,
+		0x03, 'a', 0x04, 0x01,
+		0x03, 'b', 0x06, 0x03, 'y',
+		0x01,
+		0x06, 0x03, 'x',
+	}
+
+	arr := MixedArray{Dense: Array{"x"}, Associative: Object{"b": "y", "a": 1}}
+	_, err := enc.EncodeAmf3(buf, arr)
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf3MixedArraySharedMembers(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+
+	assoc := Object{"a": "y"}
+	first := MixedArray{Dense: Array{"x"}, Associative: assoc}
+	second := MixedArray{Dense: Array{"z"}, Associative: assoc}
+
+	if _, err := enc.EncodeAmf3(buf, Array{first, second, first}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	got, err := new(Decoder).DecodeAmf3(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	expect := Array{first, second, first}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+
+	// the repeated array is sent as a reference
+	if enc.objectCount != 3 {
+		t.Errorf("expected 3 objects, got %d", enc.objectCount)
+	}
+}
+
+func TestEncodeAmf3Vector(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x0d, 0x05, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff,
+		0x10, 0x03, 0x00, 0x03, '*', 0x06, 0x03, 'x',
+		0x0d, 0x00,
+	}
+
+	ints := VectorInt{Fixed: true, Values: []int32{1, -1}}
+	for _, v := range []interface{}{ints, VectorObject{Values: Array{"x"}}, ints} {
+		_, err := enc.EncodeAmf3(buf, v)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf3Dictionary(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{0x11, 0x03, 0x00, 0x04, 0x01, 0x06, 0x03, 'a'}
+
+	dict := Dictionary{Entries: []DictionaryEntry{{Key: 1, Value: "a"}}}
+	_, err := enc.EncodeAmf3(buf, dict)
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+}
+
 func TestEncodeAmf3Object(t *testing.T) {
 	enc := new(Encoder)
 	buf := new(bytes.Buffer)
@@ -197,3 +286,192 @@ func TestEncodeAmf3Object(t *testing.T) {
 		t.Errorf("expected buffer:\n%#v\ngot:\n%#v", expect, buf.Bytes())
 	}
 }
+
+type encodeAmf3Base struct {
+	Id int32 `amf:"id"`
+}
+
+type encodeAmf3Child struct {
+	*encodeAmf3Base
+	Name string   `amf:"name"`
+	Tags []string `amf:"tags,omitempty"`
+}
+
+func TestEncodeAmf3Struct(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x0a, 0x23, 0x01, 0x05, 'i', 'd', 0x09, 'n',
+		'a', 'm', 'e', 0x04, 0x07, 0x06, 0x07, 'f',
+		'o', 'o',
+	}
+
+	val := &encodeAmf3Child{
+		encodeAmf3Base: &encodeAmf3Base{Id: 7},
+		Name:           "foo",
+	}
+
+	_, err := enc.EncodeAmf3(buf, val)
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer:\n%#v\ngot:\n%#v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf3StringReference(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{0x09, 0x09, 0x01,
+		0x06, 0x07, 'f', 'o', 'o',
+		0x06, 0x01,
+		0x06, 0x00,
+		0x06, 0x00,
+	}
+
+	_, err := enc.EncodeAmf3(buf, []string{"foo", "", "foo", "foo"})
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+
+	buf.Reset()
+	expect = []byte{0x06, 0x00}
+
+	_, err = enc.EncodeAmf3(buf, "foo")
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+
+	buf.Reset()
+	expect = []byte{0x06, 0x07, 'f', 'o', 'o'}
+
+	enc.Reset()
+	_, err = enc.EncodeAmf3(buf, "foo")
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer after reset: %+v, got: %+v", expect, buf.Bytes())
+	}
+}
+
+type encodeAmf3Node struct {
+	Name string          `amf:"name"`
+	Next *encodeAmf3Node `amf:"next"`
+}
+
+func TestEncodeAmf3ObjectReference(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x09, 0x09, 0x01,
+		0x0a, 0x13, 0x01, 0x03, 'a', 0x04, 0x01,
+		0x0a, 0x02,
+		0x08, 0x01, 0x42, 0x6d, 0x1a, 0x94, 0xa2, 0x00, 0x00, 0x00,
+		0x08, 0x04,
+	}
+
+	obj := Object{"a": 1}
+	date := time.Unix(1000000000, 0)
+
+	_, err := enc.EncodeAmf3(buf, Array{obj, obj, date, date})
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer:\n%#v\ngot:\n%#v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf3TraitReference(t *testing.T) {
+	type point struct {
+		X int32 `amf:"x"`
+	}
+
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x09, 0x05, 0x01,
+		0x0a, 0x13, 0x01, 0x03, 'x', 0x04, 0x01,
+		0x0a, 0x01, 0x04, 0x02,
+	}
+
+	_, err := enc.EncodeAmf3(buf, []point{{1}, {2}})
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer:\n%#v\ngot:\n%#v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf3TraitSignature(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+
+	traits := []Trait{
+		{Type: "t", Properties: []string{"a,b"}},
+		{Type: "t", Properties: []string{"a", "b"}},
+	}
+
+	for _, trait := range traits {
+		if _, err := enc.encodeAmf3Trait(buf, trait); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if len(enc.traitRefs) != 2 {
+		t.Errorf("expected 2 traits, got %d", len(enc.traitRefs))
+	}
+}
+
+func TestEncodeAmf3CyclicReference(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x0a, 0x23, 0x01, 0x09, 'n', 'a', 'm', 'e',
+		0x09, 'n', 'e', 'x', 't', 0x06, 0x03, 'a',
+		0x0a, 0x00,
+	}
+
+	node := &encodeAmf3Node{Name: "a"}
+	node.Next = node
+
+	_, err := enc.EncodeAmf3(buf, node)
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer:\n%#v\ngot:\n%#v", expect, buf.Bytes())
+	}
+}
+
+func TestEncodeAmf3ArrayCollection(t *testing.T) {
+	enc := new(Encoder)
+	buf := new(bytes.Buffer)
+	expect := append([]byte{0x0a, 0x07, 0x43}, "flex.messaging.io.ArrayCollection"...)
+	expect = append(expect, 0x09, 0x03, 0x01, 0x06, 0x03, 'a')
+
+	_, err := enc.EncodeAmf3(buf, ArrayCollection{"a"})
+	if err != nil {
+		t.Errorf("err: %s", err)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %+v, got: %+v", expect, buf.Bytes())
+	}
+}
@@ -0,0 +1,374 @@
+package amf
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint is an http.Handler serving a flex amf channel, as BlazeDS does.
+// Request messages carrying a flex RemotingMessage are dispatched to the
+// method named by its operation on the service registered as its
+// destination, and CommandMessages for pings, logins and logouts are
+// answered. Replies are an AcknowledgeMessage carrying the result, or an
+// ErrorMessage. Messages without a flex message are served as classic
+// remoting calls, like a Gateway does.
+type Endpoint struct {
+	Gateway
+
+	// Broker, if set, serves the subscriptions, polls and published messages
+	// of flex consumers and producers.
+	Broker *Broker
+
+	// Login, if set, checks the credentials of login commands, and only
+	// flex clients that logged in may call services, publish, subscribe and
+	// poll. Classic remoting calls then need a Credentials header. Logins are
+	// accepted when it is nil.
+	Login func(username, password string) error
+
+	mu sync.Mutex
+
+	// the flex client ids that logged in, with their username
+	authenticated map[string]string
+}
+
+// the header classic remoting calls send their credentials in
+type credentialsHeader struct {
+	Username string `amf:"userid"`
+	Password string `amf:"password"`
+}
+
+func NewEndpoint() *Endpoint {
+	return new(Endpoint)
+}
+
+func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.servePacket(w, r, e.Serve)
+}
+
+// Serve answers each message of req and returns the response packet, in the
+// same version as req.
+func (e *Endpoint) Serve(req *Packet) *Packet {
+	res := &Packet{Version: req.Version}
+
+	// checked once, for the first classic remoting call
+	var classicErr *StatusError
+	classicChecked := false
+
+	for _, msg := range req.Messages {
+		fm, ok := flexMessageOf(msg.Body)
+		if !ok {
+			if !classicChecked {
+				classicErr = e.checkCredentials(req.Headers)
+				classicChecked = true
+			}
+
+			if classicErr != nil {
+				res.Messages = append(res.Messages, Message{TargetUri: msg.ResponseUri + "/onStatus", ResponseUri: "null", Body: classicErr})
+				continue
+			}
+
+			res.Messages = append(res.Messages, e.serveMessage(msg))
+			continue
+		}
+
+		reply := e.serveFlex(fm)
+
+		target := msg.ResponseUri + "/onResult"
+		if _, failed := reply.(*ErrorMessage); failed {
+			target = msg.ResponseUri + "/onStatus"
+		}
+
+		res.Messages = append(res.Messages, Message{TargetUri: target, ResponseUri: "null", Body: reply})
+	}
+
+	return res
+}
+
+// flexMessageOf returns the flex message sent in body, which flex wraps in
+// an array of one element.
+func flexMessageOf(body interface{}) (interface{}, bool) {
+	if arr, ok := body.(Array); ok && len(arr) == 1 {
+		body = arr[0]
+	}
+
+	switch m := body.(type) {
+	case *RemotingMessage, *CommandMessage, *AsyncMessage:
+		return m, true
+	case *CommandMessageExt:
+		return &m.CommandMessage, true
+	case *AsyncMessageExt:
+		return &m.AsyncMessage, true
+	}
+
+	return nil, false
+}
+
+func (e *Endpoint) serveFlex(m interface{}) interface{} {
+	if req := abstractMessageOf(m); !e.authorized(m) {
+		return newErrorMessage(req, NewStatusError(STATUS_AUTHENTICATION, "login required"))
+	}
+
+	switch m := m.(type) {
+	case *RemotingMessage:
+		result, err := e.services.call(m.Destination, m.Operation, m.Body)
+		if err != nil {
+			return newErrorMessage(&m.AbstractMessage, statusOf(err))
+		}
+
+		return newAcknowledgeMessage(&m.AbstractMessage, result)
+
+	case *CommandMessage:
+		return e.command(m)
+
+	case *AsyncMessage:
+		if e.Broker != nil {
+			if err := e.Broker.Publish(m); err != nil {
+				return newErrorMessage(&m.AbstractMessage, statusOf(err))
+			}
+
+			return newAcknowledgeMessage(&m.AbstractMessage, nil)
+		}
+	}
+
+	req := abstractMessageOf(m)
+
+	return newErrorMessage(req, NewStatusError(STATUS_RESOURCE_UNAVAILABLE, "no messaging service for destination %s", req.Destination))
+}
+
+func (e *Endpoint) command(m *CommandMessage) interface{} {
+	switch m.Operation {
+	case COMMAND_CLIENT_PING_OPERATION, COMMAND_TRIGGER_CONNECT_OPERATION:
+		return newAcknowledgeMessage(&m.AbstractMessage, nil)
+
+	case COMMAND_LOGOUT_OPERATION:
+		e.setAuthenticated(flexClientId(&m.AbstractMessage), "", false)
+
+		return newAcknowledgeMessage(&m.AbstractMessage, nil)
+
+	case COMMAND_DISCONNECT_OPERATION:
+		e.setAuthenticated(flexClientId(&m.AbstractMessage), "", false)
+
+		if e.Broker != nil {
+			e.Broker.disconnect(flexClientId(&m.AbstractMessage))
+		}
+
+		return newAcknowledgeMessage(&m.AbstractMessage, nil)
+
+	case COMMAND_SUBSCRIBE_OPERATION, COMMAND_UNSUBSCRIBE_OPERATION, COMMAND_MULTI_SUBSCRIBE_OPERATION, COMMAND_POLL_OPERATION:
+		if e.Broker != nil {
+			return e.messaging(m)
+		}
+
+	case COMMAND_LOGIN_OPERATION:
+		username, err := e.login(m.Body)
+		if err != nil {
+			return newErrorMessage(&m.AbstractMessage, err)
+		}
+
+		e.setAuthenticated(flexClientId(&m.AbstractMessage), username, true)
+
+		return newAcknowledgeMessage(&m.AbstractMessage, "success")
+	}
+
+	return newErrorMessage(&m.AbstractMessage, NewStatusError(STATUS_PROCESSING, "unsupported command operation %d", m.Operation))
+}
+
+// messaging serves the subscriptions and polls of consumers.
+func (e *Endpoint) messaging(m *CommandMessage) interface{} {
+	id := flexClientId(&m.AbstractMessage)
+
+	var err error
+	switch m.Operation {
+	case COMMAND_SUBSCRIBE_OPERATION:
+		err = e.Broker.subscribe(id, m)
+
+	case COMMAND_MULTI_SUBSCRIBE_OPERATION:
+		err = e.Broker.multiSubscribe(id, m)
+
+	case COMMAND_UNSUBSCRIBE_OPERATION:
+		e.Broker.unsubscribe(id, m)
+
+	case COMMAND_POLL_OPERATION:
+		msgs := e.Broker.poll(id)
+		if len(msgs) == 0 {
+			return newAcknowledgeMessage(&m.AbstractMessage, nil)
+		}
+
+		body := make(Array, len(msgs))
+		for i, msg := range msgs {
+			body[i] = msg
+		}
+
+		// queued messages are flushed to the client in a sync command
+		reply := &CommandMessage{Operation: COMMAND_CLIENT_SYNC_OPERATION}
+		reply.AsyncMessage = newAcknowledgeMessage(&m.AbstractMessage, body).AsyncMessage
+
+		return reply
+	}
+
+	if err != nil {
+		return newErrorMessage(&m.AbstractMessage, statusOf(err))
+	}
+
+	return newAcknowledgeMessage(&m.AbstractMessage, nil)
+}
+
+// login checks credentials sent as the base64 encoding of
+// "username:password", and returns the username.
+func (e *Endpoint) login(body interface{}) (string, *StatusError) {
+	encoded, _ := body.(string)
+
+	credentials, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", NewStatusError(STATUS_AUTHENTICATION, "invalid credentials")
+	}
+
+	i := strings.Index(string(credentials), ":")
+	if i < 0 {
+		return "", NewStatusError(STATUS_AUTHENTICATION, "invalid credentials")
+	}
+
+	username := string(credentials[:i])
+
+	return username, e.checkLogin(username, string(credentials[i+1:]))
+}
+
+func (e *Endpoint) checkLogin(username, password string) *StatusError {
+	if e.Login == nil {
+		return nil
+	}
+
+	if err := e.Login(username, password); err != nil {
+		if se, ok := err.(*StatusError); ok {
+			return se
+		}
+
+		return NewStatusError(STATUS_AUTHENTICATION, "%s", err)
+	}
+
+	return nil
+}
+
+// checkCredentials checks the Credentials header of a packet of classic
+// remoting calls when logins are checked.
+func (e *Endpoint) checkCredentials(headers []Header) *StatusError {
+	if e.Login == nil {
+		return nil
+	}
+
+	for _, h := range headers {
+		if h.Name != "Credentials" {
+			continue
+		}
+
+		var c credentialsHeader
+		if err := Convert(h.Value, &c); err != nil {
+			return NewStatusError(STATUS_AUTHENTICATION, "invalid credentials")
+		}
+
+		return e.checkLogin(c.Username, c.Password)
+	}
+
+	return NewStatusError(STATUS_AUTHENTICATION, "login required")
+}
+
+func (e *Endpoint) setAuthenticated(id, username string, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !ok {
+		delete(e.authenticated, id)
+		return
+	}
+
+	if e.authenticated == nil {
+		e.authenticated = make(map[string]string)
+	}
+	e.authenticated[id] = username
+}
+
+// authorized reports whether the sender of m may have it served, which all
+// may when logins are not checked. Commands other than those of consumers,
+// logouts and disconnects are always served, so that clients can connect
+// and log in.
+func (e *Endpoint) authorized(m interface{}) bool {
+	if e.Login == nil {
+		return true
+	}
+
+	if cmd, ok := m.(*CommandMessage); ok {
+		switch cmd.Operation {
+		case COMMAND_SUBSCRIBE_OPERATION, COMMAND_UNSUBSCRIBE_OPERATION, COMMAND_MULTI_SUBSCRIBE_OPERATION, COMMAND_POLL_OPERATION,
+			COMMAND_LOGOUT_OPERATION, COMMAND_DISCONNECT_OPERATION:
+		default:
+			return true
+		}
+	}
+
+	id, _ := abstractMessageOf(m).Headers[MESSAGE_FLEX_CLIENT_ID_HEADER].(string)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.authenticated[id]
+
+	return ok
+}
+
+func abstractMessageOf(m interface{}) *AbstractMessage {
+	switch m := m.(type) {
+	case *RemotingMessage:
+		return &m.AbstractMessage
+	case *CommandMessage:
+		return &m.AbstractMessage
+	case *AsyncMessage:
+		return &m.AbstractMessage
+	}
+
+	return new(AbstractMessage)
+}
+
+// flexClientId returns the flex client id sent in the DSId header of req,
+// issuing one and setting it on req if it has none.
+func flexClientId(req *AbstractMessage) string {
+	id, _ := req.Headers[MESSAGE_FLEX_CLIENT_ID_HEADER].(string)
+	if id == "" || id == "nil" {
+		id = newUUID()
+
+		if req.Headers == nil {
+			req.Headers = make(Object)
+		}
+		req.Headers[MESSAGE_FLEX_CLIENT_ID_HEADER] = id
+	}
+
+	return id
+}
+
+// newAcknowledgeMessage replies to req with body. the flex client id of the
+// sender is echoed in the DSId header, and one is issued if it has none.
+func newAcknowledgeMessage(req *AbstractMessage, body interface{}) *AcknowledgeMessage {
+	ack := new(AcknowledgeMessage)
+	ack.Body = body
+	ack.ClientId = req.ClientId
+	ack.Destination = req.Destination
+	ack.MessageId = newUUID()
+	ack.CorrelationId = req.MessageId
+	ack.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+
+	ack.Headers = Object{MESSAGE_FLEX_CLIENT_ID_HEADER: flexClientId(req)}
+
+	return ack
+}
+
+func newErrorMessage(req *AbstractMessage, status *StatusError) *ErrorMessage {
+	msg := new(ErrorMessage)
+	msg.AcknowledgeMessage = *newAcknowledgeMessage(req, nil)
+	msg.FaultCode = status.Code
+	msg.FaultString = status.Description
+	msg.FaultDetail = status.Details
+
+	return msg
+}
@@ -0,0 +1,212 @@
+package amf
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+	e := NewEndpoint()
+	if err := e.Register("SummonerService", new(gatewayService)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+
+	var logins []string
+	e.Login = func(username, password string) error {
+		logins = append(logins, username+":"+password)
+		if password != "secret" {
+			return errors.New("bad password")
+		}
+		return nil
+	}
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ping := new(CommandMessage)
+	ping.Operation = COMMAND_CLIENT_PING_OPERATION
+	ping.MessageId = "ping"
+	ping.Headers = Object{"DSId": "nil"}
+
+	call := new(RemotingMessage)
+	call.Destination = "SummonerService"
+	call.Operation = "getSummoner"
+	call.MessageId = "call"
+	call.Headers = Object{"DSId": "client"}
+	call.Body = Array{"alfie", 30}
+
+	missing := new(RemotingMessage)
+	missing.Destination = "MissingService"
+	missing.Operation = "getSummoner"
+	missing.Headers = Object{"DSId": "client"}
+
+	login := new(CommandMessage)
+	login.Operation = COMMAND_LOGIN_OPERATION
+	login.Headers = Object{"DSId": "client"}
+	login.Body = base64.StdEncoding.EncodeToString([]byte("alfie:secret"))
+
+	badLogin := new(CommandMessage)
+	badLogin.Operation = COMMAND_LOGIN_OPERATION
+	badLogin.Headers = Object{}
+	badLogin.Body = base64.StdEncoding.EncodeToString([]byte("alfie:wrong"))
+
+	res := gatewayRoundTrip(t, srv.URL, &Packet{
+		Version: AMF3,
+		Headers: []Header{{Name: "Credentials", Value: Object{"userid": "alfie", "password": "secret"}}},
+		Messages: []Message{
+			{TargetUri: "null", ResponseUri: "/1", Body: Array{login}},
+			{TargetUri: "null", ResponseUri: "/2", Body: Array{ping}},
+			{TargetUri: "null", ResponseUri: "/3", Body: Array{call}},
+			{TargetUri: "null", ResponseUri: "/4", Body: Array{missing}},
+			{TargetUri: "null", ResponseUri: "/5", Body: Array{badLogin}},
+			{TargetUri: "SummonerService.sum", ResponseUri: "/6", Body: Array{1, 2}},
+		},
+	})
+
+	if len(res.Messages) != 6 {
+		t.Fatalf("expected 6 messages, got %d", len(res.Messages))
+	}
+
+	targets := []string{"/1/onResult", "/2/onResult", "/3/onResult", "/4/onStatus", "/5/onStatus", "/6/onResult"}
+	for i, target := range targets {
+		if res.Messages[i].TargetUri != target {
+			t.Errorf("expected message %d to target %s, got %s", i, target, res.Messages[i].TargetUri)
+		}
+	}
+
+	ack, ok := res.Messages[1].Body.(*AcknowledgeMessage)
+	if !ok {
+		t.Fatalf("expected ack for ping, got %#v", res.Messages[1].Body)
+	}
+	if ack.CorrelationId != "ping" {
+		t.Errorf("expected correlation id ping, got %s", ack.CorrelationId)
+	}
+	if id, _ := ack.Headers["DSId"].(string); len(id) != 36 {
+		t.Errorf("expected issued DSId, got %#v", ack.Headers["DSId"])
+	}
+
+	ack, ok = res.Messages[2].Body.(*AcknowledgeMessage)
+	if !ok {
+		t.Fatalf("expected ack for call, got %#v", res.Messages[2].Body)
+	}
+	if ack.CorrelationId != "call" || ack.Headers["DSId"] != "client" {
+		t.Errorf("unexpected ack %#v", ack)
+	}
+	var summoner gatewaySummoner
+	if err := Convert(ack.Body, &summoner); err != nil || summoner.Name != "alfie" || summoner.Level != 30 {
+		t.Errorf("unexpected result %#v: %v", ack.Body, err)
+	}
+
+	fault, ok := res.Messages[3].Body.(*ErrorMessage)
+	if !ok || fault.FaultCode != STATUS_RESOURCE_UNAVAILABLE {
+		t.Errorf("expected resource fault, got %#v", res.Messages[3].Body)
+	}
+
+	fault, ok = res.Messages[4].Body.(*ErrorMessage)
+	if !ok || fault.FaultCode != STATUS_AUTHENTICATION {
+		t.Errorf("expected authentication fault, got %#v", res.Messages[4].Body)
+	}
+
+	if len(logins) != 3 || logins[0] != "alfie:secret" || logins[1] != "alfie:wrong" || logins[2] != "alfie:secret" {
+		t.Errorf("unexpected logins %v", logins)
+	}
+
+	if res.Messages[5].Body != float64(3) {
+		t.Errorf("expected classic call result 3, got %#v", res.Messages[5].Body)
+	}
+}
+
+func TestEndpointLoginRequired(t *testing.T) {
+	e := NewEndpoint()
+	if err := e.Register("SummonerService", new(gatewayService)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	e.Broker = NewBroker()
+	e.Broker.AddDestination("chat")
+
+	e.Login = func(username, password string) error {
+		if password != "secret" {
+			return errors.New("bad password")
+		}
+		return nil
+	}
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	call := new(RemotingMessage)
+	call.Destination = "SummonerService"
+	call.Operation = "sum"
+	call.Headers = Object{"DSId": "client"}
+	call.Body = Array{1, 2}
+
+	subscribe := new(CommandMessage)
+	subscribe.Operation = COMMAND_SUBSCRIBE_OPERATION
+	subscribe.Destination = "chat"
+	subscribe.ClientId = "consumer"
+	subscribe.Headers = Object{"DSId": "client"}
+
+	login := new(CommandMessage)
+	login.Operation = COMMAND_LOGIN_OPERATION
+	login.Headers = Object{"DSId": "client"}
+	login.Body = base64.StdEncoding.EncodeToString([]byte("alfie:secret"))
+
+	logout := new(CommandMessage)
+	logout.Operation = COMMAND_LOGOUT_OPERATION
+	logout.Headers = Object{"DSId": "client"}
+
+	res := gatewayRoundTrip(t, srv.URL, &Packet{
+		Version: AMF3,
+		Messages: []Message{
+			{TargetUri: "null", ResponseUri: "/1", Body: Array{call}},
+			{TargetUri: "null", ResponseUri: "/2", Body: Array{subscribe}},
+			{TargetUri: "SummonerService.sum", ResponseUri: "/3", Body: Array{1, 2}},
+			{TargetUri: "null", ResponseUri: "/4", Body: Array{login}},
+			{TargetUri: "null", ResponseUri: "/5", Body: Array{call}},
+			{TargetUri: "null", ResponseUri: "/6", Body: Array{subscribe}},
+			{TargetUri: "null", ResponseUri: "/7", Body: Array{logout}},
+			{TargetUri: "null", ResponseUri: "/8", Body: Array{call}},
+		},
+	})
+
+	targets := []string{"/1/onStatus", "/2/onStatus", "/3/onStatus", "/4/onResult", "/5/onResult", "/6/onResult", "/7/onResult", "/8/onStatus"}
+	if len(res.Messages) != len(targets) {
+		t.Fatalf("expected %d messages, got %d", len(targets), len(res.Messages))
+	}
+
+	for i, target := range targets {
+		if res.Messages[i].TargetUri != target {
+			t.Errorf("expected message %d to target %s, got %s", i, target, res.Messages[i].TargetUri)
+		}
+	}
+
+	for _, i := range []int{0, 1, 7} {
+		if fault, ok := res.Messages[i].Body.(*ErrorMessage); !ok || fault.FaultCode != STATUS_AUTHENTICATION {
+			t.Errorf("expected authentication fault for message %d, got %#v", i, res.Messages[i].Body)
+		}
+	}
+
+	var status StatusError
+	if err := Convert(res.Messages[2].Body, &status); err != nil || status.Code != STATUS_AUTHENTICATION {
+		t.Errorf("expected authentication status for classic call, got %#v", res.Messages[2].Body)
+	}
+
+	for i, msg := range res.Messages {
+		if msg.ResponseUri != "null" {
+			t.Errorf("expected message %d to have response uri null, got %q", i, msg.ResponseUri)
+		}
+	}
+
+	// clients that are not logged in can neither log out nor disconnect
+	for _, op := range []int32{COMMAND_LOGOUT_OPERATION, COMMAND_DISCONNECT_OPERATION} {
+		cmd := new(CommandMessage)
+		cmd.Operation = op
+		cmd.Headers = Object{"DSId": "stranger"}
+
+		if fault, ok := e.serveFlex(cmd).(*ErrorMessage); !ok || fault.FaultCode != STATUS_AUTHENTICATION {
+			t.Errorf("expected authentication fault for operation %d", op)
+		}
+	}
+}
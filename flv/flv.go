@@ -0,0 +1,251 @@
+// Package flv reads and writes flash video files tag by tag, with the
+// script data of their onMetaData and onCuePoint tags decoded by package amf.
+package flv
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// tag types
+const (
+	TAG_AUDIO  = 8
+	TAG_VIDEO  = 9
+	TAG_SCRIPT = 18
+)
+
+const (
+	FILE_HEADER_SIZE = 9
+	TAG_HEADER_SIZE  = 11
+	MAX_DATA_SIZE    = 0xffffff
+	TAG_FILTER       = 0x20
+)
+
+// header type flags
+const (
+	FLAG_VIDEO = 0x01
+	FLAG_AUDIO = 0x04
+)
+
+// video frame types, the upper 4 bits of the first byte of video data
+const (
+	VIDEO_FRAME_KEY        = 1
+	VIDEO_FRAME_INTER      = 2
+	VIDEO_FRAME_DISPOSABLE = 3
+	VIDEO_FRAME_GENERATED  = 4
+	VIDEO_FRAME_VIDEO_INFO = 5
+)
+
+type Header struct {
+	Version  uint8
+	HasAudio bool
+	HasVideo bool
+}
+
+// Tag is an audio, video or script data tag. Script data is the same as
+// the payload of an amf0 rtmp data message. Filter is set for tags whose
+// data is encrypted.
+type Tag struct {
+	Type      uint8
+	Filter    bool
+	Timestamp uint32
+	StreamId  uint32
+	Data      []byte
+}
+
+// Keyframe reports whether t is a video tag of a key frame.
+func (t *Tag) Keyframe() bool {
+	return t.Type == TAG_VIDEO && len(t.Data) > 0 && t.Data[0]>>4 == VIDEO_FRAME_KEY
+}
+
+// Size returns the number of bytes t takes in a file, including the
+// previous tag size that follows it.
+func (t *Tag) Size() int64 {
+	return TAG_HEADER_SIZE + int64(len(t.Data)) + 4
+}
+
+// Reader reads the tags of a file.
+type Reader struct {
+	r      io.Reader
+	header Header
+	offset int64
+}
+
+// format:
+// - 3 byte signature "FLV"
+// - 1 byte version
+// - 1 byte type flags, 0x04 audio and 0x01 video
+// - 4 byte big endian uint32 header size, usually 9
+// - 4 byte big endian uint32 previous tag size, always 0
+func NewReader(r io.Reader) (*Reader, error) {
+	fr := &Reader{r: r}
+
+	b := make([]byte, FILE_HEADER_SIZE)
+	if err := fr.read(b); err != nil {
+		return nil, amf.Error("flv: unable to read header: %s", err)
+	}
+
+	if string(b[:3]) != "FLV" {
+		return nil, amf.Error("flv: unexpected signature %q", b[:3])
+	}
+
+	fr.header = Header{
+		Version:  b[3],
+		HasAudio: b[4]&FLAG_AUDIO != 0,
+		HasVideo: b[4]&FLAG_VIDEO != 0,
+	}
+
+	size := binary.BigEndian.Uint32(b[5:])
+	if size < FILE_HEADER_SIZE {
+		return nil, amf.Error("flv: invalid header size %d", size)
+	}
+
+	// skip the rest of the header and the first previous tag size
+	if err := fr.skip(int64(size) - FILE_HEADER_SIZE + 4); err != nil {
+		return nil, amf.Error("flv: unable to read header: %s", err)
+	}
+
+	return fr, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Offset returns the position in the file of the next tag.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// skip discards n bytes, which may be claimed by the input without being
+// there.
+func (r *Reader) skip(n int64) error {
+	m, err := io.CopyN(ioutil.Discard, r.r, n)
+	r.offset += m
+	return err
+}
+
+func (r *Reader) read(p []byte) error {
+	n, err := io.ReadFull(r.r, p)
+	r.offset += int64(n)
+	return err
+}
+
+// ReadTag reads the next tag, returning io.EOF after the last one.
+//
+// format:
+//   - 1 byte type, the upper 3 bits being reserved or the filter flag
+//   - 3 byte big endian data size
+//   - 3 byte big endian timestamp in milliseconds
+//   - 1 byte upper 8 bits of the timestamp
+//   - 3 byte big endian stream id, always 0
+//   - data
+//   - 4 byte big endian uint32 previous tag size, the size of the tag
+//     excluding this field
+func (r *Reader) ReadTag() (*Tag, error) {
+	b := make([]byte, TAG_HEADER_SIZE)
+	if err := r.read(b); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, amf.Error("flv: unable to read tag header: %s", err)
+	}
+
+	t := &Tag{
+		Type:      b[0] & 0x1f,
+		Filter:    b[0]&TAG_FILTER != 0,
+		Timestamp: uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6]) | uint32(b[7])<<24,
+		StreamId:  uint32(b[8])<<16 | uint32(b[9])<<8 | uint32(b[10]),
+		Data:      make([]byte, uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3])),
+	}
+
+	if err := r.read(t.Data); err != nil {
+		return nil, amf.Error("flv: unable to read tag data: %s", err)
+	}
+
+	if err := r.read(b[:4]); err != nil {
+		return nil, amf.Error("flv: unable to read previous tag size: %s", err)
+	}
+
+	if size := binary.BigEndian.Uint32(b); int64(size) != t.Size()-4 {
+		return nil, amf.Error("flv: previous tag size %d does not match tag of %d bytes", size, t.Size()-4)
+	}
+
+	return t, nil
+}
+
+// Writer writes the header and tags of a file.
+type Writer struct {
+	w      io.Writer
+	offset int64
+}
+
+// NewWriter writes h to w, with the same format as NewReader.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	b := []byte{'F', 'L', 'V', h.Version, 0, 0, 0, 0, FILE_HEADER_SIZE, 0, 0, 0, 0}
+	if b[3] == 0 {
+		b[3] = 1
+	}
+	if h.HasAudio {
+		b[4] |= FLAG_AUDIO
+	}
+	if h.HasVideo {
+		b[4] |= FLAG_VIDEO
+	}
+
+	fw := &Writer{w: w}
+	if err := fw.write(b); err != nil {
+		return nil, amf.Error("flv: unable to write header: %s", err)
+	}
+
+	return fw, nil
+}
+
+// Offset returns the position in the file of the next tag.
+func (w *Writer) Offset() int64 {
+	return w.offset
+}
+
+func (w *Writer) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	return err
+}
+
+// same format as ReadTag
+func (w *Writer) WriteTag(t *Tag) error {
+	if len(t.Data) > MAX_DATA_SIZE {
+		return amf.Error("flv: tag data of %d bytes is too long", len(t.Data))
+	}
+
+	typ := t.Type & 0x1f
+	if t.Filter {
+		typ |= TAG_FILTER
+	}
+
+	size := len(t.Data)
+	b := []byte{
+		typ,
+		byte(size >> 16), byte(size >> 8), byte(size),
+		byte(t.Timestamp >> 16), byte(t.Timestamp >> 8), byte(t.Timestamp), byte(t.Timestamp >> 24),
+		byte(t.StreamId >> 16), byte(t.StreamId >> 8), byte(t.StreamId),
+	}
+
+	if err := w.write(b); err != nil {
+		return amf.Error("flv: unable to write tag header: %s", err)
+	}
+
+	if err := w.write(t.Data); err != nil {
+		return amf.Error("flv: unable to write tag data: %s", err)
+	}
+
+	binary.BigEndian.PutUint32(b, uint32(t.Size()-4))
+	if err := w.write(b[:4]); err != nil {
+		return amf.Error("flv: unable to write previous tag size: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReadTag(t *testing.T) {
+	buf := bytes.NewReader([]byte{
+		'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x00, 0x00, 0x00,
+		0x09, 0x00, 0x00, 0x02, 0x00, 0x01, 0x02, 0x01, 0x00, 0x00, 0x00,
+		0x17, 0x00,
+		0x00, 0x00, 0x00, 0x0d,
+		0x28, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xaf,
+		0x00, 0x00, 0x00, 0x0c,
+	})
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if h := r.Header(); h != (Header{Version: 1, HasAudio: true, HasVideo: true}) {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	if r.Offset() != 13 {
+		t.Errorf("expected first tag at 13, got %d", r.Offset())
+	}
+
+	tag, err := r.ReadTag()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expect := &Tag{Type: TAG_VIDEO, Timestamp: 0x01000102, Data: []byte{0x17, 0x00}}
+	if !reflect.DeepEqual(tag, expect) {
+		t.Errorf("expected %+v, got %+v", expect, tag)
+	}
+
+	if !tag.Keyframe() {
+		t.Errorf("expected keyframe")
+	}
+
+	if r.Offset() != 13+tag.Size() {
+		t.Errorf("expected next tag at %d, got %d", 13+tag.Size(), r.Offset())
+	}
+
+	tag, err = r.ReadTag()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if tag.Type != TAG_AUDIO || !tag.Filter || tag.Keyframe() {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+
+	if _, err = r.ReadTag(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadTagErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("FLX\x01\x05\x00\x00\x00\x09\x00\x00\x00\x00"))); err == nil {
+		t.Errorf("expected error for bad signature")
+	}
+
+	r, err := NewReader(bytes.NewReader([]byte{
+		'F', 'L', 'V', 0x01, 0x04, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x00, 0x00, 0x00,
+		0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xaf,
+		0x00, 0x00, 0x00, 0x0d,
+	}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if _, err = r.ReadTag(); err == nil {
+		t.Errorf("expected error for previous tag size")
+	}
+}
+
+func TestReadHeaderSize(t *testing.T) {
+	r, err := NewReader(bytes.NewReader([]byte{
+		'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x0b,
+		0xaa, 0xbb,
+		0x00, 0x00, 0x00, 0x00,
+	}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if r.Offset() != 15 {
+		t.Errorf("expected offset 15, got %d", r.Offset())
+	}
+
+	if _, err = NewReader(bytes.NewReader([]byte{
+		'F', 'L', 'V', 0x01, 0x05, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00,
+	})); err == nil {
+		t.Errorf("expected error for header size beyond input")
+	}
+}
+
+func TestWriteTag(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	w, err := NewWriter(buf, Header{HasVideo: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	tags := []*Tag{
+		{Type: TAG_VIDEO, Timestamp: 0x01000102, Data: []byte{0x17, 0x00}},
+		{Type: TAG_AUDIO, Filter: true, Timestamp: 40, Data: []byte{0xaf}},
+	}
+
+	for _, tag := range tags {
+		if err = w.WriteTag(tag); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	if w.Offset() != int64(buf.Len()) {
+		t.Errorf("expected offset %d, got %d", buf.Len(), w.Offset())
+	}
+
+	if expect := []byte{'F', 'L', 'V', 0x01, 0x01, 0x00, 0x00, 0x00, 0x09}; !bytes.HasPrefix(buf.Bytes(), expect) {
+		t.Errorf("expected header %#v, got %#v", expect, buf.Bytes()[:9])
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, expect := range tags {
+		tag, err := r.ReadTag()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if !reflect.DeepEqual(tag, expect) {
+			t.Errorf("expected %+v, got %+v", expect, tag)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// Rewrite copies the file read from r to w with a single onMetaData tag
+// ahead of the other tags, replacing any the file has. The metadata keeps
+// the properties of the first onMetaData of the file, with the duration and
+// the keyframe index set from its tags. update, if not nil, may change the
+// metadata before it is written, except for the keyframe file positions and
+// the file size, which are always those of the rewritten file. Audio, video
+// and other script tags are copied unchanged.
+func Rewrite(w io.Writer, r io.ReadSeeker, update func(*Metadata)) error {
+	fr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	m := &Metadata{}
+	found := false
+
+	// positions of key frames relative to the first tag after the metadata
+	var positions, times []float64
+	var duration float64
+	var size int64
+
+	for {
+		t, err := fr.ReadTag()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if onMetaData(t) {
+			if !found {
+				_, obj, err := DecodeScript(nil, t.Data)
+				if err != nil {
+					return err
+				}
+
+				if m, err = ParseMetadata(obj); err != nil {
+					return err
+				}
+				found = true
+			}
+			continue
+		}
+
+		if t.Type == TAG_AUDIO || t.Type == TAG_VIDEO {
+			duration = float64(t.Timestamp) / 1000
+		}
+
+		if t.Keyframe() {
+			positions = append(positions, float64(size))
+			times = append(times, float64(t.Timestamp)/1000)
+		}
+
+		size += t.Size()
+	}
+
+	m.Duration = duration
+	m.Keyframes = Keyframes{Times: times}
+	if update != nil {
+		update(m)
+	}
+
+	// numbers are 8 bytes whatever their value, so the metadata is as long
+	// with placeholders as with the offsets they are replaced by
+	m.FileSize = 1
+	if len(m.Keyframes.Times) > 0 {
+		if len(m.Keyframes.Times) != len(positions) {
+			return amf.Error("flv: %d keyframe times for %d keyframes", len(m.Keyframes.Times), len(positions))
+		}
+		m.Keyframes.FilePositions = make([]float64, len(positions))
+	} else {
+		// leave out the index of the file the metadata was written for
+		delete(m.Properties, "keyframes")
+	}
+
+	data, err := EncodeScript(nil, "onMetaData", m.Object())
+	if err != nil {
+		return err
+	}
+
+	meta := &Tag{Type: TAG_SCRIPT, Data: data}
+	base := FILE_HEADER_SIZE + 4 + meta.Size()
+
+	for i := range m.Keyframes.FilePositions {
+		m.Keyframes.FilePositions[i] = float64(base) + positions[i]
+	}
+	m.FileSize = float64(base + size)
+
+	if meta.Data, err = EncodeScript(nil, "onMetaData", m.Object()); err != nil {
+		return err
+	}
+
+	if len(meta.Data) != len(data) {
+		return amf.Error("flv: metadata changed length from %d to %d bytes", len(data), len(meta.Data))
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return amf.Error("flv: unable to seek to start: %s", err)
+	}
+
+	if fr, err = NewReader(r); err != nil {
+		return err
+	}
+
+	fw, err := NewWriter(w, fr.Header())
+	if err != nil {
+		return err
+	}
+
+	if err = fw.WriteTag(meta); err != nil {
+		return err
+	}
+
+	for {
+		t, err := fr.ReadTag()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if onMetaData(t) {
+			continue
+		}
+
+		if err = fw.WriteTag(t); err != nil {
+			return err
+		}
+	}
+}
+
+// onMetaData reports whether t is an onMetaData script tag.
+func onMetaData(t *Tag) bool {
+	if t.Type != TAG_SCRIPT {
+		return false
+	}
+
+	name, err := amf.NewDecoder().DecodeAmf0String(bytes.NewReader(t.Data), true)
+
+	return err == nil && name == "onMetaData"
+}
@@ -0,0 +1,152 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	amf "github.com/elobuff/goamf"
+)
+
+func writeFile(t *testing.T, tags []*Tag) []byte {
+	buf := new(bytes.Buffer)
+
+	w, err := NewWriter(buf, Header{HasAudio: true, HasVideo: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, tag := range tags {
+		if err = w.WriteTag(tag); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func readFile(t *testing.T, data []byte) (tags []*Tag, offsets []int64) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for {
+		offset := r.Offset()
+
+		tag, err := r.ReadTag()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		tags = append(tags, tag)
+		offsets = append(offsets, offset)
+	}
+}
+
+func testRewrite(t *testing.T, in []*Tag, update func(*Metadata)) *Metadata {
+	file := writeFile(t, in)
+
+	out := new(bytes.Buffer)
+	if err := Rewrite(out, bytes.NewReader(file), update); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	tags, offsets := readFile(t, out.Bytes())
+
+	name, obj, err := DecodeScript(nil, tags[0].Data)
+	if err != nil || name != "onMetaData" {
+		t.Fatalf("expected onMetaData first, got %s: %v", name, err)
+	}
+
+	m, err := ParseMetadata(obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if m.FileSize != float64(out.Len()) {
+		t.Errorf("expected file size %d, got %f", out.Len(), m.FileSize)
+	}
+
+	// every tag but onMetaData is copied unchanged
+	var copied []*Tag
+	for _, tag := range in {
+		if !onMetaData(tag) {
+			copied = append(copied, tag)
+		}
+	}
+
+	if !reflect.DeepEqual(tags[1:], copied) {
+		t.Errorf("expected tags %+v, got %+v", copied, tags[1:])
+	}
+
+	var times, positions []float64
+	for i, tag := range tags {
+		if tag.Keyframe() {
+			times = append(times, float64(tag.Timestamp)/1000)
+			positions = append(positions, float64(offsets[i]))
+		}
+	}
+
+	if !reflect.DeepEqual(m.Keyframes.Times, times) || !reflect.DeepEqual(m.Keyframes.FilePositions, positions) {
+		t.Errorf("expected keyframes %v at %v, got %+v", times, positions, m.Keyframes)
+	}
+
+	return m
+}
+
+func TestRewrite(t *testing.T) {
+	meta, err := EncodeScript(nil, "onMetaData", amf.Object{
+		"duration": float64(1),
+		"width":    float64(320),
+		"encoder":  "test",
+		"keyframes": amf.Object{
+			"filepositions": amf.Array{float64(1)},
+			"times":         amf.Array{float64(0)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	cue, err := EncodeScript(nil, "onCuePoint", (&CuePoint{Name: "a", Time: 1, Type: "event"}).Object())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	in := []*Tag{
+		{Type: TAG_SCRIPT, Data: meta},
+		{Type: TAG_VIDEO, Data: []byte{0x17, 0x00, 0x01}},
+		{Type: TAG_AUDIO, Data: []byte{0xaf, 0x01}},
+		{Type: TAG_VIDEO, Timestamp: 1000, Data: []byte{0x27, 0x01}},
+		{Type: TAG_SCRIPT, Timestamp: 1000, Data: cue},
+		{Type: TAG_VIDEO, Timestamp: 2000, Data: []byte{0x17, 0x01, 0x02, 0x03}},
+		{Type: TAG_AUDIO, Timestamp: 2500, Data: []byte{0xaf, 0x01}},
+	}
+
+	m := testRewrite(t, in, func(m *Metadata) {
+		m.Height = 240
+	})
+
+	if m.Duration != 2.5 || m.Width != 320 || m.Height != 240 || m.Properties["encoder"] != "test" {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+}
+
+func TestRewriteInject(t *testing.T) {
+	in := []*Tag{
+		{Type: TAG_VIDEO, Data: []byte{0x17, 0x00}},
+		{Type: TAG_VIDEO, Timestamp: 40, Data: []byte{0x27, 0x01}},
+		{Type: TAG_VIDEO, Timestamp: 80, Data: []byte{0x17, 0x01}},
+	}
+
+	m := testRewrite(t, in, nil)
+
+	if m.Duration != 0.08 {
+		t.Errorf("expected duration 0.08, got %f", m.Duration)
+	}
+}
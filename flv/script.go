@@ -0,0 +1,195 @@
+package flv
+
+import (
+	"bytes"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// DecodeScript decodes the data of a script tag with d, which may be nil.
+// Its properties are usually an ecma array, but an object, as some
+// encoders write onCuePoint, is accepted as well.
+//
+// format:
+// - amf0 string name, e.g. onMetaData or onCuePoint
+// - amf0 ecma array or object of properties
+func DecodeScript(d *amf.Decoder, data []byte) (string, amf.Object, error) {
+	if d == nil {
+		d = amf.NewDecoder()
+	}
+	d.Reset()
+
+	r := bytes.NewReader(data)
+
+	name, err := d.DecodeAmf0String(r, true)
+	if err != nil {
+		return "", nil, amf.Error("flv: unable to decode script name: %s", err)
+	}
+
+	if r.Len() == 0 {
+		return name, nil, nil
+	}
+
+	if data[len(data)-r.Len()] == amf.AMF0_ECMA_ARRAY_MARKER {
+		obj, err := d.DecodeAmf0EcmaArray(r, true)
+		if err != nil {
+			return "", nil, amf.Error("flv: unable to decode %s properties: %s", name, err)
+		}
+
+		return name, obj, nil
+	}
+
+	v, err := d.DecodeAmf0(r)
+	if err != nil {
+		return "", nil, amf.Error("flv: unable to decode %s properties: %s", name, err)
+	}
+
+	obj, ok := v.(amf.Object)
+	if !ok {
+		return "", nil, amf.Error("flv: %s properties are %T, not an object", name, v)
+	}
+
+	return name, obj, nil
+}
+
+// EncodeScript encodes name and its properties as an ecma array with e,
+// which may be nil.
+func EncodeScript(e *amf.Encoder, name string, obj amf.Object) ([]byte, error) {
+	if e == nil {
+		e = new(amf.Encoder)
+	}
+	e.Reset()
+
+	buf := new(bytes.Buffer)
+
+	if _, err := e.EncodeAmf0String(buf, name, true); err != nil {
+		return nil, amf.Error("flv: unable to encode script name: %s", err)
+	}
+
+	if _, err := e.EncodeAmf0EcmaArray(buf, obj, true); err != nil {
+		return nil, amf.Error("flv: unable to encode %s properties: %s", name, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Metadata is the onMetaData of a file. Properties holds every property it
+// was parsed from, including those without a field here.
+type Metadata struct {
+	Duration     float64
+	Width        float64
+	Height       float64
+	FrameRate    float64
+	VideoCodecId float64
+	AudioCodecId float64
+	FileSize     float64
+	Keyframes    Keyframes
+	Properties   amf.Object
+}
+
+// Keyframes is the keyframe index players seek with, the times in seconds
+// of key frames and the positions of their tags in the file.
+type Keyframes struct {
+	FilePositions []float64 `amf:"filepositions"`
+	Times         []float64 `amf:"times"`
+}
+
+// ParseMetadata returns the metadata of the properties of an onMetaData
+// script tag. Properties that are not numbers, e.g. codec ids written as
+// strings, are only kept in Properties.
+func ParseMetadata(obj amf.Object) (*Metadata, error) {
+	m := &Metadata{Properties: obj}
+
+	number(obj, "duration", &m.Duration)
+	number(obj, "width", &m.Width)
+	number(obj, "height", &m.Height)
+	number(obj, "framerate", &m.FrameRate)
+	number(obj, "videocodecid", &m.VideoCodecId)
+	number(obj, "audiocodecid", &m.AudioCodecId)
+	number(obj, "filesize", &m.FileSize)
+
+	if v, ok := obj["keyframes"]; ok && v != nil {
+		if err := amf.Convert(v, &m.Keyframes); err != nil {
+			return nil, amf.Error("flv: unable to parse keyframes: %s", err)
+		}
+	}
+
+	return m, nil
+}
+
+func number(obj amf.Object, key string, f *float64) {
+	if v, ok := obj[key].(float64); ok {
+		*f = v
+	}
+}
+
+// Object returns the properties of m, with the fields set over those it
+// was parsed from. Fields other than the duration are left out while zero.
+func (m *Metadata) Object() amf.Object {
+	obj := make(amf.Object, len(m.Properties)+8)
+	for k, v := range m.Properties {
+		obj[k] = v
+	}
+
+	obj["duration"] = m.Duration
+
+	set := func(key string, f float64) {
+		if f != 0 {
+			obj[key] = f
+		}
+	}
+
+	set("width", m.Width)
+	set("height", m.Height)
+	set("framerate", m.FrameRate)
+	set("videocodecid", m.VideoCodecId)
+	set("audiocodecid", m.AudioCodecId)
+	set("filesize", m.FileSize)
+
+	if len(m.Keyframes.Times) > 0 {
+		obj["keyframes"] = amf.Object{
+			"filepositions": numbers(m.Keyframes.FilePositions),
+			"times":         numbers(m.Keyframes.Times),
+		}
+	}
+
+	return obj
+}
+
+func numbers(fs []float64) amf.Array {
+	arr := make(amf.Array, len(fs))
+	for i, f := range fs {
+		arr[i] = f
+	}
+
+	return arr
+}
+
+// CuePoint is an onCuePoint, an event or navigation point of a file.
+type CuePoint struct {
+	Name       string     `amf:"name"`
+	Time       float64    `amf:"time"`
+	Type       string     `amf:"type"`
+	Parameters amf.Object `amf:"parameters"`
+}
+
+// ParseCuePoint returns the cue point of the properties of an onCuePoint
+// script tag.
+func ParseCuePoint(obj amf.Object) (*CuePoint, error) {
+	c := new(CuePoint)
+	if err := amf.Convert(obj, c); err != nil {
+		return nil, amf.Error("flv: unable to parse cue point: %s", err)
+	}
+
+	return c, nil
+}
+
+// Object returns the properties of c.
+func (c *CuePoint) Object() amf.Object {
+	obj := amf.Object{"name": c.Name, "time": c.Time, "type": c.Type}
+	if c.Parameters != nil {
+		obj["parameters"] = c.Parameters
+	}
+
+	return obj
+}
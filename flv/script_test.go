@@ -0,0 +1,111 @@
+package flv
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	amf "github.com/elobuff/goamf"
+)
+
+func TestDecodeScript(t *testing.T) {
+	data := []byte{
+		0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a',
+		0x08, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x05, 'w', 'i', 'd', 't', 'h', 0x00, 0x40, 0x74, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x09,
+	}
+
+	name, obj, err := DecodeScript(nil, data)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if name != "onMetaData" || obj["width"] != float64(320) {
+		t.Errorf("unexpected script %s %#v", name, obj)
+	}
+
+	encoded, err := EncodeScript(nil, name, obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if bytes.Compare(encoded, data) != 0 {
+		t.Errorf("expected buffer: %#v, got: %#v", data, encoded)
+	}
+}
+
+func TestDecodeScriptObject(t *testing.T) {
+	data := []byte{
+		0x02, 0x00, 0x0a, 'o', 'n', 'C', 'u', 'e', 'P', 'o', 'i', 'n', 't',
+		0x03,
+		0x00, 0x04, 'n', 'a', 'm', 'e', 0x02, 0x00, 0x01, 'a',
+		0x00, 0x04, 't', 'i', 'm', 'e', 0x00, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x09,
+	}
+
+	name, obj, err := DecodeScript(nil, data)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if name != "onCuePoint" {
+		t.Errorf("expected onCuePoint, got %s", name)
+	}
+
+	c, err := ParseCuePoint(obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if c.Name != "a" || c.Time != 1.5 {
+		t.Errorf("unexpected cue point %+v", c)
+	}
+
+	if _, _, err = DecodeScript(nil, []byte{0x02, 0x00, 0x01, 'x', 0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Errorf("expected error for properties that are not an object")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	obj := amf.Object{
+		"duration":     float64(10),
+		"width":        float64(640),
+		"height":       float64(360),
+		"videocodecid": "avc1",
+		"encoder":      "test",
+		"keyframes": amf.Object{
+			"filepositions": amf.Array{float64(100), float64(200)},
+			"times":         amf.Array{float64(0), float64(5)},
+		},
+	}
+
+	m, err := ParseMetadata(obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if m.Duration != 10 || m.Width != 640 || m.Height != 360 || m.VideoCodecId != 0 {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+
+	expect := Keyframes{FilePositions: []float64{100, 200}, Times: []float64{0, 5}}
+	if !reflect.DeepEqual(m.Keyframes, expect) {
+		t.Errorf("expected keyframes %+v, got %+v", expect, m.Keyframes)
+	}
+
+	m.Height = 480
+	out := m.Object()
+
+	if out["height"] != float64(480) || out["videocodecid"] != "avc1" || out["encoder"] != "test" {
+		t.Errorf("unexpected properties %#v", out)
+	}
+
+	if _, ok := out["framerate"]; ok {
+		t.Errorf("expected zero framerate to be left out")
+	}
+
+	if !reflect.DeepEqual(out["keyframes"], obj["keyframes"]) {
+		t.Errorf("expected keyframes %#v, got %#v", obj["keyframes"], out["keyframes"])
+	}
+}
@@ -0,0 +1,108 @@
+package amf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+)
+
+const AMF_CONTENT_TYPE = "application/x-amf"
+
+// the largest request body read when Gateway.MaxRequestSize is zero
+const AMF_DEFAULT_MAX_REQUEST_SIZE = 10 << 20
+
+// Gateway is an http.Handler serving flash remoting requests. Each message
+// of a request packet names a registered service and method in its target
+// uri, e.g. "SummonerService.getSummoner". The method's result is sent back
+// to the message's response uri suffixed with /onResult, and errors are
+// sent as a StatusError suffixed with /onStatus.
+type Gateway struct {
+	// MaxRequestSize limits the size of request bodies, which are refused
+	// beyond it. AMF_DEFAULT_MAX_REQUEST_SIZE is used when it is zero.
+	MaxRequestSize int64
+
+	services serviceMap
+}
+
+func NewGateway() *Gateway {
+	return new(Gateway)
+}
+
+// Register exposes the exported methods of rcvr as service name. Methods may
+// take any parameters the decoded arguments can be converted to, and return
+// nothing, a result, an error, or a result and an error.
+func (g *Gateway) Register(name string, rcvr interface{}) error {
+	return g.services.register(name, rcvr)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.servePacket(w, r, g.Serve)
+}
+
+// servePacket reads the request packet of r, and writes the response packet
+// returned by serve for it.
+func (g *Gateway) servePacket(w http.ResponseWriter, r *http.Request, serve func(*Packet) *Packet) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "amf gateway: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := g.MaxRequestSize
+	if limit <= 0 {
+		limit = AMF_DEFAULT_MAX_REQUEST_SIZE
+	}
+
+	// the body is read whole, so that lengths claimed by the packet are
+	// checked against the bytes actually sent
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req, err := ReadPacket(bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := serve(req)
+
+	// the packet is buffered so that encoding errors can still be reported
+	buf := new(bytes.Buffer)
+	if _, err = WritePacket(buf, res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", AMF_CONTENT_TYPE)
+	w.Write(buf.Bytes())
+}
+
+// Serve calls the method named by each message of req and returns the
+// response packet, in the same version as req.
+func (g *Gateway) Serve(req *Packet) *Packet {
+	res := &Packet{Version: req.Version}
+
+	for _, msg := range req.Messages {
+		res.Messages = append(res.Messages, g.serveMessage(msg))
+	}
+
+	return res
+}
+
+func (g *Gateway) serveMessage(msg Message) Message {
+	result, err := g.services.callTarget(msg.TargetUri, msg.Body)
+
+	reply := Message{ResponseUri: "null"}
+	if err != nil {
+		reply.TargetUri = msg.ResponseUri + "/onStatus"
+		reply.Body = statusOf(err)
+	} else {
+		reply.TargetUri = msg.ResponseUri + "/onResult"
+		reply.Body = result
+	}
+
+	return reply
+}
@@ -0,0 +1,160 @@
+package amf
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type gatewaySummoner struct {
+	Name  string `amf:"name"`
+	Level int32  `amf:"level"`
+}
+
+type gatewayService struct{}
+
+func (s *gatewayService) GetSummoner(name string, level int) (*gatewaySummoner, error) {
+	if name == "" {
+		return nil, errors.New("no name given")
+	}
+
+	return &gatewaySummoner{Name: name, Level: int32(level)}, nil
+}
+
+func (s *gatewayService) Sum(values ...float64) (sum float64) {
+	for _, v := range values {
+		sum += v
+	}
+
+	return
+}
+
+func gatewayRoundTrip(t *testing.T, url string, req *Packet) *Packet {
+	buf := new(bytes.Buffer)
+	if _, err := WritePacket(buf, req); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	resp, err := http.Post(url, AMF_CONTENT_TYPE, buf)
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != AMF_CONTENT_TYPE {
+		t.Errorf("expected content type %s, got %s", AMF_CONTENT_TYPE, ct)
+	}
+
+	res, err := ReadPacket(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	return res
+}
+
+func TestGateway(t *testing.T) {
+	g := NewGateway()
+	if err := g.Register("SummonerService", new(gatewayService)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	for _, ver := range []Version{AMF0, AMF3} {
+		res := gatewayRoundTrip(t, srv.URL, &Packet{
+			Version: ver,
+			Messages: []Message{
+				{TargetUri: "SummonerService.getSummoner", ResponseUri: "/1", Body: Array{"alfie", 30}},
+				{TargetUri: "SummonerService.sum", ResponseUri: "/2", Body: Array{1, 2.5}},
+				{TargetUri: "SummonerService.getSummoner", ResponseUri: "/3", Body: Array{""}},
+				{TargetUri: "SummonerService.missing", ResponseUri: "/4"},
+			},
+		})
+
+		if res.Version != ver || len(res.Messages) != 4 {
+			t.Fatalf("unexpected response packet: %#v", res)
+		}
+
+		var summoner gatewaySummoner
+		if err := Convert(res.Messages[0].Body, &summoner); err != nil {
+			t.Errorf("convert: %s", err)
+		}
+
+		if res.Messages[0].TargetUri != "/1/onResult" || summoner.Name != "alfie" || summoner.Level != 30 {
+			t.Errorf("unexpected result: %#v", res.Messages[0])
+		}
+
+		if res.Messages[1].TargetUri != "/2/onResult" || res.Messages[1].Body != 3.5 {
+			t.Errorf("unexpected result: %#v", res.Messages[1])
+		}
+
+		for i, code := range []string{STATUS_PROCESSING, STATUS_RESOURCE_UNAVAILABLE} {
+			msg := res.Messages[i+2]
+
+			var status StatusError
+			if err := Convert(msg.Body, &status); err != nil {
+				t.Errorf("convert: %s", err)
+			}
+
+			if msg.TargetUri != msg.TargetUri[:2]+"/onStatus" || status.Code != code || status.Level != "error" {
+				t.Errorf("unexpected status: %#v", msg)
+			}
+		}
+	}
+}
+
+func TestGatewayBadRequest(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/gateway", bytes.NewReader([]byte{0x00, 0x09}))
+
+	NewGateway().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/gateway", nil)
+
+	NewGateway().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", rec.Code)
+	}
+}
+
+func TestGatewayMaxRequestSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	WritePacket(buf, &Packet{Messages: []Message{{TargetUri: "Missing.method", ResponseUri: "/1", Body: Array{}}}})
+
+	g := NewGateway()
+	g.MaxRequestSize = int64(buf.Len() - 1)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/gateway", bytes.NewReader(buf.Bytes()))
+
+	g.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+
+	g.MaxRequestSize = int64(buf.Len())
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/gateway", bytes.NewReader(buf.Bytes()))
+
+	g.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+}
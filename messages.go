@@ -0,0 +1,322 @@
+package amf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// operations of a CommandMessage
+const (
+	COMMAND_SUBSCRIBE_OPERATION               = 0
+	COMMAND_UNSUBSCRIBE_OPERATION             = 1
+	COMMAND_POLL_OPERATION                    = 2
+	COMMAND_CLIENT_SYNC_OPERATION             = 4
+	COMMAND_CLIENT_PING_OPERATION             = 5
+	COMMAND_CLUSTER_REQUEST_OPERATION         = 7
+	COMMAND_LOGIN_OPERATION                   = 8
+	COMMAND_LOGOUT_OPERATION                  = 9
+	COMMAND_SUBSCRIPTION_INVALIDATE_OPERATION = 10
+	COMMAND_MULTI_SUBSCRIBE_OPERATION         = 11
+	COMMAND_DISCONNECT_OPERATION              = 12
+	COMMAND_TRIGGER_CONNECT_OPERATION         = 13
+	COMMAND_UNKNOWN_OPERATION                 = 10000
+)
+
+// headers of flex messages
+const (
+	MESSAGE_FLEX_CLIENT_ID_HEADER       = "DSId"
+	MESSAGE_SUBTOPIC_HEADER             = "DSSubtopic"
+	COMMAND_SELECTOR_HEADER             = "DSSelector"
+	COMMAND_ADD_SUBSCRIPTIONS_HEADER    = "DSAddSub"
+	COMMAND_REMOVE_SUBSCRIPTIONS_HEADER = "DSRemSub"
+	COMMAND_SUBTOPIC_SEPARATOR          = "_;_"
+)
+
+// AbstractMessage holds the members shared by all flex messages. Message and
+// client ids sent as 16 byte uuids in the small message forms are surfaced as
+// their usual string representation.
+type AbstractMessage struct {
+	Body        interface{} `amf:"body"`
+	ClientId    string      `amf:"clientId"`
+	Destination string      `amf:"destination"`
+	Headers     Object      `amf:"headers"`
+	MessageId   string      `amf:"messageId"`
+	Timestamp   int64       `amf:"timestamp"`
+	TimeToLive  int64       `amf:"timeToLive"`
+}
+
+// flex.messaging.messages.AsyncMessage
+type AsyncMessage struct {
+	AbstractMessage
+	CorrelationId string `amf:"correlationId"`
+}
+
+// flex.messaging.messages.AcknowledgeMessage
+type AcknowledgeMessage struct {
+	AsyncMessage
+}
+
+// flex.messaging.messages.CommandMessage
+type CommandMessage struct {
+	AsyncMessage
+	Operation int32 `amf:"operation"`
+}
+
+// flex.messaging.messages.RemotingMessage
+type RemotingMessage struct {
+	AbstractMessage
+	Operation string `amf:"operation"`
+	Source    string `amf:"source"`
+}
+
+// flex.messaging.messages.ErrorMessage
+type ErrorMessage struct {
+	AcknowledgeMessage
+	FaultCode    string      `amf:"faultCode"`
+	FaultString  string      `amf:"faultString"`
+	FaultDetail  string      `amf:"faultDetail"`
+	RootCause    interface{} `amf:"rootCause"`
+	ExtendedData interface{} `amf:"extendedData"`
+}
+
+// AsyncMessageExt is the small message form of AsyncMessage (DSA).
+type AsyncMessageExt struct {
+	AsyncMessage
+}
+
+// AcknowledgeMessageExt is the small message form of AcknowledgeMessage (DSK).
+type AcknowledgeMessageExt struct {
+	AcknowledgeMessage
+}
+
+// CommandMessageExt is the small message form of CommandMessage (DSC).
+type CommandMessageExt struct {
+	CommandMessage
+}
+
+func init() {
+	RegisterClassAlias("flex.messaging.messages.AsyncMessage", AsyncMessage{})
+	RegisterClassAlias("flex.messaging.messages.AcknowledgeMessage", AcknowledgeMessage{})
+	RegisterClassAlias("flex.messaging.messages.CommandMessage", CommandMessage{})
+	RegisterClassAlias("flex.messaging.messages.RemotingMessage", RemotingMessage{})
+	RegisterClassAlias("flex.messaging.messages.ErrorMessage", ErrorMessage{})
+
+	RegisterExternalizable("DSA", &AsyncMessageExt{})
+	RegisterExternalizable("DSK", &AcknowledgeMessageExt{})
+	RegisterExternalizable("DSC", &CommandMessageExt{})
+}
+
+func (m *AsyncMessageExt) ReadExternal(d *Decoder, r io.Reader) error {
+	return m.AsyncMessage.readExternal(d, r)
+}
+
+func (m *AsyncMessageExt) WriteExternal(e *Encoder, w io.Writer) (int, error) {
+	return m.AsyncMessage.writeExternal(e, w)
+}
+
+func (m *AcknowledgeMessageExt) ReadExternal(d *Decoder, r io.Reader) error {
+	return m.AcknowledgeMessage.readExternal(d, r)
+}
+
+func (m *AcknowledgeMessageExt) WriteExternal(e *Encoder, w io.Writer) (int, error) {
+	return m.AcknowledgeMessage.writeExternal(e, w)
+}
+
+func (m *CommandMessageExt) ReadExternal(d *Decoder, r io.Reader) error {
+	return m.CommandMessage.readExternal(d, r)
+}
+
+func (m *CommandMessageExt) WriteExternal(e *Encoder, w io.Writer) (int, error) {
+	return m.CommandMessage.writeExternal(e, w)
+}
+
+// format:
+// - flags: body, clientId, destination, headers, messageId, timestamp, timeToLive
+// - flags: clientIdBytes, messageIdBytes
+func (m *AbstractMessage) readExternal(d *Decoder, r io.Reader) (err error) {
+	var clientIdBytes, messageIdBytes []byte
+
+	err = d.decodeMessageFields(r,
+		[]interface{}{&m.Body, &m.ClientId, &m.Destination, &m.Headers, &m.MessageId, &m.Timestamp, &m.TimeToLive},
+		[]interface{}{&clientIdBytes, &messageIdBytes})
+	if err != nil {
+		return Error("unable to decode abstract external: %s", err)
+	}
+
+	if clientIdBytes != nil {
+		if m.ClientId, err = uuidFromBytes(clientIdBytes); err != nil {
+			return Error("unable to decode abstract external client id: %s", err)
+		}
+	}
+
+	if messageIdBytes != nil {
+		if m.MessageId, err = uuidFromBytes(messageIdBytes); err != nil {
+			return Error("unable to decode abstract external message id: %s", err)
+		}
+	}
+
+	return
+}
+
+func (m *AbstractMessage) writeExternal(e *Encoder, w io.Writer) (n int, err error) {
+	clientId, clientIdBytes := splitUUID(m.ClientId)
+	messageId, messageIdBytes := splitUUID(m.MessageId)
+
+	n, err = e.encodeMessageFields(w,
+		[]interface{}{m.Body, clientId, m.Destination, m.Headers, messageId, m.Timestamp, m.TimeToLive},
+		[]interface{}{clientIdBytes, messageIdBytes})
+	if err != nil {
+		return n, Error("unable to encode abstract external: %s", err)
+	}
+
+	return
+}
+
+// format:
+// - abstract message
+// - flags: correlationId, correlationIdBytes
+func (m *AsyncMessage) readExternal(d *Decoder, r io.Reader) (err error) {
+	if err = m.AbstractMessage.readExternal(d, r); err != nil {
+		return Error("unable to decode abstract for async: %s", err)
+	}
+
+	var correlationIdBytes []byte
+
+	err = d.decodeMessageFields(r, []interface{}{&m.CorrelationId, &correlationIdBytes})
+	if err != nil {
+		return Error("unable to decode async external: %s", err)
+	}
+
+	if correlationIdBytes != nil {
+		if m.CorrelationId, err = uuidFromBytes(correlationIdBytes); err != nil {
+			return Error("unable to decode async external correlation id: %s", err)
+		}
+	}
+
+	return
+}
+
+func (m *AsyncMessage) writeExternal(e *Encoder, w io.Writer) (n int, err error) {
+	n, err = m.AbstractMessage.writeExternal(e, w)
+	if err != nil {
+		return n, Error("unable to encode abstract for async: %s", err)
+	}
+
+	correlationId, correlationIdBytes := splitUUID(m.CorrelationId)
+
+	var k int
+	k, err = e.encodeMessageFields(w, []interface{}{correlationId, correlationIdBytes})
+	n += k
+	if err != nil {
+		return n, Error("unable to encode async external: %s", err)
+	}
+
+	return
+}
+
+// format:
+// - async message
+// - flags: none defined
+func (m *AcknowledgeMessage) readExternal(d *Decoder, r io.Reader) (err error) {
+	if err = m.AsyncMessage.readExternal(d, r); err != nil {
+		return Error("unable to decode async for ack: %s", err)
+	}
+
+	if err = d.decodeMessageFields(r); err != nil {
+		return Error("unable to decode ack external: %s", err)
+	}
+
+	return
+}
+
+func (m *AcknowledgeMessage) writeExternal(e *Encoder, w io.Writer) (n int, err error) {
+	n, err = m.AsyncMessage.writeExternal(e, w)
+	if err != nil {
+		return n, Error("unable to encode async for ack: %s", err)
+	}
+
+	var k int
+	k, err = e.encodeMessageFields(w)
+	n += k
+	if err != nil {
+		return n, Error("unable to encode ack external: %s", err)
+	}
+
+	return
+}
+
+// format:
+// - async message
+// - flags: operation
+func (m *CommandMessage) readExternal(d *Decoder, r io.Reader) (err error) {
+	if err = m.AsyncMessage.readExternal(d, r); err != nil {
+		return Error("unable to decode async for command: %s", err)
+	}
+
+	if err = d.decodeMessageFields(r, []interface{}{&m.Operation}); err != nil {
+		return Error("unable to decode command external: %s", err)
+	}
+
+	return
+}
+
+func (m *CommandMessage) writeExternal(e *Encoder, w io.Writer) (n int, err error) {
+	n, err = m.AsyncMessage.writeExternal(e, w)
+	if err != nil {
+		return n, Error("unable to encode async for command: %s", err)
+	}
+
+	var k int
+	k, err = e.encodeMessageFields(w, []interface{}{m.Operation})
+	n += k
+	if err != nil {
+		return n, Error("unable to encode command external: %s", err)
+	}
+
+	return
+}
+
+// newUUID returns a random (version 4) uuid in the form flex uses for
+// message and client ids.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(Error("amf: unable to generate uuid: %s", err))
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	s, _ := uuidFromBytes(b)
+
+	return s
+}
+
+// uuidFromBytes formats a 16 byte uuid the way flex does,
+// e.g. "5A1B2C3D-0000-1111-2222-333344445555".
+func uuidFromBytes(b []byte) (string, error) {
+	if len(b) != 16 {
+		return "", Error("invalid uuid length %d", len(b))
+	}
+
+	s := strings.ToUpper(hex.EncodeToString(b))
+
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
+
+// splitUUID returns the 16 byte form of id if it is a uuid, or id itself
+// otherwise, so that it can be written in the compact form when possible.
+func splitUUID(id string) (string, []byte) {
+	if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' {
+		return id, nil
+	}
+
+	b, err := hex.DecodeString(id[0:8] + id[9:13] + id[14:18] + id[19:23] + id[24:])
+	if err != nil {
+		return id, nil
+	}
+
+	return "", b
+}
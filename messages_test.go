@@ -0,0 +1,84 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeAcknowledgeMessageExt(t *testing.T) {
+	buf := bytes.NewReader([]byte{
+		0x0a, 0x07, 0x07, 'D', 'S', 'K',
+		0xa0, 0x01,
+		0x05, 0x40, 0x8f, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x0c, 0x21, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x00,
+		0x00,
+	})
+
+	dec := new(Decoder)
+	got, err := dec.DecodeAmf3(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	expect := new(AcknowledgeMessageExt)
+	expect.ClientId = "00010203-0405-0607-0809-0A0B0C0D0E0F"
+	expect.Timestamp = 1000
+
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+}
+
+func TestMessageExtRoundTrip(t *testing.T) {
+	async := new(AsyncMessageExt)
+	async.Body = Array{"hello"}
+	async.ClientId = "5A1B2C3D-0000-1111-2222-333344445555"
+	async.MessageId = "not-a-uuid"
+	async.Headers = Object{"DSId": "nil"}
+	async.Timestamp = 1400000000000
+	async.CorrelationId = "00010203-0405-0607-0809-0A0B0C0D0E0F"
+
+	cmd := new(CommandMessageExt)
+	cmd.Destination = "feed"
+	cmd.Operation = COMMAND_CLIENT_PING_OPERATION
+
+	for _, msg := range []interface{}{async, cmd} {
+		result, err := EncodeAndDecode(msg, AMF3)
+		if err != nil {
+			t.Fatalf("%T: %s", msg, err)
+		}
+
+		if !reflect.DeepEqual(msg, result) {
+			t.Errorf("expected %#v, got %#v", msg, result)
+		}
+	}
+}
+
+func TestMessageFullClass(t *testing.T) {
+	msg := new(RemotingMessage)
+	msg.Body = Array{"a", int32(1)}
+	msg.Destination = "service"
+	msg.Operation = "getSummoner"
+	msg.MessageId = "5A1B2C3D-0000-1111-2222-333344445555"
+	msg.Headers = Object{"DSEndpoint": "my-amf"}
+
+	buf := new(bytes.Buffer)
+	if _, err := new(Encoder).EncodeAmf3(buf, msg); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("flex.messaging.messages.RemotingMessage")) {
+		t.Errorf("expected class name in encoded buffer: %#v", buf.Bytes())
+	}
+
+	got, err := new(Decoder).DecodeAmf3(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	if !reflect.DeepEqual(msg, got) {
+		t.Errorf("expected %#v, got %#v", msg, got)
+	}
+}
@@ -0,0 +1,250 @@
+package amf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// the length of a header or message whose length was not known in advance
+const AMF_PACKET_UNKNOWN_LENGTH = 0xffffffff
+
+// Packet is the envelope flash remoting requests and responses are sent in
+// over http. In AMF3 packets, header values and message bodies are written
+// as amf3, behind the amf0 switch marker.
+type Packet struct {
+	Version  Version
+	Headers  []Header
+	Messages []Message
+}
+
+type Header struct {
+	Name           string
+	MustUnderstand bool
+	Value          interface{}
+}
+
+type Message struct {
+	TargetUri   string
+	ResponseUri string
+	Body        interface{}
+}
+
+// ReadPacket reads a packet from r with a new Decoder.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	return NewDecoder().ReadPacket(r)
+}
+
+// WritePacket writes p to w with a new Encoder.
+func WritePacket(w io.Writer, p *Packet) (int, error) {
+	return new(Encoder).WritePacket(w, p)
+}
+
+// format:
+// - 2 byte big endian uint16 version
+// - 2 byte big endian uint16 header count
+// - headers:
+//   - 2 byte big endian uint16 length of name followed by the name
+//   - 1 byte must understand flag
+//   - 4 byte big endian uint32 length of value, 0xffffffff if unknown
+//   - encoded amf0 value
+// - 2 byte big endian uint16 message count
+// - messages:
+//   - 2 byte big endian uint16 length of target uri followed by the uri
+//   - 2 byte big endian uint16 length of response uri followed by the uri
+//   - 4 byte big endian uint32 length of body, 0xffffffff if unknown
+//   - encoded amf0 value
+//
+// reference tables are reset before each header value and message body.
+func (d *Decoder) ReadPacket(r io.Reader) (*Packet, error) {
+	var version, count uint16
+
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, Error("decode packet: unable to read version: %s", err)
+	}
+
+	if version != AMF0 && version != AMF3 {
+		return nil, Error("decode packet: unsupported version %d", version)
+	}
+
+	p := &Packet{Version: Version(version)}
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, Error("decode packet: unable to read header count: %s", err)
+	}
+
+	for i := uint16(0); i < count; i++ {
+		var h Header
+		var err error
+
+		if h.Name, err = d.DecodeAmf0String(r, false); err != nil {
+			return nil, Error("decode packet: unable to read header name: %s", err)
+		}
+
+		var mustUnderstand byte
+		if mustUnderstand, err = ReadByte(r); err != nil {
+			return nil, Error("decode packet: unable to read header %s must understand flag: %s", h.Name, err)
+		}
+		h.MustUnderstand = mustUnderstand != 0x00
+
+		if h.Value, err = d.readPacketValue(r); err != nil {
+			return nil, Error("decode packet: unable to read header %s: %s", h.Name, err)
+		}
+
+		p.Headers = append(p.Headers, h)
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, Error("decode packet: unable to read message count: %s", err)
+	}
+
+	for i := uint16(0); i < count; i++ {
+		var m Message
+		var err error
+
+		if m.TargetUri, err = d.DecodeAmf0String(r, false); err != nil {
+			return nil, Error("decode packet: unable to read message target uri: %s", err)
+		}
+
+		if m.ResponseUri, err = d.DecodeAmf0String(r, false); err != nil {
+			return nil, Error("decode packet: unable to read message response uri: %s", err)
+		}
+
+		if m.Body, err = d.readPacketValue(r); err != nil {
+			return nil, Error("decode packet: unable to read message %s body: %s", m.TargetUri, err)
+		}
+
+		p.Messages = append(p.Messages, m)
+	}
+
+	return p, nil
+}
+
+func (d *Decoder) readPacketValue(r io.Reader) (interface{}, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, Error("unable to read length: %s", err)
+	}
+
+	d.Reset()
+
+	if length == AMF_PACKET_UNKNOWN_LENGTH {
+		return d.DecodeAmf0(r)
+	}
+
+	// stay within the given length, and skip whatever the value left unread
+	lr := &io.LimitedReader{R: r, N: int64(length)}
+
+	val, err := d.DecodeAmf0(lr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = io.Copy(ioutil.Discard, lr); err != nil {
+		return nil, Error("unable to skip past value: %s", err)
+	}
+
+	return val, nil
+}
+
+// same format as ReadPacket
+func (e *Encoder) WritePacket(w io.Writer, p *Packet) (n int, err error) {
+	if p.Version != AMF0 && p.Version != AMF3 {
+		return 0, Error("encode packet: unsupported version %d", p.Version)
+	}
+
+	if len(p.Headers) > 0xffff || len(p.Messages) > 0xffff {
+		return 0, Error("encode packet: too many headers or messages")
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint16(p.Version)); err != nil {
+		return n, Error("encode packet: unable to write version: %s", err)
+	}
+	n += 2
+
+	if err = binary.Write(w, binary.BigEndian, uint16(len(p.Headers))); err != nil {
+		return n, Error("encode packet: unable to write header count: %s", err)
+	}
+	n += 2
+
+	var m int
+	for _, h := range p.Headers {
+		if m, err = e.EncodeAmf0String(w, h.Name, false); err != nil {
+			return n, Error("encode packet: unable to write header name: %s", err)
+		}
+		n += m
+
+		var mustUnderstand byte
+		if h.MustUnderstand {
+			mustUnderstand = 0x01
+		}
+
+		if err = WriteByte(w, mustUnderstand); err != nil {
+			return n, Error("encode packet: unable to write header %s must understand flag: %s", h.Name, err)
+		}
+		n += 1
+
+		if m, err = e.writePacketValue(w, h.Value, p.Version); err != nil {
+			return n, Error("encode packet: unable to write header %s: %s", h.Name, err)
+		}
+		n += m
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint16(len(p.Messages))); err != nil {
+		return n, Error("encode packet: unable to write message count: %s", err)
+	}
+	n += 2
+
+	for _, msg := range p.Messages {
+		if m, err = e.EncodeAmf0String(w, msg.TargetUri, false); err != nil {
+			return n, Error("encode packet: unable to write message target uri: %s", err)
+		}
+		n += m
+
+		if m, err = e.EncodeAmf0String(w, msg.ResponseUri, false); err != nil {
+			return n, Error("encode packet: unable to write message response uri: %s", err)
+		}
+		n += m
+
+		if m, err = e.writePacketValue(w, msg.Body, p.Version); err != nil {
+			return n, Error("encode packet: unable to write message %s body: %s", msg.TargetUri, err)
+		}
+		n += m
+	}
+
+	return
+}
+
+func (e *Encoder) writePacketValue(w io.Writer, val interface{}, ver Version) (n int, err error) {
+	e.Reset()
+
+	// the value is buffered to learn its length
+	buf := new(bytes.Buffer)
+
+	if ver == AMF3 {
+		if err = e.EncodeAmf0Amf3Marker(buf); err != nil {
+			return
+		}
+		_, err = e.EncodeAmf3(buf, val)
+	} else {
+		_, err = e.EncodeAmf0(buf, val)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint32(buf.Len())); err != nil {
+		return n, Error("unable to write length: %s", err)
+	}
+	n += 4
+
+	m, err := w.Write(buf.Bytes())
+	n += m
+	if err != nil {
+		return n, Error("unable to write value: %s", err)
+	}
+
+	return
+}
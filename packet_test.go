@@ -0,0 +1,89 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWritePacket(t *testing.T) {
+	buf := new(bytes.Buffer)
+	expect := []byte{
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x01, 'h', 0x01, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x01, 'v',
+		0x00, 0x01,
+		0x00, 0x01, 't', 0x00, 0x01, 'r', 0x00, 0x00, 0x00, 0x09, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	p := &Packet{
+		Version:  AMF0,
+		Headers:  []Header{{Name: "h", MustUnderstand: true, Value: "v"}},
+		Messages: []Message{{TargetUri: "t", ResponseUri: "r", Body: 1}},
+	}
+
+	n, err := WritePacket(buf, p)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if n != len(expect) {
+		t.Errorf("expected to write %d bytes, actual %d", len(expect), n)
+	}
+
+	if bytes.Compare(buf.Bytes(), expect) != 0 {
+		t.Errorf("expected buffer: %#v, got: %#v", expect, buf.Bytes())
+	}
+}
+
+func TestReadPacketUnknownLength(t *testing.T) {
+	buf := bytes.NewReader([]byte{
+		0x00, 0x03, 0x00, 0x00,
+		0x00, 0x01,
+		0x00, 0x01, 't', 0x00, 0x01, 'r', 0xff, 0xff, 0xff, 0xff, 0x11, 0x06, 0x03, 'x',
+	})
+
+	p, err := ReadPacket(buf)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expect := &Packet{Version: AMF3, Messages: []Message{{TargetUri: "t", ResponseUri: "r", Body: "x"}}}
+	if !reflect.DeepEqual(expect, p) {
+		t.Errorf("expected %#v, got %#v", expect, p)
+	}
+}
+
+func TestPacketAmf3RoundTrip(t *testing.T) {
+	msg := new(RemotingMessage)
+	msg.Operation = "getSummoner"
+	msg.Body = Array{"alfie"}
+	msg.Headers = Object{}
+
+	// the same object in every value is written inline each time, as the
+	// reference tables start over for each of them
+	p := &Packet{
+		Version: AMF3,
+		Headers: []Header{{Name: "credentials", Value: msg}},
+		Messages: []Message{
+			{TargetUri: "null", ResponseUri: "/1", Body: Array{msg}},
+			{TargetUri: "null", ResponseUri: "/2", Body: Array{msg}},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := WritePacket(buf, p); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	if c := bytes.Count(buf.Bytes(), []byte("flex.messaging.messages.RemotingMessage")); c != 3 {
+		t.Errorf("expected class name to be written 3 times, got %d", c)
+	}
+
+	got, err := ReadPacket(buf)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	if !reflect.DeepEqual(p, got) {
+		t.Errorf("expected %#v, got %#v", p, got)
+	}
+}
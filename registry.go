@@ -0,0 +1,157 @@
+package amf
+
+import (
+	"io"
+	"reflect"
+	"sync"
+)
+
+var classAliases struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+// RegisterClassAlias maps the remote class name alias (as passed to
+// registerClassAlias in ActionScript, e.g. "com.example.Summoner") to the Go
+// struct type of v. Decoders instantiate that type for typed objects of the
+// class, and encoders write the alias as the class name of its values.
+// v may be a struct or a pointer to one; decoded values are always pointers.
+func RegisterClassAlias(alias string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(Error("amf: cannot register class alias %s for non-struct type %T", alias, v))
+	}
+
+	if alias == "" {
+		panic(Error("amf: cannot register empty class alias for %s", t))
+	}
+
+	classAliases.Lock()
+	defer classAliases.Unlock()
+
+	if classAliases.byName == nil {
+		classAliases.byName = make(map[string]reflect.Type)
+		classAliases.byType = make(map[reflect.Type]string)
+	}
+
+	classAliases.byName[alias] = t
+	classAliases.byType[t] = alias
+}
+
+// classAliasForType returns the registered class name for struct type t.
+func classAliasForType(t reflect.Type) (string, bool) {
+	classAliases.RLock()
+	alias, ok := classAliases.byType[t]
+	classAliases.RUnlock()
+
+	return alias, ok
+}
+
+// typeForClassAlias returns the struct type registered for a class name.
+func typeForClassAlias(alias string) (reflect.Type, bool) {
+	if alias == "" {
+		return nil, false
+	}
+
+	classAliases.RLock()
+	t, ok := classAliases.byName[alias]
+	classAliases.RUnlock()
+
+	return t, ok
+}
+
+// newClassValue returns a pointer to a new zero value of the struct
+// registered for alias. if nothing is registered for alias, ok is false.
+func newClassValue(alias string) (ptr reflect.Value, ok bool) {
+	t, ok := typeForClassAlias(alias)
+	if !ok {
+		return ptr, false
+	}
+
+	return reflect.New(t), true
+}
+
+var externalHandlers struct {
+	sync.RWMutex
+	decoders map[string]ExternalHandler
+	encoders map[reflect.Type]externalEncoder
+}
+
+// RegisterExternalHandler decodes externalizable objects of class name with f
+// on every decoder that has no handler of its own for the class.
+func RegisterExternalHandler(name string, f ExternalHandler) {
+	externalHandlers.Lock()
+	defer externalHandlers.Unlock()
+
+	if externalHandlers.decoders == nil {
+		externalHandlers.decoders = make(map[string]ExternalHandler)
+	}
+
+	externalHandlers.decoders[name] = f
+}
+
+// RegisterExternalEncodeHandler encodes values of the type of v as
+// externalizable objects of class name, with f writing their body, on every
+// encoder that has no handler of its own for the type.
+func RegisterExternalEncodeHandler(name string, v interface{}, f ExternalEncodeHandler) {
+	externalHandlers.Lock()
+	defer externalHandlers.Unlock()
+
+	if externalHandlers.encoders == nil {
+		externalHandlers.encoders = make(map[reflect.Type]externalEncoder)
+	}
+
+	externalHandlers.encoders[reflect.TypeOf(v)] = externalEncoder{name, f}
+}
+
+// RegisterExternalizable registers handlers for class name in both
+// directions: values of the type of v, or of the type it points to, are
+// written with WriteExternal, and decoded objects of the class are read into
+// a new value with ReadExternal. v must be a pointer, as ReadExternal fills
+// in its receiver.
+func RegisterExternalizable(name string, v Externalizable) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Ptr {
+		panic(Error("amf: cannot register externalizable %s for non-pointer type %s", name, t))
+	}
+
+	RegisterExternalHandler(name, func(d *Decoder, r io.Reader) (interface{}, error) {
+		ext := reflect.New(t.Elem()).Interface().(Externalizable)
+		if err := ext.ReadExternal(d, r); err != nil {
+			return nil, err
+		}
+
+		return ext, nil
+	})
+
+	RegisterExternalEncodeHandler(name, v, func(e *Encoder, w io.Writer, val interface{}) (int, error) {
+		return val.(Externalizable).WriteExternal(e, w)
+	})
+
+	RegisterExternalEncodeHandler(name, reflect.Zero(t.Elem()).Interface(), func(e *Encoder, w io.Writer, val interface{}) (int, error) {
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(reflect.ValueOf(val))
+		return ptr.Interface().(Externalizable).WriteExternal(e, w)
+	})
+}
+
+func defaultExternalHandler(name string) (ExternalHandler, bool) {
+	externalHandlers.RLock()
+	f, ok := externalHandlers.decoders[name]
+	externalHandlers.RUnlock()
+
+	return f, ok
+}
+
+func defaultExternalEncoder(t reflect.Type) (externalEncoder, bool) {
+	externalHandlers.RLock()
+	ext, ok := externalHandlers.encoders[t]
+	externalHandlers.RUnlock()
+
+	return ext, ok
+}
@@ -0,0 +1,205 @@
+package amf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type registrySummoner struct {
+	Name    string      `amf:"name"`
+	Level   int32       `amf:"level"`
+	Partner interface{} `amf:"partner"`
+}
+
+type registryTeam struct {
+	Members []registrySummoner `amf:"members"`
+}
+
+func init() {
+	RegisterClassAlias("com.example.Summoner", registrySummoner{})
+}
+
+func TestRegisterClassAliasAmf3(t *testing.T) {
+	val := Array{
+		&registrySummoner{Name: "alfie", Level: 30, Partner: registrySummoner{Name: "pup", Level: 1}},
+		registrySummoner{Name: "bob", Level: 5},
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := new(Encoder).EncodeAmf3(buf, val); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("com.example.Summoner")) {
+		t.Errorf("expected class name in encoded buffer: %#v", buf.Bytes())
+	}
+
+	got, err := new(Decoder).DecodeAmf3(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	expect := Array{
+		&registrySummoner{Name: "alfie", Level: 30, Partner: &registrySummoner{Name: "pup", Level: 1}},
+		&registrySummoner{Name: "bob", Level: 5},
+	}
+
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+
+	var team registryTeam
+	if err = Convert(Object{"members": got}, &team); err != nil {
+		t.Fatalf("convert: %s", err)
+	}
+
+	if len(team.Members) != 2 || team.Members[1].Name != "bob" {
+		t.Errorf("unexpected team: %+v", team)
+	}
+}
+
+func TestRegisterClassAliasAmf0(t *testing.T) {
+	val := &registrySummoner{Name: "alfie", Level: 30}
+
+	buf := new(bytes.Buffer)
+	if _, err := new(Encoder).EncodeAmf0(buf, val); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	if buf.Bytes()[0] != AMF0_TYPED_OBJECT_MARKER {
+		t.Errorf("expected typed object marker, got %#v", buf.Bytes()[0])
+	}
+
+	got, err := new(Decoder).DecodeAmf0(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	expect := &registrySummoner{Name: "alfie", Level: 30}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expected %#v, got %#v", expect, got)
+	}
+}
+
+func TestRegisterClassAliasInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering non-struct type")
+		}
+	}()
+
+	RegisterClassAlias("com.example.Number", 5)
+}
+
+type registryNode struct {
+	Name string        `amf:"name"`
+	Next *registryNode `amf:"next"`
+}
+
+func init() {
+	RegisterClassAlias("com.example.Node", registryNode{})
+}
+
+func TestRegisterClassAliasAmf0Cycle(t *testing.T) {
+	a := &registryNode{Name: "a"}
+	a.Next = &registryNode{Name: "b", Next: a}
+
+	buf := new(bytes.Buffer)
+	if _, err := new(Encoder).EncodeAmf0(buf, a); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	got, err := new(Decoder).DecodeAmf0(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	node, ok := got.(*registryNode)
+	if !ok {
+		t.Fatalf("expected *registryNode, got %T", got)
+	}
+
+	if node.Name != "a" || node.Next == nil || node.Next.Name != "b" || node.Next.Next != node {
+		t.Errorf("expected cycle to be preserved, got %+v", node)
+	}
+}
+
+type registryPoint struct {
+	X, Y int32
+}
+
+func (p *registryPoint) WriteExternal(e *Encoder, w io.Writer) (int, error) {
+	return 8, binary.Write(w, binary.BigEndian, []int32{p.X, p.Y})
+}
+
+func (p *registryPoint) ReadExternal(d *Decoder, r io.Reader) error {
+	return binary.Read(r, binary.BigEndian, p)
+}
+
+func init() {
+	RegisterExternalizable("com.example.Point", &registryPoint{})
+}
+
+func TestRegisterExternalizable(t *testing.T) {
+	p := &registryPoint{X: 1, Y: -1}
+	expect := []byte{0x0a, 0x07, 0x23, 'c', 'o', 'm', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'P', 'o', 'i', 'n', 't',
+		0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff,
+		0x0a, 0x00,
+	}
+
+	buf := new(bytes.Buffer)
+	enc := new(Encoder)
+	for i := 0; i < 2; i++ {
+		if _, err := enc.EncodeAmf3(buf, p); err != nil {
+			t.Fatalf("encode: %s", err)
+		}
+	}
+
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("expected buffer: %#v, got: %#v", expect, buf.Bytes())
+	}
+
+	dec := new(Decoder)
+	for i := 0; i < 2; i++ {
+		got, err := dec.DecodeAmf3(buf)
+		if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+
+		if !reflect.DeepEqual(p, got) {
+			t.Errorf("expected %#v, got %#v", p, got)
+		}
+	}
+}
+
+func TestEncoderExternalHandler(t *testing.T) {
+	type token string
+
+	enc := new(Encoder)
+	enc.RegisterExternalHandler("com.example.Token", token(""), func(e *Encoder, w io.Writer, v interface{}) (int, error) {
+		return e.EncodeAmf3(w, string(v.(token)))
+	})
+
+	buf := new(bytes.Buffer)
+	if _, err := enc.EncodeAmf0(buf, token("abc")); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	dec := new(Decoder)
+	dec.RegisterExternalHandler("com.example.Token", func(d *Decoder, r io.Reader) (interface{}, error) {
+		s, err := d.DecodeAmf3(r)
+		return token(s.(string)), err
+	})
+
+	got, err := dec.DecodeAmf0(buf)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	if got != token("abc") {
+		t.Errorf("expected token abc, got %#v", got)
+	}
+}
@@ -0,0 +1,406 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// chunk header formats
+const (
+	CHUNK_TYPE_FULL         = 0
+	CHUNK_TYPE_SAME_STREAM  = 1
+	CHUNK_TYPE_DELTA        = 2
+	CHUNK_TYPE_CONTINUATION = 3
+)
+
+const EXTENDED_TIMESTAMP = 0xffffff
+
+// chunkStream is the header state of a chunk stream, from which compressed
+// headers are expanded.
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeId    uint8
+	streamId  uint32
+
+	// extended is set when the last header carried an extended timestamp,
+	// which continuation chunks then repeat
+	extended bool
+
+	// payload of the message being reassembled
+	payload []byte
+}
+
+// Reader reads messages from a chunk stream. Set Chunk Size and Abort
+// messages sent by the peer are applied as they are read, and returned like
+// any other message.
+type Reader struct {
+	r         io.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	read      uint64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:         r,
+		chunkSize: DEFAULT_CHUNK_SIZE,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+// BytesRead returns the number of bytes read so far, for acknowledgements.
+func (r *Reader) BytesRead() uint64 {
+	return r.read
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(r.r, p)
+	r.read += uint64(n)
+	return n, err
+}
+
+// ReadMessage reads chunks until a message is complete.
+//
+// format (chunk):
+//   - basic header: 2 bit header type, 6 bit chunk stream id, where ids 0 and
+//     1 are followed by 1 or 2 bytes of id - 64 (little endian)
+//   - message header, by type:
+//   - 0: 3 byte timestamp, 3 byte length, 1 byte type, 4 byte little endian stream id
+//   - 1: 3 byte timestamp delta, 3 byte length, 1 byte type
+//   - 2: 3 byte timestamp delta
+//   - 3: none
+//   - 4 byte extended timestamp if the timestamp (delta) is 0xffffff
+//   - up to chunk size bytes of payload
+func (r *Reader) ReadMessage() (*Message, error) {
+	for {
+		msg, err := r.readChunk()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+	}
+}
+
+func (r *Reader) readChunk() (*Message, error) {
+	buf := make([]byte, 11)
+
+	if _, err := r.Read(buf[:1]); err != nil {
+		return nil, err
+	}
+
+	format := buf[0] >> 6
+	csid := uint32(buf[0] & 0x3f)
+
+	switch csid {
+	case 0:
+		if _, err := r.Read(buf[:1]); err != nil {
+			return nil, amf.Error("rtmp: unable to read chunk stream id: %s", err)
+		}
+		csid = 64 + uint32(buf[0])
+	case 1:
+		if _, err := r.Read(buf[:2]); err != nil {
+			return nil, amf.Error("rtmp: unable to read chunk stream id: %s", err)
+		}
+		csid = 64 + uint32(buf[0]) + uint32(buf[1])<<8
+	}
+
+	cs := r.streams[csid]
+	if cs == nil {
+		if format != CHUNK_TYPE_FULL {
+			return nil, amf.Error("rtmp: chunk stream %d started with header type %d", csid, format)
+		}
+
+		cs = new(chunkStream)
+		r.streams[csid] = cs
+	}
+
+	if format != CHUNK_TYPE_CONTINUATION && len(cs.payload) > 0 {
+		return nil, amf.Error("rtmp: chunk stream %d got a new message header inside a message", csid)
+	}
+
+	headerSizes := []int{11, 7, 3, 0}
+	header := buf[:headerSizes[format]]
+	if _, err := r.Read(header); err != nil {
+		return nil, amf.Error("rtmp: unable to read chunk header: %s", err)
+	}
+
+	var ts uint32
+	if format != CHUNK_TYPE_CONTINUATION {
+		ts = uint24(header[0:3])
+		cs.extended = ts == EXTENDED_TIMESTAMP
+	}
+
+	if format == CHUNK_TYPE_FULL || format == CHUNK_TYPE_SAME_STREAM {
+		cs.length = uint24(header[3:6])
+		cs.typeId = header[6]
+	}
+
+	if format == CHUNK_TYPE_FULL {
+		cs.streamId = binary.LittleEndian.Uint32(header[7:11])
+	}
+
+	if cs.extended {
+		ext := make([]byte, 4)
+		if _, err := r.Read(ext); err != nil {
+			return nil, amf.Error("rtmp: unable to read extended timestamp: %s", err)
+		}
+
+		if format != CHUNK_TYPE_CONTINUATION {
+			ts = binary.BigEndian.Uint32(ext)
+		}
+	}
+
+	switch format {
+	case CHUNK_TYPE_FULL:
+		cs.timestamp = ts
+		cs.delta = 0
+	case CHUNK_TYPE_SAME_STREAM, CHUNK_TYPE_DELTA:
+		cs.timestamp += ts
+		cs.delta = ts
+	case CHUNK_TYPE_CONTINUATION:
+		// a continuation header starting a message repeats the last delta
+		if len(cs.payload) == 0 {
+			cs.timestamp += cs.delta
+		}
+	}
+
+	if cs.length > MAX_MESSAGE_LENGTH {
+		return nil, amf.Error("rtmp: message length %d too large", cs.length)
+	}
+
+	n := cs.length - uint32(len(cs.payload))
+	if n > r.chunkSize {
+		n = r.chunkSize
+	}
+
+	// the payload grows as its chunks arrive, rather than being allocated
+	// up front for a length any peer may claim on any chunk stream
+	if cs.payload == nil {
+		cs.payload = make([]byte, 0, n)
+	}
+
+	start := len(cs.payload)
+	cs.payload = append(cs.payload, make([]byte, n)...)
+	if _, err := r.Read(cs.payload[start:]); err != nil {
+		return nil, amf.Error("rtmp: unable to read chunk payload: %s", err)
+	}
+
+	if uint32(len(cs.payload)) < cs.length {
+		return nil, nil
+	}
+
+	msg := &Message{
+		ChunkStreamId: csid,
+		Type:          cs.typeId,
+		StreamId:      cs.streamId,
+		Timestamp:     cs.timestamp,
+		Payload:       cs.payload,
+	}
+	cs.payload = nil
+
+	if err := r.apply(msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// apply handles the protocol control messages that change how chunks are
+// read.
+func (r *Reader) apply(msg *Message) error {
+	switch msg.Type {
+	case MSG_SET_CHUNK_SIZE:
+		size, err := msg.ControlValue()
+		if err != nil {
+			return err
+		}
+
+		size &= MAX_CHUNK_SIZE
+		if size == 0 {
+			return amf.Error("rtmp: invalid chunk size 0")
+		}
+		r.chunkSize = size
+
+	case MSG_ABORT:
+		csid, err := msg.ControlValue()
+		if err != nil {
+			return err
+		}
+
+		if cs := r.streams[csid]; cs != nil {
+			cs.payload = nil
+		}
+	}
+
+	return nil
+}
+
+// Writer writes messages to a chunk stream, compressing each header
+// against the last one sent on its chunk stream. It is safe for concurrent
+// use, each message being written whole.
+type Writer struct {
+	mu        sync.Mutex
+	w         io.Writer
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:         w,
+		chunkSize: DEFAULT_CHUNK_SIZE,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+// SetChunkSize sends a Set Chunk Size message, and splits the following
+// messages into chunks of size bytes.
+func (w *Writer) SetChunkSize(size uint32) error {
+	if size == 0 || size > MAX_CHUNK_SIZE {
+		return amf.Error("rtmp: invalid chunk size %d", size)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writeMessage(NewSetChunkSize(size)); err != nil {
+		return err
+	}
+
+	w.chunkSize = size
+
+	return nil
+}
+
+// WriteMessage writes msg on its chunk stream, chunk stream 3 if none is
+// set.
+func (w *Writer) WriteMessage(msg *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writeMessage(msg)
+}
+
+func (w *Writer) writeMessage(msg *Message) error {
+	csid := msg.ChunkStreamId
+	if csid == 0 {
+		csid = CHUNK_STREAM_COMMAND
+	}
+
+	if csid < 2 || csid > MAX_CHUNK_STREAM {
+		return amf.Error("rtmp: invalid chunk stream id %d", csid)
+	}
+
+	if len(msg.Payload) > MAX_MESSAGE_LENGTH {
+		return amf.Error("rtmp: message length %d too large", len(msg.Payload))
+	}
+
+	length := uint32(len(msg.Payload))
+
+	cs := w.streams[csid]
+	format := byte(CHUNK_TYPE_FULL)
+	ts := msg.Timestamp
+
+	if cs != nil && cs.streamId == msg.StreamId && msg.Timestamp >= cs.timestamp {
+		ts = msg.Timestamp - cs.timestamp
+
+		switch {
+		case cs.length != length || cs.typeId != msg.Type:
+			format = CHUNK_TYPE_SAME_STREAM
+		case cs.delta != ts:
+			format = CHUNK_TYPE_DELTA
+		default:
+			format = CHUNK_TYPE_CONTINUATION
+		}
+	}
+
+	if cs == nil {
+		cs = new(chunkStream)
+		w.streams[csid] = cs
+	}
+
+	if format != CHUNK_TYPE_CONTINUATION {
+		cs.extended = ts >= EXTENDED_TIMESTAMP
+	}
+
+	if format == CHUNK_TYPE_FULL {
+		cs.delta = 0
+	} else {
+		cs.delta = ts
+	}
+
+	cs.timestamp = msg.Timestamp
+	cs.length = length
+	cs.typeId = msg.Type
+	cs.streamId = msg.StreamId
+
+	buf := make([]byte, 0, 18+int(length)+int(length/w.chunkSize)*8)
+	buf = appendChunkHeader(buf, format, csid)
+
+	field := ts
+	if cs.extended {
+		field = EXTENDED_TIMESTAMP
+	}
+
+	if format != CHUNK_TYPE_CONTINUATION {
+		buf = appendUint24(buf, field)
+	}
+
+	if format == CHUNK_TYPE_FULL || format == CHUNK_TYPE_SAME_STREAM {
+		buf = appendUint24(buf, length)
+		buf = append(buf, msg.Type)
+	}
+
+	if format == CHUNK_TYPE_FULL {
+		buf = append(buf, byte(msg.StreamId), byte(msg.StreamId>>8), byte(msg.StreamId>>16), byte(msg.StreamId>>24))
+	}
+
+	for i := uint32(0); ; {
+		if cs.extended {
+			buf = append(buf, byte(ts>>24), byte(ts>>16), byte(ts>>8), byte(ts))
+		}
+
+		n := length - i
+		if n > w.chunkSize {
+			n = w.chunkSize
+		}
+
+		buf = append(buf, msg.Payload[i:i+n]...)
+		i += n
+
+		if i >= length {
+			break
+		}
+
+		buf = appendChunkHeader(buf, CHUNK_TYPE_CONTINUATION, csid)
+	}
+
+	if _, err := w.w.Write(buf); err != nil {
+		return amf.Error("rtmp: unable to write message: %s", err)
+	}
+
+	return nil
+}
+
+func appendChunkHeader(buf []byte, format byte, csid uint32) []byte {
+	switch {
+	case csid < 64:
+		return append(buf, format<<6|byte(csid))
+	case csid < 320:
+		return append(buf, format<<6, byte(csid-64))
+	}
+
+	return append(buf, format<<6|1, byte(csid-64), byte((csid-64)>>8))
+}
+
+func appendUint24(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
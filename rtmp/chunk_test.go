@@ -0,0 +1,198 @@
+package rtmp
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	large := make([]byte, 1000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+
+	msgs := []*Message{
+		{ChunkStreamId: 3, Type: MSG_COMMAND_AMF0, Timestamp: 0, Payload: []byte("connect")},
+		{ChunkStreamId: 4, Type: MSG_AUDIO, StreamId: 1, Timestamp: 10, Payload: large},
+		{ChunkStreamId: 4, Type: MSG_AUDIO, StreamId: 1, Timestamp: 30, Payload: large},
+		{ChunkStreamId: 4, Type: MSG_AUDIO, StreamId: 1, Timestamp: 50, Payload: large},
+		{ChunkStreamId: 4, Type: MSG_VIDEO, StreamId: 1, Timestamp: 60, Payload: []byte{1, 2, 3}},
+		{ChunkStreamId: 100, Type: MSG_VIDEO, StreamId: 1, Timestamp: 0x1000000, Payload: large},
+		{ChunkStreamId: 100, Type: MSG_VIDEO, StreamId: 1, Timestamp: 0x1000010, Payload: large},
+		{ChunkStreamId: 400, Type: MSG_DATA_AMF0, StreamId: 2, Timestamp: 5, Payload: []byte{}},
+		{ChunkStreamId: 3, Type: MSG_COMMAND_AMF0, Timestamp: 0, Payload: []byte("createStream")},
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	for i, msg := range msgs {
+		if i == 3 {
+			if err := w.SetChunkSize(4096); err != nil {
+				t.Fatalf("set chunk size: %s", err)
+			}
+		}
+
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatalf("write %d: %s", i, err)
+		}
+	}
+
+	written := buf.Len()
+	r := NewReader(buf)
+
+	for i := 0; i < len(msgs); i++ {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d: %s", i, err)
+		}
+
+		if msg.Type == MSG_SET_CHUNK_SIZE {
+			if size, _ := msg.ControlValue(); size != 4096 || r.chunkSize != 4096 {
+				t.Errorf("expected chunk size 4096, got %d", size)
+			}
+			i--
+			continue
+		}
+
+		if !reflect.DeepEqual(msg, msgs[i]) {
+			t.Errorf("message %d: expected %+v, got %+v", i, msgs[i], msg)
+		}
+	}
+
+	if r.BytesRead() != uint64(written) {
+		t.Errorf("expected %d bytes read, got %d", written, r.BytesRead())
+	}
+}
+
+func TestChunkLengthBeyondInput(t *testing.T) {
+	// first chunks of messages on many chunk streams, each claiming the
+	// largest length but sending a single chunk of it
+	buf := new(bytes.Buffer)
+	for csid := byte(4); csid < 64; csid++ {
+		buf.Write([]byte{csid, 0, 0, 0, 0xff, 0xff, 0xff, MSG_VIDEO, 1, 0, 0, 0})
+		buf.Write(make([]byte, DEFAULT_CHUNK_SIZE))
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	r := NewReader(buf)
+	if msg, err := r.ReadMessage(); err == nil {
+		t.Errorf("expected error at the end of input, got %+v", msg)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("expected allocation to follow the input, allocated %d bytes", alloc)
+	}
+}
+
+func TestChunkHeaderCompression(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	msg := &Message{ChunkStreamId: 4, Type: MSG_AUDIO, StreamId: 1, Payload: []byte{0xaa}}
+
+	expected := [][]byte{
+		// type 0: timestamp 10, length 1, type 8, stream 1
+		{0x04, 0, 0, 10, 0, 0, 1, 8, 1, 0, 0, 0, 0xaa},
+		// type 2: delta 20
+		{0x84, 0, 0, 20, 0xaa},
+		// type 3: same delta
+		{0xc4, 0xaa},
+	}
+
+	for i, ts := range []uint32{10, 30, 50} {
+		msg.Timestamp = ts
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+
+		if b := buf.Next(buf.Len()); !bytes.Equal(b, expected[i]) {
+			t.Errorf("message %d: expected % x, got % x", i, expected[i], b)
+		}
+	}
+
+	msg.Payload = []byte{0xaa, 0xbb}
+	msg.Timestamp = 55
+	w.WriteMessage(msg)
+
+	// type 1: delta 5, length 2, type 8
+	if b, e := buf.Bytes(), []byte{0x44, 0, 0, 5, 0, 0, 2, 8, 0xaa, 0xbb}; !bytes.Equal(b, e) {
+		t.Errorf("expected % x, got % x", e, b)
+	}
+}
+
+func TestChunkExtendedTimestamp(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.chunkSize = 2
+
+	w.WriteMessage(&Message{ChunkStreamId: 3, Type: MSG_VIDEO, Timestamp: 0x01020304, Payload: []byte{1, 2, 3}})
+
+	expected := []byte{
+		0x03, 0xff, 0xff, 0xff, 0, 0, 3, 9, 0, 0, 0, 0, 0x01, 0x02, 0x03, 0x04, 1, 2,
+		0xc3, 0x01, 0x02, 0x03, 0x04, 3,
+	}
+
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected % x, got % x", expected, buf.Bytes())
+	}
+
+	r := NewReader(buf)
+	r.chunkSize = 2
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	if msg.Timestamp != 0x01020304 || !bytes.Equal(msg.Payload, []byte{1, 2, 3}) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestChunkAbort(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	// half of a 4 byte message on chunk stream 5, an abort, then a message
+	buf.Write([]byte{0x05, 0, 0, 0, 0, 0, 4, 8, 0, 0, 0, 0, 1, 2})
+	w := NewWriter(buf)
+	w.chunkSize = 2
+	w.WriteMessage(NewAbort(5))
+	buf.Write([]byte{0x05, 0, 0, 0, 0, 0, 1, 8, 0, 0, 0, 0, 9})
+
+	r := NewReader(buf)
+	r.chunkSize = 2
+
+	msg, err := r.ReadMessage()
+	if err != nil || msg.Type != MSG_ABORT {
+		t.Fatalf("expected abort, got %+v: %v", msg, err)
+	}
+
+	msg, err = r.ReadMessage()
+	if err != nil || !bytes.Equal(msg.Payload, []byte{9}) {
+		t.Errorf("unexpected message %+v: %v", msg, err)
+	}
+}
+
+func TestControlMessages(t *testing.T) {
+	for _, msg := range []*Message{NewSetChunkSize(4096), NewAck(4096), NewWindowAckSize(4096), NewAbort(4096)} {
+		if v, err := msg.ControlValue(); err != nil || v != 4096 {
+			t.Errorf("type %d: expected 4096, got %d: %v", msg.Type, v, err)
+		}
+	}
+
+	size, limit, err := NewSetPeerBandwidth(2500000, BANDWIDTH_LIMIT_DYNAMIC).PeerBandwidth()
+	if err != nil || size != 2500000 || limit != BANDWIDTH_LIMIT_DYNAMIC {
+		t.Errorf("unexpected peer bandwidth %d %d: %v", size, limit, err)
+	}
+
+	if _, err = (&Message{Type: MSG_AUDIO}).ControlValue(); err == nil {
+		t.Errorf("expected error for audio message")
+	}
+}
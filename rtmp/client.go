@@ -0,0 +1,507 @@
+package rtmp
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// Handler serves a call made by the peer. Its result is sent back with
+// _result, or its error with _error, unless the call is a notification
+// (transaction id 0), whose result is dropped.
+type Handler func(c *Command) (interface{}, error)
+
+// Client is a NetConnection to an rtmp server: it connects to an
+// application, invokes its methods, and serves the calls the server makes,
+// e.g. onBWDone.
+type Client struct {
+	// ObjectEncoding is the amf version requested when connecting, AMF0
+	// unless set, and is replaced by the version the server agrees to.
+	ObjectEncoding amf.Version
+
+	// ConnectObject holds properties added to the connect command object,
+	// overriding the defaults (app, flashVer, tcUrl, capabilities, ...).
+	ConnectObject amf.Object
+
+	// TLSConfig configures rtmps and rtmpts connections. The server name
+	// defaults to the host of the url.
+	TLSConfig *tls.Config
+
+	// Decoder decodes commands from the server, and may be set to register
+	// external handlers.
+	Decoder *amf.Decoder
+
+	// Dial opens the transport of the connection, replacing the tcp (or tls
+	// for rtmps) connection to the host of the url.
+	Dial func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error)
+
+	url *url.URL
+
+	mu       sync.Mutex
+	conn     *conn
+	encoding amf.Version
+	handlers map[string]Handler
+	pending  map[float64]chan *Command
+	txid     float64
+	err      error
+	done     chan struct{}
+
+	// calls of the server waiting for their handler
+	calls   []*Command
+	serving bool
+}
+
+// NewClient returns a client for the application at rawurl, e.g.
+// "rtmp://host/app" or "rtmps://host:443/app/instance". The rtmpt and
+// rtmpts schemes tunnel the connection over http and https.
+func NewClient(rawurl string) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, amf.Error("rtmp: invalid url %s: %s", rawurl, err)
+	}
+
+	if u.Host == "" {
+		return nil, amf.Error("rtmp: no host in url %s", rawurl)
+	}
+
+	return &Client{
+		url:      u,
+		handlers: make(map[string]Handler),
+		pending:  make(map[float64]chan *Command),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Handle serves calls of method name made by the server with h. Handlers
+// are called one at a time, in the order the calls arrive.
+func (c *Client) Handle(name string, h Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[name] = h
+}
+
+// Connect opens the connection, performs the handshake and sends the
+// connect command with args. The _result of connect is returned, whose
+// Object holds the server properties and whose first argument the
+// information object.
+func (c *Client) Connect(ctx context.Context, args ...interface{}) (*Command, error) {
+	c.mu.Lock()
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	if connected {
+		return nil, amf.Error("rtmp: already connected")
+	}
+
+	rwc, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake does not take a context, so it is interrupted by
+	// closing the connection
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			rwc.Close()
+		case <-stop:
+		}
+	}()
+
+	err = ClientHandshake(rwc)
+	close(stop)
+
+	if err != nil {
+		rwc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.conn = newConn(rwc)
+	c.mu.Unlock()
+
+	go c.readLoop()
+
+	obj := amf.Object{
+		"app":            strings.TrimPrefix(c.url.Path, "/"),
+		"flashVer":       "LNX 11,2,202,235",
+		"tcUrl":          c.url.String(),
+		"fpad":           false,
+		"capabilities":   float64(15),
+		"audioCodecs":    float64(3575),
+		"videoCodecs":    float64(252),
+		"videoFunction":  float64(1),
+		"objectEncoding": float64(c.ObjectEncoding),
+	}
+
+	for k, v := range c.ConnectObject {
+		obj[k] = v
+	}
+
+	// connect is always sent in amf0, as it negotiates the encoding
+	res, err := c.call(ctx, amf.AMF0, &Command{Name: "connect", Object: obj, Args: args})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	encoding := amf.Version(amf.AMF0)
+	if len(res.Args) > 0 {
+		var info struct {
+			ObjectEncoding float64 `amf:"objectEncoding"`
+		}
+
+		if amf.Convert(res.Args[0], &info) == nil && info.ObjectEncoding == amf.AMF3 {
+			encoding = amf.AMF3
+		}
+	}
+
+	c.mu.Lock()
+	c.encoding = encoding
+	c.ObjectEncoding = encoding
+	c.mu.Unlock()
+
+	return res, nil
+}
+
+func (c *Client) dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	if c.Dial != nil {
+		return c.Dial(ctx, c.url)
+	}
+
+	host := c.url.Host
+	if c.url.Port() == "" {
+		switch c.url.Scheme {
+		case "rtmp":
+			host = net.JoinHostPort(c.url.Hostname(), "1935")
+		case "rtmpt":
+			host = net.JoinHostPort(c.url.Hostname(), "80")
+		case "rtmps", "rtmpts":
+			host = net.JoinHostPort(c.url.Hostname(), "443")
+		}
+	}
+
+	var d net.Dialer
+
+	switch c.url.Scheme {
+	case "rtmp":
+		conn, err := d.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return nil, amf.Error("rtmp: %s", err)
+		}
+
+		return conn, nil
+
+	case "rtmps":
+		config := new(tls.Config)
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = c.url.Hostname()
+		}
+
+		td := tls.Dialer{NetDialer: &d, Config: config}
+
+		conn, err := td.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return nil, amf.Error("rtmp: %s", err)
+		}
+
+		return conn, nil
+
+	case "rtmpt":
+		return DialTunnel(ctx, "http://"+host, nil)
+
+	case "rtmpts":
+		config := new(tls.Config)
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+
+		transport := &http.Transport{TLSClientConfig: config}
+
+		rwc, err := DialTunnel(ctx, "https://"+host, &http.Client{Transport: transport})
+		if err != nil {
+			transport.CloseIdleConnections()
+			return nil, err
+		}
+
+		return &transportConn{rwc, transport}, nil
+	}
+
+	return nil, amf.Error("rtmp: unsupported scheme %s", c.url.Scheme)
+}
+
+// transportConn is a tunnel over a transport of its own, whose connections
+// are closed with it.
+type transportConn struct {
+	io.ReadWriteCloser
+	transport *http.Transport
+}
+
+func (t *transportConn) Close() error {
+	err := t.ReadWriteCloser.Close()
+	t.transport.CloseIdleConnections()
+
+	return err
+}
+
+// Call invokes method name of the server with args, and returns its result:
+// the first argument of the _result command, or its command object if it
+// has no arguments. Errors sent with _error are returned as an
+// *amf.StatusError.
+func (c *Client) Call(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	res, err := c.call(ctx, c.version(), &Command{Name: name, Args: args})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Args) > 0 {
+		return res.Args[0], nil
+	}
+
+	return res.Object, nil
+}
+
+// Notify invokes method name of the server without waiting for a result.
+func (c *Client) Notify(name string, args ...interface{}) error {
+	return c.send(c.version(), &Command{Name: name, Args: args})
+}
+
+func (c *Client) call(ctx context.Context, ver amf.Version, cmd *Command) (*Command, error) {
+	ch := make(chan *Command, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+
+	c.txid++
+	cmd.TransactionId = c.txid
+	c.pending[cmd.TransactionId] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, cmd.TransactionId)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(ver, cmd); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		if res.Name == "_error" {
+			return nil, statusError(cmd.Name, res)
+		}
+
+		return res, nil
+
+	case <-c.done:
+		return nil, c.Err()
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// version returns the negotiated object encoding.
+func (c *Client) version() amf.Version {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.encoding
+}
+
+func (c *Client) send(ver amf.Version, cmd *Command) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return amf.Error("rtmp: not connected")
+	}
+
+	msg, err := EncodeCommand(nil, ver, 0, cmd)
+	if err != nil {
+		return err
+	}
+
+	return conn.w.WriteMessage(msg)
+}
+
+// statusError returns the information object of an _error response as an
+// *amf.StatusError.
+func statusError(name string, res *Command) error {
+	info := res.Object
+	if len(res.Args) > 0 {
+		info = res.Args[0]
+	}
+
+	status := new(amf.StatusError)
+	if err := amf.Convert(info, status); err != nil || status.Code == "" {
+		return amf.Error("rtmp: call %s failed: %#v", name, info)
+	}
+
+	return status
+}
+
+func (c *Client) readLoop() {
+	d := c.Decoder
+	if d == nil {
+		d = amf.NewDecoder()
+	}
+
+	for {
+		msg, err := c.conn.readMessage()
+		if err != nil {
+			c.fail(amf.Error("rtmp: connection lost: %s", err))
+			return
+		}
+
+		if msg.Type != MSG_COMMAND_AMF0 && msg.Type != MSG_COMMAND_AMF3 {
+			continue
+		}
+
+		cmd, err := DecodeCommand(d, msg)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.dispatch(cmd)
+	}
+}
+
+func (c *Client) dispatch(cmd *Command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// results are delivered once, so that duplicates or results nobody
+	// waits for do not block the read loop
+	if cmd.Name == "_result" || cmd.Name == "_error" {
+		if ch, ok := c.pending[cmd.TransactionId]; ok {
+			select {
+			case ch <- cmd:
+			default:
+			}
+			delete(c.pending, cmd.TransactionId)
+		}
+		return
+	}
+
+	// handlers may make calls themselves, so they run apart from the
+	// read loop, one at a time to keep their order
+	c.calls = append(c.calls, cmd)
+	if !c.serving {
+		c.serving = true
+		go c.serveCalls()
+	}
+}
+
+func (c *Client) serveCalls() {
+	for {
+		c.mu.Lock()
+		if len(c.calls) == 0 {
+			c.serving = false
+			c.mu.Unlock()
+			return
+		}
+
+		cmd := c.calls[0]
+		c.calls = c.calls[1:]
+		h := c.handlers[cmd.Name]
+		c.mu.Unlock()
+
+		c.serve(cmd, h)
+	}
+}
+
+func (c *Client) serve(cmd *Command, h Handler) {
+	var result interface{}
+	var err error
+
+	if h != nil {
+		result, err = h(cmd)
+	} else {
+		err = amf.NewStatusError("NetConnection.Call.Failed", "method %s not found", cmd.Name)
+	}
+
+	if cmd.TransactionId == 0 {
+		return
+	}
+
+	reply := &Command{Name: "_result", TransactionId: cmd.TransactionId, Args: []interface{}{result}}
+	if err != nil {
+		reply.Name = "_error"
+		reply.Args = []interface{}{statusOf(err)}
+	}
+
+	c.send(c.version(), reply)
+}
+
+// statusOf returns err as a status, wrapping errors that are not one.
+func statusOf(err error) *amf.StatusError {
+	if se, ok := err.(*amf.StatusError); ok {
+		return se
+	}
+
+	return amf.NewStatusError("NetConnection.Call.Failed", "%s", err)
+}
+
+// Err returns the error that ended the connection, if it has ended.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Done is closed when the connection ends.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection, failing calls in progress.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	c.fail(amf.Error("rtmp: connection closed"))
+
+	if conn == nil {
+		return nil
+	}
+
+	return conn.close()
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.done)
+}
@@ -0,0 +1,282 @@
+package rtmp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// standIn is a minimal rtmp server answering connect, add and fail, and
+// calling back the client with ping when it connects.
+type standIn struct {
+	t        *testing.T
+	l        net.Listener
+	pingDone chan interface{}
+}
+
+func newStandIn(t *testing.T, l net.Listener) *standIn {
+	s := &standIn{t: t, l: l, pingDone: make(chan interface{}, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *standIn) serve() {
+	nc, err := s.l.Accept()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+
+	if err = ServerHandshake(nc); err != nil {
+		s.t.Errorf("stand in: handshake: %s", err)
+		return
+	}
+
+	c := newConn(nc)
+	c.w.WriteMessage(NewWindowAckSize(2500000))
+	c.w.WriteMessage(NewSetPeerBandwidth(2500000, BANDWIDTH_LIMIT_DYNAMIC))
+
+	encoding := amf.Version(amf.AMF0)
+	reply := func(cmd *Command) {
+		msg, err := EncodeCommand(nil, encoding, 0, cmd)
+		if err != nil {
+			s.t.Errorf("stand in: encode: %s", err)
+			return
+		}
+		c.w.WriteMessage(msg)
+	}
+
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return
+		}
+
+		if msg.Type != MSG_COMMAND_AMF0 && msg.Type != MSG_COMMAND_AMF3 {
+			continue
+		}
+
+		cmd, err := DecodeCommand(nil, msg)
+		if err != nil {
+			s.t.Errorf("stand in: decode: %s", err)
+			return
+		}
+
+		switch cmd.Name {
+		case "connect":
+			obj := cmd.Object.(amf.Object)
+			if obj["app"] != "live" {
+				s.t.Errorf("stand in: unexpected app %v", obj["app"])
+			}
+			encoding = amf.Version(obj["objectEncoding"].(float64))
+
+			reply(&Command{
+				Name:          "_result",
+				TransactionId: cmd.TransactionId,
+				Object:        amf.Object{"fmsVer": "FMS/3,5,7,7009"},
+				Args: []interface{}{amf.Object{
+					"level":          "status",
+					"code":           "NetConnection.Connect.Success",
+					"objectEncoding": float64(encoding),
+				}},
+			})
+			reply(&Command{Name: "onBWDone"})
+			reply(&Command{Name: "ping", TransactionId: 1, Args: []interface{}{"hello"}})
+
+		case "add":
+			var a, b float64
+			amf.Convert(cmd.Args[0], &a)
+			amf.Convert(cmd.Args[1], &b)
+			reply(&Command{Name: "_result", TransactionId: cmd.TransactionId, Args: []interface{}{a + b}})
+
+		case "fail":
+			reply(&Command{Name: "_error", TransactionId: cmd.TransactionId, Args: []interface{}{amf.Object{
+				"level":       "error",
+				"code":        "NetConnection.Call.Failed",
+				"description": "failed on purpose",
+			}}})
+
+		case "_result", "_error":
+			if len(cmd.Args) > 0 {
+				s.pingDone <- cmd.Args[0]
+			} else {
+				s.pingDone <- cmd.Name
+			}
+		}
+	}
+}
+
+func testClient(t *testing.T, c *Client, encoding amf.Version, s *standIn) {
+	pinged := make(chan string, 1)
+	c.Handle("ping", func(cmd *Command) (interface{}, error) {
+		pinged <- cmd.Args[0].(string)
+		return "pong", nil
+	})
+
+	c.ObjectEncoding = encoding
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := c.Connect(ctx)
+	if err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+	defer c.Close()
+
+	if c.ObjectEncoding != encoding {
+		t.Errorf("expected object encoding %d, got %d", encoding, c.ObjectEncoding)
+	}
+
+	if info, ok := res.Args[0].(amf.Object); !ok || info["code"] != "NetConnection.Connect.Success" {
+		t.Errorf("unexpected connect result %#v", res)
+	}
+
+	result, err := c.Call(ctx, "add", 1, 2)
+	var sum float64
+	if err != nil || amf.Convert(result, &sum) != nil || sum != 3 {
+		t.Errorf("expected 3, got %#v: %v", result, err)
+	}
+
+	_, err = c.Call(ctx, "fail")
+	if status, ok := err.(*amf.StatusError); !ok || status.Code != "NetConnection.Call.Failed" {
+		t.Errorf("expected status error, got %#v", err)
+	}
+
+	select {
+	case arg := <-pinged:
+		if arg != "hello" {
+			t.Errorf("expected ping hello, got %s", arg)
+		}
+	case <-ctx.Done():
+		t.Fatalf("server call not handled")
+	}
+
+	select {
+	case result := <-s.pingDone:
+		if result != "pong" {
+			t.Errorf("expected pong, got %#v", result)
+		}
+	case <-ctx.Done():
+		t.Fatalf("server call not answered")
+	}
+
+	c.Close()
+
+	if _, err = c.Call(ctx, "add", 1, 2); err == nil {
+		t.Errorf("expected error after close")
+	}
+}
+
+func TestClient(t *testing.T) {
+	for _, encoding := range []amf.Version{amf.AMF0, amf.AMF3} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %s", err)
+		}
+
+		s := newStandIn(t, l)
+
+		c, err := NewClient("rtmp://" + l.Addr().String() + "/live")
+		if err != nil {
+			t.Fatalf("new client: %s", err)
+		}
+
+		testClient(t, c, encoding, s)
+		l.Close()
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	// borrow the test certificate of httptest
+	hs := httptest.NewTLSServer(nil)
+	cert := hs.TLS.Certificates[0]
+	pool := x509.NewCertPool()
+	pool.AddCert(hs.Certificate())
+	hs.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+
+	s := newStandIn(t, l)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	c, err := NewClient("rtmps://127.0.0.1:" + port + "/live")
+	if err != nil {
+		t.Fatalf("new client: %s", err)
+	}
+	c.TLSConfig = &tls.Config{RootCAs: pool}
+
+	testClient(t, c, amf.AMF3, s)
+}
+
+func TestClientServesCallsInOrder(t *testing.T) {
+	c, err := NewClient("rtmp://127.0.0.1/live")
+	if err != nil {
+		t.Fatalf("new client: %s", err)
+	}
+
+	got := make(chan float64, 3)
+	c.Handle("note", func(cmd *Command) (interface{}, error) {
+		n := cmd.Args[0].(float64)
+		if n == 1 {
+			// a slow first handler must not let later calls overtake it
+			time.Sleep(20 * time.Millisecond)
+		}
+		got <- n
+		return nil, nil
+	})
+
+	for n := 1; n <= 3; n++ {
+		c.dispatch(&Command{Name: "note", Args: []interface{}{float64(n)}})
+	}
+
+	for n := 1; n <= 3; n++ {
+		select {
+		case v := <-got:
+			if v != float64(n) {
+				t.Errorf("expected call %d, got %v", n, v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("call %d not served", n)
+		}
+	}
+}
+
+func TestClientDuplicateResult(t *testing.T) {
+	c, err := NewClient("rtmp://127.0.0.1/live")
+	if err != nil {
+		t.Fatalf("new client: %s", err)
+	}
+
+	ch := make(chan *Command, 1)
+	c.pending[1] = ch
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2; i++ {
+			c.dispatch(&Command{Name: "_result", TransactionId: 1})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("duplicate result blocked the read loop")
+	}
+
+	if len(ch) != 1 || len(c.pending) != 0 {
+		t.Errorf("expected one result delivered and the call removed, got %d and %d", len(ch), len(c.pending))
+	}
+}
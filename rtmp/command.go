@@ -0,0 +1,180 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// Command is a remote procedure call or its response, e.g. connect,
+// createStream, _result or onStatus.
+type Command struct {
+	Name          string
+	TransactionId float64
+	Object        interface{}
+	Args          []interface{}
+}
+
+// DecodeCommand decodes a command message with d, which may be nil. Values
+// of amf3 command messages are amf0, switched to amf3 with the avmplus
+// marker as needed, and are preceded by a zero format byte.
+//
+// format:
+// - amf0 string name
+// - amf0 number transaction id
+// - command object (usually null for requests other than connect)
+// - any number of arguments
+func DecodeCommand(d *amf.Decoder, m *Message) (*Command, error) {
+	if m.Type != MSG_COMMAND_AMF0 && m.Type != MSG_COMMAND_AMF3 {
+		return nil, amf.Error("rtmp: message type %d is not a command", m.Type)
+	}
+
+	values, err := decodeValues(d, m)
+	if err != nil {
+		return nil, amf.Error("rtmp: unable to decode command: %s", err)
+	}
+
+	if len(values) == 0 {
+		return nil, amf.Error("rtmp: empty command")
+	}
+
+	c := new(Command)
+
+	var ok bool
+	if c.Name, ok = values[0].(string); !ok {
+		return nil, amf.Error("rtmp: expected command name, got %T", values[0])
+	}
+
+	if len(values) > 1 {
+		if c.TransactionId, ok = values[1].(float64); !ok {
+			return nil, amf.Error("rtmp: expected transaction id for %s, got %T", c.Name, values[1])
+		}
+	}
+
+	if len(values) > 2 {
+		c.Object = values[2]
+	}
+
+	if len(values) > 3 {
+		c.Args = values[3:]
+	}
+
+	return c, nil
+}
+
+// EncodeCommand encodes c with e, which may be nil, as a command message of
+// version ver for message stream streamId.
+func EncodeCommand(e *amf.Encoder, ver amf.Version, streamId uint32, c *Command) (*Message, error) {
+	values := append([]interface{}{c.Name, c.TransactionId, c.Object}, c.Args...)
+
+	typeId := uint8(MSG_COMMAND_AMF0)
+	if ver == amf.AMF3 {
+		typeId = MSG_COMMAND_AMF3
+	}
+
+	msg, err := encodeValues(e, ver, typeId, streamId, values)
+	if err != nil {
+		return nil, amf.Error("rtmp: unable to encode command %s: %s", c.Name, err)
+	}
+
+	return msg, nil
+}
+
+// DecodeData decodes the values of a data message, e.g. "onMetaData"
+// followed by the metadata object.
+func DecodeData(d *amf.Decoder, m *Message) ([]interface{}, error) {
+	if m.Type != MSG_DATA_AMF0 && m.Type != MSG_DATA_AMF3 {
+		return nil, amf.Error("rtmp: message type %d is not data", m.Type)
+	}
+
+	values, err := decodeValues(d, m)
+	if err != nil {
+		return nil, amf.Error("rtmp: unable to decode data: %s", err)
+	}
+
+	return values, nil
+}
+
+// EncodeData encodes values as a data message of version ver.
+func EncodeData(e *amf.Encoder, ver amf.Version, streamId uint32, values ...interface{}) (*Message, error) {
+	typeId := uint8(MSG_DATA_AMF0)
+	if ver == amf.AMF3 {
+		typeId = MSG_DATA_AMF3
+	}
+
+	msg, err := encodeValues(e, ver, typeId, streamId, values)
+	if err != nil {
+		return nil, amf.Error("rtmp: unable to encode data: %s", err)
+	}
+
+	return msg, nil
+}
+
+func decodeValues(d *amf.Decoder, m *Message) ([]interface{}, error) {
+	if d == nil {
+		d = amf.NewDecoder()
+	}
+	d.Reset()
+
+	payload := m.Payload
+	if (m.Type == MSG_COMMAND_AMF3 || m.Type == MSG_DATA_AMF3) && len(payload) > 0 && payload[0] == 0 {
+		payload = payload[1:]
+	}
+
+	r := bytes.NewReader(payload)
+
+	var values []interface{}
+	for r.Len() > 0 {
+		v, err := d.DecodeAmf0(r)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+// encodeValues writes values as amf0, except that values after the command
+// name and transaction id of amf3 messages are switched to amf3.
+func encodeValues(e *amf.Encoder, ver amf.Version, typeId uint8, streamId uint32, values []interface{}) (*Message, error) {
+	if e == nil {
+		e = new(amf.Encoder)
+	}
+	e.Reset()
+
+	buf := new(bytes.Buffer)
+	if ver == amf.AMF3 {
+		buf.WriteByte(0)
+	}
+
+	for i, v := range values {
+		if err := encodeValue(e, buf, ver, v, i < 2 && typeId == MSG_COMMAND_AMF3); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Message{
+		ChunkStreamId: CHUNK_STREAM_COMMAND,
+		Type:          typeId,
+		StreamId:      streamId,
+		Payload:       buf.Bytes(),
+	}, nil
+}
+
+func encodeValue(e *amf.Encoder, w io.Writer, ver amf.Version, v interface{}, header bool) error {
+	if ver != amf.AMF3 || header {
+		_, err := e.EncodeAmf0(w, v)
+		return err
+	}
+
+	if err := amf.WriteMarker(w, amf.AMF0_ACMPLUS_OBJECT_MARKER); err != nil {
+		return err
+	}
+
+	_, err := e.EncodeAmf3(w, v)
+
+	return err
+}
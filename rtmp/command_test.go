@@ -0,0 +1,89 @@
+package rtmp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	amf "github.com/elobuff/goamf"
+)
+
+func TestCommandRoundTrip(t *testing.T) {
+	c := &Command{
+		Name:          "connect",
+		TransactionId: 1,
+		Object:        amf.Object{"app": "live", "objectEncoding": float64(3)},
+		Args:          []interface{}{"token", float64(2)},
+	}
+
+	for _, ver := range []amf.Version{amf.AMF0, amf.AMF3} {
+		msg, err := EncodeCommand(nil, ver, 0, c)
+		if err != nil {
+			t.Fatalf("encode: %s", err)
+		}
+
+		if ver == amf.AMF3 && (msg.Type != MSG_COMMAND_AMF3 || msg.Payload[0] != 0) {
+			t.Errorf("expected amf3 command, got type %d", msg.Type)
+		}
+
+		// through the chunk stream, as on the wire
+		buf := new(bytes.Buffer)
+		NewWriter(buf).WriteMessage(msg)
+		msg, err = NewReader(buf).ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %s", err)
+		}
+
+		decoded, err := DecodeCommand(amf.NewDecoder(), msg)
+		if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+
+		if !reflect.DeepEqual(decoded, c) {
+			t.Errorf("version %d: expected %#v, got %#v", ver, c, decoded)
+		}
+	}
+}
+
+func TestDecodeCommand(t *testing.T) {
+	// _result of createStream: "_result", 2, null, 1
+	payload := []byte{
+		0x02, 0x00, 0x07, '_', 'r', 'e', 's', 'u', 'l', 't',
+		0x00, 0x40, 0x00, 0, 0, 0, 0, 0, 0,
+		0x05,
+		0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0,
+	}
+
+	c, err := DecodeCommand(nil, &Message{Type: MSG_COMMAND_AMF0, Payload: payload})
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	if c.Name != "_result" || c.TransactionId != 2 || c.Object != nil || len(c.Args) != 1 || c.Args[0] != float64(1) {
+		t.Errorf("unexpected command %#v", c)
+	}
+
+	if _, err = DecodeCommand(nil, &Message{Type: MSG_AUDIO, Payload: payload}); err == nil {
+		t.Errorf("expected error for audio message")
+	}
+}
+
+func TestDataRoundTrip(t *testing.T) {
+	values := []interface{}{"onMetaData", amf.Object{"width": float64(640)}}
+
+	for _, ver := range []amf.Version{amf.AMF0, amf.AMF3} {
+		msg, err := EncodeData(nil, ver, 1, values...)
+		if err != nil {
+			t.Fatalf("encode: %s", err)
+		}
+
+		decoded, err := DecodeData(nil, msg)
+		if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+
+		if !reflect.DeepEqual(decoded, values) {
+			t.Errorf("version %d: expected %#v, got %#v", ver, values, decoded)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package rtmp
+
+import (
+	"io"
+)
+
+// conn is a chunk stream connection after its handshake, answering the
+// protocol control messages of the peer: acknowledgements are sent once
+// per window, Set Peer Bandwidth is acknowledged with a window size, and
+// pings are answered.
+type conn struct {
+	rwc io.ReadWriteCloser
+	r   *Reader
+	w   *Writer
+
+	// read side state, owned by the reading goroutine
+	window     uint32
+	acked      uint64
+	peerWindow uint32
+}
+
+func newConn(rwc io.ReadWriteCloser) *conn {
+	return &conn{
+		rwc: rwc,
+		r:   NewReader(rwc),
+		w:   NewWriter(rwc),
+	}
+}
+
+// readMessage reads the next message. Protocol control messages are
+// handled, then returned like any other message.
+func (c *conn) readMessage() (*Message, error) {
+	msg, err := c.r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if read := c.r.BytesRead(); c.window > 0 && read-c.acked >= uint64(c.window) {
+		c.acked = read
+		if err = c.w.WriteMessage(NewAck(uint32(read))); err != nil {
+			return nil, err
+		}
+	}
+
+	switch msg.Type {
+	case MSG_WINDOW_ACK_SIZE:
+		if c.window, err = msg.ControlValue(); err != nil {
+			return nil, err
+		}
+
+	case MSG_SET_PEER_BANDWIDTH:
+		size, _, err := msg.PeerBandwidth()
+		if err != nil {
+			return nil, err
+		}
+
+		if size != c.peerWindow {
+			c.peerWindow = size
+			if err = c.w.WriteMessage(NewWindowAckSize(size)); err != nil {
+				return nil, err
+			}
+		}
+
+	case MSG_USER_CONTROL:
+		event, data, err := msg.UserControl()
+		if err != nil {
+			return nil, err
+		}
+
+		if event == USER_CONTROL_PING_REQUEST && len(data) > 0 {
+			if err = c.w.WriteMessage(NewUserControl(USER_CONTROL_PING_RESPONSE, data[0])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return msg, nil
+}
+
+func (c *conn) close() error {
+	return c.rwc.Close()
+}
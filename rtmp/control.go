@@ -0,0 +1,144 @@
+package rtmp
+
+import (
+	"encoding/binary"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// limit types of Set Peer Bandwidth
+const (
+	BANDWIDTH_LIMIT_HARD    = 0
+	BANDWIDTH_LIMIT_SOFT    = 1
+	BANDWIDTH_LIMIT_DYNAMIC = 2
+)
+
+// NewSetChunkSize returns a Set Chunk Size message. Writer.SetChunkSize
+// should be used to send it, so that the writer uses the new size.
+func NewSetChunkSize(size uint32) *Message {
+	return newControl(MSG_SET_CHUNK_SIZE, size)
+}
+
+// NewAbort returns an Abort message, discarding the partly sent message of
+// chunk stream csid.
+func NewAbort(csid uint32) *Message {
+	return newControl(MSG_ABORT, csid)
+}
+
+// NewAck returns an Acknowledgement of the sequence number bytes received.
+func NewAck(sequence uint32) *Message {
+	return newControl(MSG_ACK, sequence)
+}
+
+// NewWindowAckSize returns a Window Acknowledgement Size message, asking
+// the peer to acknowledge every size bytes.
+func NewWindowAckSize(size uint32) *Message {
+	return newControl(MSG_WINDOW_ACK_SIZE, size)
+}
+
+// NewSetPeerBandwidth returns a Set Peer Bandwidth message, limiting the
+// output bandwidth of the peer.
+//
+// format:
+// - 4 byte big endian window size
+// - 1 byte limit type
+func NewSetPeerBandwidth(size uint32, limit uint8) *Message {
+	msg := newControl(MSG_SET_PEER_BANDWIDTH, size)
+	msg.Payload = append(msg.Payload, limit)
+
+	return msg
+}
+
+// format:
+// - 4 byte big endian value
+func newControl(typeId uint8, value uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, value)
+
+	return &Message{
+		ChunkStreamId: CHUNK_STREAM_CONTROL,
+		Type:          typeId,
+		Payload:       payload,
+	}
+}
+
+// ControlValue returns the value of a Set Chunk Size, Abort,
+// Acknowledgement or Window Acknowledgement Size message, or the window
+// size of a Set Peer Bandwidth message.
+func (m *Message) ControlValue() (uint32, error) {
+	switch m.Type {
+	case MSG_SET_CHUNK_SIZE, MSG_ABORT, MSG_ACK, MSG_WINDOW_ACK_SIZE, MSG_SET_PEER_BANDWIDTH:
+	default:
+		return 0, amf.Error("rtmp: message type %d is not a control message", m.Type)
+	}
+
+	if len(m.Payload) < 4 {
+		return 0, amf.Error("rtmp: control message type %d too short: %d bytes", m.Type, len(m.Payload))
+	}
+
+	return binary.BigEndian.Uint32(m.Payload), nil
+}
+
+// PeerBandwidth returns the window size and limit type of a Set Peer
+// Bandwidth message.
+func (m *Message) PeerBandwidth() (uint32, uint8, error) {
+	if m.Type != MSG_SET_PEER_BANDWIDTH {
+		return 0, 0, amf.Error("rtmp: message type %d is not set peer bandwidth", m.Type)
+	}
+
+	if len(m.Payload) < 5 {
+		return 0, 0, amf.Error("rtmp: set peer bandwidth too short: %d bytes", len(m.Payload))
+	}
+
+	return binary.BigEndian.Uint32(m.Payload), m.Payload[4], nil
+}
+
+// user control events
+const (
+	USER_CONTROL_STREAM_BEGIN       = 0
+	USER_CONTROL_STREAM_EOF         = 1
+	USER_CONTROL_STREAM_DRY         = 2
+	USER_CONTROL_SET_BUFFER_LENGTH  = 3
+	USER_CONTROL_STREAM_IS_RECORDED = 4
+	USER_CONTROL_PING_REQUEST       = 6
+	USER_CONTROL_PING_RESPONSE      = 7
+)
+
+// NewUserControl returns a User Control message of event type event.
+//
+// format:
+// - 2 byte big endian event type
+// - event data, 4 byte big endian values (a stream id, a timestamp, or a
+//   stream id followed by a buffer length)
+func NewUserControl(event uint16, data ...uint32) *Message {
+	payload := make([]byte, 2+4*len(data))
+	binary.BigEndian.PutUint16(payload, event)
+
+	for i, v := range data {
+		binary.BigEndian.PutUint32(payload[2+4*i:], v)
+	}
+
+	return &Message{
+		ChunkStreamId: CHUNK_STREAM_CONTROL,
+		Type:          MSG_USER_CONTROL,
+		Payload:       payload,
+	}
+}
+
+// UserControl returns the event type and data of a User Control message.
+func (m *Message) UserControl() (uint16, []uint32, error) {
+	if m.Type != MSG_USER_CONTROL {
+		return 0, nil, amf.Error("rtmp: message type %d is not user control", m.Type)
+	}
+
+	if len(m.Payload) < 2 || (len(m.Payload)-2)%4 != 0 {
+		return 0, nil, amf.Error("rtmp: invalid user control length %d", len(m.Payload))
+	}
+
+	data := make([]uint32, (len(m.Payload)-2)/4)
+	for i := range data {
+		data[i] = binary.BigEndian.Uint32(m.Payload[2+4*i:])
+	}
+
+	return binary.BigEndian.Uint16(m.Payload), data, nil
+}
@@ -0,0 +1,97 @@
+package rtmp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+const (
+	RTMP_VERSION   = 3
+	HANDSHAKE_SIZE = 1536
+)
+
+// ClientHandshake performs the simple (unencrypted, undigested) handshake
+// as a client.
+//
+// format:
+// - c0: 1 byte version (3)
+// - c1: 4 byte time, 4 zero bytes, 1528 random bytes
+// - s0, s1 and s2 are read, where s2 echoes c1
+// - c2: echo of s1
+func ClientHandshake(rw io.ReadWriter) error {
+	c0c1, err := newHandshake()
+	if err != nil {
+		return err
+	}
+
+	if _, err = rw.Write(c0c1); err != nil {
+		return amf.Error("rtmp handshake: unable to write c0 and c1: %s", err)
+	}
+
+	s0s1 := make([]byte, 1+HANDSHAKE_SIZE)
+	if _, err = io.ReadFull(rw, s0s1); err != nil {
+		return amf.Error("rtmp handshake: unable to read s0 and s1: %s", err)
+	}
+
+	if s0s1[0] != RTMP_VERSION {
+		return amf.Error("rtmp handshake: unsupported version %d", s0s1[0])
+	}
+
+	if _, err = rw.Write(s0s1[1:]); err != nil {
+		return amf.Error("rtmp handshake: unable to write c2: %s", err)
+	}
+
+	// servers answering with a complex handshake do not echo c1 verbatim,
+	// so s2 is not checked
+	if _, err = io.ReadFull(rw, make([]byte, HANDSHAKE_SIZE)); err != nil {
+		return amf.Error("rtmp handshake: unable to read s2: %s", err)
+	}
+
+	return nil
+}
+
+// ServerHandshake performs the simple handshake as a server, the
+// counterpart of ClientHandshake.
+func ServerHandshake(rw io.ReadWriter) error {
+	c0c1 := make([]byte, 1+HANDSHAKE_SIZE)
+	if _, err := io.ReadFull(rw, c0c1); err != nil {
+		return amf.Error("rtmp handshake: unable to read c0 and c1: %s", err)
+	}
+
+	if c0c1[0] != RTMP_VERSION {
+		return amf.Error("rtmp handshake: unsupported version %d", c0c1[0])
+	}
+
+	s0s1, err := newHandshake()
+	if err != nil {
+		return err
+	}
+
+	if _, err = rw.Write(append(s0s1, c0c1[1:]...)); err != nil {
+		return amf.Error("rtmp handshake: unable to write s0, s1 and s2: %s", err)
+	}
+
+	if _, err = io.ReadFull(rw, make([]byte, HANDSHAKE_SIZE)); err != nil {
+		return amf.Error("rtmp handshake: unable to read c2: %s", err)
+	}
+
+	return nil
+}
+
+// newHandshake returns the version byte followed by a c1 or s1 chunk.
+func newHandshake() ([]byte, error) {
+	b := make([]byte, 1+HANDSHAKE_SIZE)
+	b[0] = RTMP_VERSION
+
+	binary.BigEndian.PutUint32(b[1:5], uint32(time.Now().UnixNano()/int64(time.Millisecond)))
+
+	if _, err := rand.Read(b[9:]); err != nil {
+		return nil, amf.Error("rtmp handshake: unable to generate random bytes: %s", err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,56 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+)
+
+// handshakes write ahead of what they read, so they are tested over tcp
+// rather than an unbuffered pipe
+func TestHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		server, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer server.Close()
+
+		errs <- ServerHandshake(server)
+	}()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer client.Close()
+
+	if err := ClientHandshake(client); err != nil {
+		t.Fatalf("client: %s", err)
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatalf("server: %s", err)
+	}
+}
+
+func TestHandshakeVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		client.Write(append([]byte{6}, make([]byte, HANDSHAKE_SIZE)...))
+	}()
+
+	if err := ServerHandshake(server); err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+	server.Close()
+}
@@ -0,0 +1,44 @@
+// Package rtmp implements the chunk stream layer of the real time messaging
+// protocol, with commands and data encoded by package amf.
+package rtmp
+
+// message types
+const (
+	MSG_SET_CHUNK_SIZE     = 1
+	MSG_ABORT              = 2
+	MSG_ACK                = 3
+	MSG_USER_CONTROL       = 4
+	MSG_WINDOW_ACK_SIZE    = 5
+	MSG_SET_PEER_BANDWIDTH = 6
+	MSG_AUDIO              = 8
+	MSG_VIDEO              = 9
+	MSG_DATA_AMF3          = 15
+	MSG_SHARED_OBJECT_AMF3 = 16
+	MSG_COMMAND_AMF3       = 17
+	MSG_DATA_AMF0          = 18
+	MSG_SHARED_OBJECT_AMF0 = 19
+	MSG_COMMAND_AMF0       = 20
+	MSG_AGGREGATE          = 22
+)
+
+// chunk streams messages are usually sent on
+const (
+	CHUNK_STREAM_CONTROL = 2
+	CHUNK_STREAM_COMMAND = 3
+)
+
+const (
+	DEFAULT_CHUNK_SIZE = 128
+	MAX_CHUNK_SIZE     = 0x7fffffff
+	MAX_MESSAGE_LENGTH = 0xffffff
+	MAX_CHUNK_STREAM   = 65599
+)
+
+// Message is a complete rtmp message, reassembled from its chunks.
+type Message struct {
+	ChunkStreamId uint32
+	Type          uint8
+	StreamId      uint32
+	Timestamp     uint32
+	Payload       []byte
+}
@@ -0,0 +1,505 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+const RTMPT_CONTENT_TYPE = "application/x-fcs"
+
+// bounds of the polling delay sent with each rtmpt response, which
+// clients here wait in units of 10ms before their next idle request
+const (
+	RTMPT_MIN_INTERVAL = 0x01
+	RTMPT_MAX_INTERVAL = 0x21
+)
+
+// limits of a TunnelHandler when its fields are zero
+const (
+	RTMPT_DEFAULT_MAX_REQUEST_SIZE = 1 << 20
+	RTMPT_DEFAULT_MAX_SESSIONS     = 1024
+)
+
+// the longest a tunnelConn waits for each of its requests
+const RTMPT_REQUEST_TIMEOUT = 30 * time.Second
+
+var errTunnelFull = amf.Error("rtmpt: too many sessions")
+
+// TunnelHandler is an http.Handler serving rtmp tunnelled over http
+// (rtmpt) for a Server. Clients open a session, then post the bytes they
+// send to /send and poll with /idle, each response carrying a polling
+// delay byte followed by the bytes the server has written since.
+//
+// format:
+// - POST /open/1: responds with the session id and a newline
+// - POST /send/<session id>/<sequence>: body is rtmp bytes
+// - POST /idle/<session id>/<sequence>: body is a single zero byte
+// - POST /close/<session id>/<sequence>
+//
+// Sequence numbers count up from 1 in each session, and requests out of
+// order are refused.
+type TunnelHandler struct {
+	Server *Server
+
+	// Timeout closes sessions without a request for that long, 60 seconds
+	// when it is zero.
+	Timeout time.Duration
+
+	// MaxRequestSize limits the size of request bodies, which are refused
+	// beyond it. RTMPT_DEFAULT_MAX_REQUEST_SIZE is used when it is zero.
+	MaxRequestSize int64
+
+	// MaxSessions limits the number of sessions open at once, new ones
+	// being refused beyond it. RTMPT_DEFAULT_MAX_SESSIONS is used when it
+	// is zero.
+	MaxSessions int
+
+	mu       sync.Mutex
+	sessions map[string]*tunnelSession
+}
+
+func NewTunnelHandler(s *Server) *TunnelHandler {
+	return &TunnelHandler{Server: s}
+}
+
+// tunnelSession is the connection served by the Server for an rtmpt
+// session, fed by the bodies of requests and drained by their responses.
+type tunnelSession struct {
+	id    string
+	timer *time.Timer
+
+	mu       sync.Mutex
+	seq      int
+	cond     *sync.Cond
+	in       bytes.Buffer
+	out      bytes.Buffer
+	closed   bool
+	interval byte
+}
+
+func (t *TunnelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "rtmpt: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	limit := t.MaxRequestSize
+	if limit <= 0 {
+		limit = RTMPT_DEFAULT_MAX_REQUEST_SIZE
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if parts[0] == "open" {
+		s, err := t.open()
+		if err == errTunnelFull {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		t.respond(w, []byte(s.id+"\n"))
+		return
+	}
+
+	if len(parts) < 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	seq, err := strconv.Atoi(parts[2])
+	if err != nil {
+		http.Error(w, "rtmpt: invalid sequence number", http.StatusBadRequest)
+		return
+	}
+
+	t.mu.Lock()
+	s := t.sessions[parts[1]]
+	t.mu.Unlock()
+
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// a close may overtake the request still in flight before it
+	if !s.next(seq, parts[0] == "close") {
+		http.Error(w, "rtmpt: unexpected sequence number", http.StatusBadRequest)
+		return
+	}
+
+	switch parts[0] {
+	case "send", "idle":
+		if parts[0] == "idle" {
+			body = nil
+		}
+
+		data, ok := s.exchange(body, t.timeout())
+		if !ok {
+			t.remove(s)
+			http.NotFound(w, r)
+			return
+		}
+
+		t.respond(w, data)
+
+	case "close":
+		t.remove(s)
+		t.respond(w, []byte{0})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (t *TunnelHandler) respond(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", RTMPT_CONTENT_TYPE)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+func (t *TunnelHandler) timeout() time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+
+	return 60 * time.Second
+}
+
+func (t *TunnelHandler) open() (*tunnelSession, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, amf.Error("rtmpt: unable to generate session id: %s", err)
+	}
+
+	s := &tunnelSession{id: hex.EncodeToString(b), interval: RTMPT_MIN_INTERVAL}
+	s.cond = sync.NewCond(&s.mu)
+
+	max := t.MaxSessions
+	if max <= 0 {
+		max = RTMPT_DEFAULT_MAX_SESSIONS
+	}
+
+	t.mu.Lock()
+	if len(t.sessions) >= max {
+		t.mu.Unlock()
+		return nil, errTunnelFull
+	}
+
+	if t.sessions == nil {
+		t.sessions = make(map[string]*tunnelSession)
+	}
+	t.sessions[s.id] = s
+	t.mu.Unlock()
+
+	s.timer = time.AfterFunc(t.timeout(), func() { t.remove(s) })
+
+	go func() {
+		t.Server.ServeConn(s)
+		s.Close()
+	}()
+
+	return s, nil
+}
+
+func (t *TunnelHandler) remove(s *tunnelSession) {
+	t.mu.Lock()
+	delete(t.sessions, s.id)
+	t.mu.Unlock()
+
+	s.timer.Stop()
+	s.Close()
+}
+
+// next reports whether seq follows the last sequence number of the
+// session, or comes anywhere after it if later is set, and advances it.
+func (s *tunnelSession) next(seq int, later bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seq != s.seq+1 && !(later && seq > s.seq) {
+		return false
+	}
+
+	s.seq = seq
+	return true
+}
+
+// exchange hands the bytes posted by the client to the server, and returns
+// the polling delay followed by the bytes written by the server. It
+// reports false once the session is closed and drained.
+func (s *tunnelSession) exchange(data []byte, timeout time.Duration) ([]byte, bool) {
+	s.timer.Reset(timeout)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed && s.out.Len() == 0 {
+		return nil, false
+	}
+
+	s.in.Write(data)
+	s.cond.Broadcast()
+
+	// the delay grows while the session is idle
+	if len(data) > 0 || s.out.Len() > 0 {
+		s.interval = RTMPT_MIN_INTERVAL
+	} else if s.interval < RTMPT_MAX_INTERVAL {
+		s.interval++
+	}
+
+	res := make([]byte, 1+s.out.Len())
+	res[0] = s.interval
+	s.out.Read(res[1:])
+
+	return res, true
+}
+
+func (s *tunnelSession) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.in.Len() == 0 && !s.closed {
+		s.cond.Wait()
+	}
+
+	if s.in.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return s.in.Read(p)
+}
+
+func (s *tunnelSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, amf.Error("rtmpt: session closed")
+	}
+
+	return s.out.Write(p)
+}
+
+func (s *tunnelSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+
+	return nil
+}
+
+// tunnelConn is the client side of an rtmpt session. Writes are posted by
+// a polling goroutine, which also collects the bytes the server sends.
+type tunnelConn struct {
+	httpClient *http.Client
+	base       string
+	id         string
+	seq        int
+
+	// ctx lives as long as the session, ending requests in flight on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wake chan struct{}
+	stop chan struct{}
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	in   bytes.Buffer
+	out  bytes.Buffer
+	err  error
+}
+
+// DialTunnel opens an rtmpt session with the server at baseURL, e.g.
+// "http://host:80", returning a connection for Client.Dial. If httpClient
+// is nil, http.DefaultClient is used.
+func DialTunnel(ctx context.Context, baseURL string, httpClient *http.Client) (io.ReadWriteCloser, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	t := &tunnelConn{
+		httpClient: httpClient,
+		base:       strings.TrimSuffix(baseURL, "/"),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+
+	res, err := t.post(ctx, "/open/1", []byte{0})
+	if err != nil {
+		t.cancel()
+		return nil, err
+	}
+
+	t.id = strings.TrimSpace(string(res))
+	if t.id == "" {
+		t.cancel()
+		return nil, amf.Error("rtmpt: no session id")
+	}
+
+	go t.poll()
+
+	return t, nil
+}
+
+func (t *tunnelConn) post(ctx context.Context, path string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, RTMPT_REQUEST_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", t.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, amf.Error("rtmpt: %s", err)
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", RTMPT_CONTENT_TYPE)
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, amf.Error("rtmpt: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, amf.Error("rtmpt: %s responded with %s", path, resp.Status)
+	}
+
+	res, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, amf.Error("rtmpt: %s", err)
+	}
+
+	return res, nil
+}
+
+// poll sends pending writes, or idles when there are none, waiting the
+// delay asked for by the server unless more writes are made.
+func (t *tunnelConn) poll() {
+	delay := time.Duration(0)
+
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-t.wake:
+		case <-timer.C:
+		case <-t.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+
+		t.mu.Lock()
+		data := append([]byte(nil), t.out.Bytes()...)
+		t.out.Reset()
+		t.seq++
+		seq := t.seq
+		t.mu.Unlock()
+
+		path := "/send/"
+		if len(data) == 0 {
+			path, data = "/idle/", []byte{0}
+		}
+
+		res, err := t.post(t.ctx, path+t.id+"/"+strconv.Itoa(seq), data)
+		if err == nil && len(res) == 0 {
+			err = amf.Error("rtmpt: empty response")
+		}
+
+		t.mu.Lock()
+		if err != nil {
+			if t.err == nil {
+				t.err = err
+			}
+			t.cond.Broadcast()
+			t.mu.Unlock()
+			return
+		}
+
+		t.in.Write(res[1:])
+		t.cond.Broadcast()
+		t.mu.Unlock()
+
+		delay = time.Duration(res[0]) * 10 * time.Millisecond
+	}
+}
+
+func (t *tunnelConn) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.in.Len() == 0 && t.err == nil {
+		t.cond.Wait()
+	}
+
+	if t.in.Len() == 0 {
+		return 0, t.err
+	}
+
+	return t.in.Read(p)
+}
+
+func (t *tunnelConn) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	t.out.Write(p)
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+
+	return len(p), nil
+}
+
+// Close ends the session.
+func (t *tunnelConn) Close() error {
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		t.cancel()
+		return nil
+	}
+
+	t.err = io.EOF
+	t.cond.Broadcast()
+	t.seq++
+	seq := t.seq
+	t.mu.Unlock()
+
+	close(t.stop)
+	t.cancel()
+
+	_, err := t.post(context.Background(), "/close/"+t.id+"/"+strconv.Itoa(seq), []byte{0})
+
+	return err
+}
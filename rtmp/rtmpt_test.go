@@ -0,0 +1,198 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+func TestTunnel(t *testing.T) {
+	s := NewServer()
+	s.Handle("echo", func(req *Request) (interface{}, error) {
+		return req.Command.Args[0], nil
+	})
+
+	h := NewTunnelHandler(s)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, err := NewClient("rtmpt://" + strings.TrimPrefix(srv.URL, "http://") + "/live")
+	if err != nil {
+		t.Fatalf("new client: %s", err)
+	}
+	c.ObjectEncoding = amf.AMF3
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err = c.Connect(ctx); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+
+	for _, v := range []string{"one", "two"} {
+		result, err := c.Call(ctx, "echo", v)
+		if err != nil || result != v {
+			t.Errorf("expected %s, got %#v: %v", v, result, err)
+		}
+	}
+
+	h.mu.Lock()
+	sessions := len(h.sessions)
+	h.mu.Unlock()
+
+	if sessions != 1 {
+		t.Errorf("expected 1 session, got %d", sessions)
+	}
+
+	if err = c.Close(); err != nil {
+		t.Errorf("close: %s", err)
+	}
+
+	h.mu.Lock()
+	sessions = len(h.sessions)
+	h.mu.Unlock()
+
+	if sessions != 0 {
+		t.Errorf("expected closed session to be removed, got %d", sessions)
+	}
+}
+
+func TestTunnelTimeout(t *testing.T) {
+	h := NewTunnelHandler(NewServer())
+	h.Timeout = 10 * time.Millisecond
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	if _, err := DialTunnel(context.Background(), srv.URL, nil); err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	// the client polls more slowly as it idles, eventually past the timeout
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.Lock()
+		sessions := len(h.sessions)
+		h.mu.Unlock()
+
+		if sessions == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("idle session was not closed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func tunnelPost(t *testing.T, url string, body []byte) (int, string) {
+	resp, err := http.Post(url, RTMPT_CONTENT_TYPE, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	defer resp.Body.Close()
+
+	res, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(res)
+}
+
+func TestTunnelLimits(t *testing.T) {
+	h := NewTunnelHandler(NewServer())
+	h.MaxRequestSize = 16
+	h.MaxSessions = 1
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	code, id := tunnelPost(t, srv.URL+"/open/1", []byte{0})
+	if code != http.StatusOK {
+		t.Fatalf("open: %d", code)
+	}
+	id = strings.TrimSpace(id)
+
+	if code, _ = tunnelPost(t, srv.URL+"/open/1", []byte{0}); code != http.StatusServiceUnavailable {
+		t.Errorf("expected session beyond the limit to be refused, got %d", code)
+	}
+
+	if code, _ = tunnelPost(t, srv.URL+"/send/"+id+"/1", make([]byte, 17)); code != http.StatusBadRequest {
+		t.Errorf("expected large body to be refused, got %d", code)
+	}
+
+	if code, _ = tunnelPost(t, srv.URL+"/idle/"+id+"/1", []byte{0}); code != http.StatusOK {
+		t.Errorf("idle: %d", code)
+	}
+
+	// replayed and skipped sequence numbers
+	for _, seq := range []string{"1", "3", "x"} {
+		if code, _ = tunnelPost(t, srv.URL+"/idle/"+id+"/"+seq, []byte{0}); code != http.StatusBadRequest {
+			t.Errorf("expected sequence %s to be refused, got %d", seq, code)
+		}
+	}
+
+	if code, _ = tunnelPost(t, srv.URL+"/idle/"+id+"/2", []byte{0}); code != http.StatusOK {
+		t.Errorf("idle: %d", code)
+	}
+
+	if code, _ = tunnelPost(t, srv.URL+"/close/"+id+"/4", []byte{0}); code != http.StatusOK {
+		t.Errorf("close: %d", code)
+	}
+
+	if code, _ = tunnelPost(t, srv.URL+"/open/1", []byte{0}); code != http.StatusOK {
+		t.Errorf("expected session after close, got %d", code)
+	}
+}
+
+func TestTunnelCloseCancelsPoll(t *testing.T) {
+	// the server never answers polls
+	polled := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+
+		switch strings.Split(strings.Trim(r.URL.Path, "/"), "/")[0] {
+		case "open":
+			w.Write([]byte("id\n"))
+		case "idle", "send":
+			select {
+			case polled <- struct{}{}:
+			default:
+			}
+			<-r.Context().Done()
+		default:
+			w.Write([]byte{0})
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := DialTunnel(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	tc := conn.(*tunnelConn)
+
+	<-polled
+
+	done := make(chan struct{})
+	go func() {
+		tc.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close hung")
+	}
+
+	if tc.ctx.Err() == nil {
+		t.Errorf("expected requests to be canceled on close")
+	}
+}
@@ -0,0 +1,564 @@
+package rtmp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// Request is a command sent to a Server.
+type Request struct {
+	Conn     *ServerConn
+	StreamId uint32
+	Command  *Command
+}
+
+// ServerHandler serves a command. For calls, its result is sent back with
+// _result and its error with _error. For play and publish, which are
+// answered with onStatus on their stream, the result is ignored.
+type ServerHandler func(req *Request) (interface{}, error)
+
+// limits of a Server when its fields are zero
+const (
+	DEFAULT_HANDSHAKE_TIMEOUT = 10 * time.Second
+	DEFAULT_MAX_STREAMS       = 64
+)
+
+// Server accepts rtmp connections and dispatches the commands they send to
+// handlers. Connect, createStream and deleteStream are answered by the
+// server itself, consulting a handler registered for them if there is
+// one; play, publish and other calls need a handler. Handlers of a
+// connection are called in order, from the goroutine reading it.
+type Server struct {
+	// ChunkSize is the size of chunks sent to clients, announced once they
+	// connect. The default of 128 bytes is kept when it is zero.
+	ChunkSize uint32
+
+	// WindowAckSize is the acknowledgement window and peer bandwidth
+	// announced to clients, 2500000 bytes when it is zero.
+	WindowAckSize uint32
+
+	// HandshakeTimeout bounds the handshake of connections that can take a
+	// deadline, such as net.Conn. DEFAULT_HANDSHAKE_TIMEOUT is used when it
+	// is zero.
+	HandshakeTimeout time.Duration
+
+	// MaxStreams limits the streams a connection may have open at once,
+	// createStream failing beyond it. DEFAULT_MAX_STREAMS is used when it
+	// is zero.
+	MaxStreams int
+
+	// SharedObjects, if set, serves the remote shared objects used by
+	// connections.
+	SharedObjects *SharedObjectStore
+
+	// OnMessage, if set, receives the messages of connections other than
+	// commands, protocol control and served shared objects, e.g. audio,
+	// video and data.
+	OnMessage func(c *ServerConn, msg *Message)
+
+	mu        sync.Mutex
+	handlers  map[string]ServerHandler
+	listeners map[net.Listener]bool
+	conns     map[*ServerConn]bool
+	closed    bool
+
+	// connections still in their handshake
+	handshakes map[io.Closer]bool
+}
+
+// ServerConn is a connection accepted by a Server.
+type ServerConn struct {
+	// App is the application named in the connect command, and Properties
+	// the whole connect command object.
+	App        string
+	Properties amf.Object
+
+	server *Server
+	conn   *conn
+
+	mu         sync.Mutex
+	encoding   amf.Version
+	connected  bool
+	streams    map[uint32]bool
+	nextStream uint32
+}
+
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]ServerHandler)}
+}
+
+// Handle serves commands named name with h.
+func (s *Server) Handle(name string, h ServerHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[name] = h
+}
+
+func (s *Server) handler(name string) ServerHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handlers[name]
+}
+
+// ListenAndServe listens on tcp address addr and serves the connections
+// accepted on it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return amf.Error("rtmp: %s", err)
+	}
+
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted on l, until l fails or the server
+// is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return amf.Error("rtmp: server closed")
+	}
+
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return amf.Error("rtmp: server closed")
+			}
+
+			return amf.Error("rtmp: %s", err)
+		}
+
+		go s.ServeConn(nc)
+	}
+}
+
+// ServeConn performs the handshake on rwc and serves its commands until it
+// is closed.
+func (s *Server) ServeConn(rwc io.ReadWriteCloser) error {
+	defer rwc.Close()
+
+	if err := s.handshake(rwc); err != nil {
+		return err
+	}
+
+	c := &ServerConn{
+		server:     s,
+		conn:       newConn(rwc),
+		streams:    make(map[uint32]bool),
+		nextStream: 1,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return amf.Error("rtmp: server closed")
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[*ServerConn]bool)
+	}
+	s.conns[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+
+		if s.SharedObjects != nil {
+			s.SharedObjects.Disconnect(c)
+		}
+	}()
+
+	return c.serve()
+}
+
+// handshake performs the handshake on rwc, which Close can interrupt, with
+// a deadline if rwc takes one.
+func (s *Server) handshake(rwc io.ReadWriteCloser) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return amf.Error("rtmp: server closed")
+	}
+
+	if s.handshakes == nil {
+		s.handshakes = make(map[io.Closer]bool)
+	}
+	s.handshakes[rwc] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.handshakes, rwc)
+		s.mu.Unlock()
+	}()
+
+	dc, ok := rwc.(interface {
+		SetDeadline(t time.Time) error
+	})
+	if !ok {
+		return ServerHandshake(rwc)
+	}
+
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_HANDSHAKE_TIMEOUT
+	}
+
+	dc.SetDeadline(time.Now().Add(timeout))
+	if err := ServerHandshake(rwc); err != nil {
+		return err
+	}
+
+	return dc.SetDeadline(time.Time{})
+}
+
+// Close closes the listeners and connections of the server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	for l := range s.listeners {
+		l.Close()
+	}
+
+	for rwc := range s.handshakes {
+		rwc.Close()
+	}
+
+	for c := range s.conns {
+		c.conn.close()
+	}
+
+	return nil
+}
+
+func (c *ServerConn) serve() error {
+	d := amf.NewDecoder()
+
+	for {
+		msg, err := c.conn.readMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch msg.Type {
+		case MSG_COMMAND_AMF0, MSG_COMMAND_AMF3:
+			cmd, err := DecodeCommand(d, msg)
+			if err != nil {
+				return err
+			}
+
+			if err = c.dispatch(&Request{Conn: c, StreamId: msg.StreamId, Command: cmd}); err != nil {
+				return err
+			}
+
+		case MSG_SET_CHUNK_SIZE, MSG_ABORT, MSG_ACK, MSG_USER_CONTROL, MSG_WINDOW_ACK_SIZE, MSG_SET_PEER_BANDWIDTH:
+
+		case MSG_SHARED_OBJECT_AMF0, MSG_SHARED_OBJECT_AMF3:
+			if c.server.SharedObjects == nil {
+				break
+			}
+
+			if err = c.server.SharedObjects.Serve(c, msg); err != nil {
+				return err
+			}
+
+		default:
+			if c.server.OnMessage != nil {
+				c.server.OnMessage(c, msg)
+			}
+		}
+	}
+}
+
+func (c *ServerConn) dispatch(req *Request) error {
+	cmd := req.Command
+	h := c.server.handler(cmd.Name)
+
+	c.mu.Lock()
+	connected := c.connected
+	c.mu.Unlock()
+
+	if cmd.Name == "connect" {
+		if connected {
+			return c.reply(req, nil, amf.NewStatusError("NetConnection.Call.Failed", "already connected"))
+		}
+
+		return c.connect(req, h)
+	}
+
+	if !connected {
+		return c.reply(req, nil, amf.NewStatusError("NetConnection.Call.Failed", "not connected"))
+	}
+
+	switch cmd.Name {
+	case "createStream":
+		if h != nil {
+			if _, err := h(req); err != nil {
+				return c.reply(req, nil, err)
+			}
+		}
+
+		max := c.server.MaxStreams
+		if max <= 0 {
+			max = DEFAULT_MAX_STREAMS
+		}
+
+		c.mu.Lock()
+		if len(c.streams) >= max {
+			c.mu.Unlock()
+			return c.reply(req, nil, amf.NewStatusError("NetConnection.Call.Failed", "too many streams"))
+		}
+
+		id := c.nextStream
+		c.nextStream++
+		c.streams[id] = true
+		c.mu.Unlock()
+
+		return c.reply(req, float64(id), nil)
+
+	case "deleteStream", "closeStream":
+		var id float64
+		if len(cmd.Args) > 0 {
+			amf.Convert(cmd.Args[0], &id)
+		}
+		if cmd.Name == "closeStream" {
+			id = float64(req.StreamId)
+		}
+
+		c.mu.Lock()
+		delete(c.streams, uint32(id))
+		c.mu.Unlock()
+
+		if h != nil {
+			h(req)
+		}
+
+		return nil
+
+	case "play", "publish":
+		return c.stream(req, h)
+	}
+
+	if h == nil {
+		return c.reply(req, nil, amf.NewStatusError("NetConnection.Call.Failed", "method %s not found", cmd.Name))
+	}
+
+	result, err := h(req)
+
+	return c.reply(req, result, err)
+}
+
+// connect accepts or rejects a connection, then announces the window,
+// bandwidth and chunk size of the server and answers with the object
+// encoding asked for by the client.
+func (c *ServerConn) connect(req *Request, h ServerHandler) error {
+	var props struct {
+		App            string  `amf:"app"`
+		ObjectEncoding float64 `amf:"objectEncoding"`
+	}
+
+	if err := amf.Convert(req.Command.Object, &props); err != nil {
+		return c.reply(req, nil, amf.NewStatusError("NetConnection.Connect.Rejected", "invalid connect object: %s", err))
+	}
+
+	c.App = props.App
+	c.Properties, _ = req.Command.Object.(amf.Object)
+
+	encoding := amf.Version(amf.AMF0)
+	if props.ObjectEncoding == amf.AMF3 {
+		encoding = amf.AMF3
+	}
+
+	if h != nil {
+		if _, err := h(req); err != nil {
+			status := statusOf(err)
+			if _, ok := err.(*amf.StatusError); !ok {
+				status.Code = "NetConnection.Connect.Rejected"
+			}
+
+			if err = c.reply(req, nil, status); err != nil {
+				return err
+			}
+
+			return amf.Error("rtmp: connect to %s rejected: %s", c.App, status.Description)
+		}
+	}
+
+	window := c.server.WindowAckSize
+	if window == 0 {
+		window = 2500000
+	}
+
+	msgs := []*Message{
+		NewWindowAckSize(window),
+		NewSetPeerBandwidth(window, BANDWIDTH_LIMIT_DYNAMIC),
+		NewUserControl(USER_CONTROL_STREAM_BEGIN, 0),
+	}
+
+	for _, msg := range msgs {
+		if err := c.conn.w.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	if c.server.ChunkSize > 0 {
+		if err := c.conn.w.SetChunkSize(c.server.ChunkSize); err != nil {
+			return err
+		}
+	}
+
+	// the result is sent in amf0 like the connect command itself, and the
+	// negotiated encoding is used from then on
+	res := &Command{
+		Name:          "_result",
+		TransactionId: req.Command.TransactionId,
+		Object: amf.Object{
+			"fmsVer":       "FMS/3,5,7,7009",
+			"capabilities": float64(31),
+			"mode":         float64(1),
+		},
+		Args: []interface{}{amf.Object{
+			"level":          "status",
+			"code":           "NetConnection.Connect.Success",
+			"description":    "Connection succeeded.",
+			"objectEncoding": float64(encoding),
+		}},
+	}
+
+	if err := c.send(amf.AMF0, 0, res); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.encoding = encoding
+	c.connected = true
+	c.mu.Unlock()
+
+	return nil
+}
+
+// stream serves play and publish, answering with onStatus on the stream.
+func (c *ServerConn) stream(req *Request, h ServerHandler) error {
+	c.mu.Lock()
+	exists := c.streams[req.StreamId]
+	c.mu.Unlock()
+
+	start, failed := "NetStream.Play.Start", "NetStream.Play.Failed"
+	if req.Command.Name == "publish" {
+		start, failed = "NetStream.Publish.Start", "NetStream.Publish.BadName"
+	}
+
+	var err error
+	switch {
+	case !exists:
+		err = amf.Error("no stream %d", req.StreamId)
+	case h == nil:
+		err = amf.Error("%s is not supported", req.Command.Name)
+	default:
+		_, err = h(req)
+	}
+
+	if err != nil {
+		status := statusOf(err)
+		if _, ok := err.(*amf.StatusError); !ok {
+			status.Code = failed
+		}
+
+		return c.OnStatus(req.StreamId, status)
+	}
+
+	if req.Command.Name == "play" {
+		if err = c.conn.w.WriteMessage(NewUserControl(USER_CONTROL_STREAM_BEGIN, req.StreamId)); err != nil {
+			return err
+		}
+	}
+
+	status := &amf.StatusError{Level: "status", Code: start, Description: req.Command.Name + " started"}
+
+	return c.OnStatus(req.StreamId, status)
+}
+
+// reply answers a call with _result or _error, unless it is a
+// notification.
+func (c *ServerConn) reply(req *Request, result interface{}, err error) error {
+	if req.Command.TransactionId == 0 {
+		return nil
+	}
+
+	res := &Command{Name: "_result", TransactionId: req.Command.TransactionId, Args: []interface{}{result}}
+	if err != nil {
+		res.Name = "_error"
+		res.Args = []interface{}{statusOf(err)}
+	}
+
+	return c.send(c.version(), req.StreamId, res)
+}
+
+func (c *ServerConn) version() amf.Version {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.encoding
+}
+
+func (c *ServerConn) send(ver amf.Version, streamId uint32, cmd *Command) error {
+	msg, err := EncodeCommand(nil, ver, streamId, cmd)
+	if err != nil {
+		return err
+	}
+
+	return c.conn.w.WriteMessage(msg)
+}
+
+// OnStatus sends an onStatus notification on stream streamId.
+func (c *ServerConn) OnStatus(streamId uint32, status *amf.StatusError) error {
+	return c.send(c.version(), streamId, &Command{Name: "onStatus", Args: []interface{}{status}})
+}
+
+// Notify calls method name of the client without expecting a result.
+func (c *ServerConn) Notify(name string, args ...interface{}) error {
+	return c.send(c.version(), 0, &Command{Name: name, Args: args})
+}
+
+// WriteMessage sends msg to the client, e.g. audio or video for a stream
+// it plays.
+func (c *ServerConn) WriteMessage(msg *Message) error {
+	return c.conn.w.WriteMessage(msg)
+}
+
+// Close closes the connection.
+func (c *ServerConn) Close() error {
+	return c.conn.close()
+}
@@ -0,0 +1,236 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	s.ChunkSize = 4096
+
+	s.Handle("connect", func(req *Request) (interface{}, error) {
+		if req.Conn.App != "live" {
+			return nil, errors.New("unknown app")
+		}
+		return nil, nil
+	})
+
+	s.Handle("echo", func(req *Request) (interface{}, error) {
+		return req.Command.Args[0], nil
+	})
+
+	s.Handle("publish", func(req *Request) (interface{}, error) {
+		if req.Command.Args[0] != "stream" {
+			return nil, errors.New("bad name")
+		}
+		return nil, nil
+	})
+
+	media := make(chan *Message, 1)
+	s.OnMessage = func(c *ServerConn, msg *Message) {
+		media <- msg
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, encoding := range []amf.Version{amf.AMF0, amf.AMF3} {
+		c, err := NewClient("rtmp://" + l.Addr().String() + "/live")
+		if err != nil {
+			t.Fatalf("new client: %s", err)
+		}
+		c.ObjectEncoding = encoding
+
+		statuses := make(chan string, 2)
+		c.Handle("onStatus", func(cmd *Command) (interface{}, error) {
+			var status amf.StatusError
+			amf.Convert(cmd.Args[0], &status)
+			statuses <- status.Code
+			return nil, nil
+		})
+
+		if _, err = c.Connect(ctx); err != nil {
+			t.Fatalf("connect: %s", err)
+		}
+
+		if c.ObjectEncoding != encoding {
+			t.Errorf("expected encoding %d, got %d", encoding, c.ObjectEncoding)
+		}
+
+		result, err := c.Call(ctx, "echo", amf.Object{"a": "b"})
+		if obj, ok := result.(amf.Object); err != nil || !ok || obj["a"] != "b" {
+			t.Errorf("unexpected echo %#v: %v", result, err)
+		}
+
+		_, err = c.Call(ctx, "missing")
+		if status, ok := err.(*amf.StatusError); !ok || status.Code != "NetConnection.Call.Failed" {
+			t.Errorf("expected call failure, got %#v", err)
+		}
+
+		id, err := c.Call(ctx, "createStream", nil)
+		if err != nil || id != float64(1) {
+			t.Fatalf("expected stream 1, got %#v: %v", id, err)
+		}
+
+		for _, name := range []string{"stream", "other"} {
+			msg, _ := EncodeCommand(nil, encoding, 1, &Command{Name: "publish", Args: []interface{}{name, "live"}})
+			c.conn.w.WriteMessage(msg)
+		}
+
+		for _, code := range []string{"NetStream.Publish.Start", "NetStream.Publish.BadName"} {
+			select {
+			case got := <-statuses:
+				if got != code {
+					t.Errorf("expected %s, got %s", code, got)
+				}
+			case <-ctx.Done():
+				t.Fatalf("no onStatus for publish")
+			}
+		}
+
+		c.conn.w.WriteMessage(&Message{ChunkStreamId: 4, Type: MSG_AUDIO, StreamId: 1, Payload: []byte{0xaf}})
+
+		select {
+		case msg := <-media:
+			if msg.Type != MSG_AUDIO || msg.StreamId != 1 {
+				t.Errorf("unexpected media message %+v", msg)
+			}
+		case <-ctx.Done():
+			t.Fatalf("no media message")
+		}
+
+		c.Close()
+	}
+}
+
+func TestServerConnectRejected(t *testing.T) {
+	s := NewServer()
+	s.Handle("connect", func(req *Request) (interface{}, error) {
+		return nil, errors.New("go away")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := NewClient("rtmp://" + l.Addr().String() + "/live")
+	if err != nil {
+		t.Fatalf("new client: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = c.Connect(ctx)
+	if status, ok := err.(*amf.StatusError); !ok || status.Code != "NetConnection.Connect.Rejected" {
+		t.Errorf("expected rejection, got %#v", err)
+	}
+}
+
+func TestServerHandshake(t *testing.T) {
+	s := NewServer()
+	s.HandshakeTimeout = 50 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	// a peer that stalls in the handshake is dropped once it times out
+	stalled, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer stalled.Close()
+
+	stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = stalled.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected stalled handshake to be closed, got %v", err)
+	}
+
+	// and one still in its handshake is closed with the server
+	s = NewServer()
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go s.Serve(l)
+
+	pending, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer pending.Close()
+
+	for {
+		s.mu.Lock()
+		n := len(s.handshakes)
+		s.mu.Unlock()
+
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Close()
+
+	pending.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = pending.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected handshake to be closed with the server, got %v", err)
+	}
+}
+
+func TestServerMaxStreams(t *testing.T) {
+	s := NewServer()
+	s.MaxStreams = 1
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := NewClient("rtmp://" + l.Addr().String() + "/live")
+	if err != nil {
+		t.Fatalf("new client: %s", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err = c.Connect(ctx); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+
+	if id, err := c.Call(ctx, "createStream", nil); err != nil || id != float64(1) {
+		t.Fatalf("expected stream 1, got %#v: %v", id, err)
+	}
+
+	_, err = c.Call(ctx, "createStream", nil)
+	if status, ok := err.(*amf.StatusError); !ok || status.Code != "NetConnection.Call.Failed" {
+		t.Errorf("expected stream beyond the limit to fail, got %#v", err)
+	}
+}
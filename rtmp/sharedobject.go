@@ -0,0 +1,646 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	amf "github.com/elobuff/goamf"
+)
+
+// shared object event types
+const (
+	SO_USE            = 1
+	SO_RELEASE        = 2
+	SO_REQUEST_CHANGE = 3
+	SO_CHANGE         = 4
+	SO_SUCCESS        = 5
+	SO_SEND_MESSAGE   = 6
+	SO_STATUS         = 7
+	SO_CLEAR          = 8
+	SO_REMOVE         = 9
+	SO_REQUEST_REMOVE = 10
+	SO_USE_SUCCESS    = 11
+)
+
+// SharedObjectMessage is a batch of events on a remote shared object.
+type SharedObjectMessage struct {
+	Name       string
+	Version    uint32
+	Persistent bool
+	Events     []SharedObjectEvent
+}
+
+// SharedObjectEvent is an event of a shared object message. Which fields
+// are used depends on its type:
+// - use, release, clear and use success: none
+// - request change and change: Key and Value
+// - success, remove and request remove: Key
+// - send message: Method and Args
+// - status: Code and Level
+type SharedObjectEvent struct {
+	Type   uint8
+	Key    string
+	Value  interface{}
+	Method string
+	Args   []interface{}
+	Code   string
+	Level  string
+}
+
+// DecodeSharedObject decodes a shared object message with d, which may be
+// nil. Values of amf3 messages (type 16) are amf3, those of amf0 messages
+// amf0.
+//
+// format:
+// - 1 zero byte for amf3 messages
+// - 2 byte big endian length of the name followed by the name
+// - 4 byte big endian version
+// - 4 byte big endian flags, 2 if persistent
+// - 4 reserved bytes
+// - events:
+//   - 1 byte type
+//   - 4 byte big endian length of the event data followed by the data
+//
+// format (event data):
+// - keys, codes and levels: 2 byte big endian length followed by the string
+// - change and request change: key followed by the encoded value
+// - send message: encoded method name followed by the encoded arguments
+// - status: code followed by level
+func DecodeSharedObject(d *amf.Decoder, m *Message) (*SharedObjectMessage, error) {
+	ver := amf.Version(amf.AMF0)
+	switch m.Type {
+	case MSG_SHARED_OBJECT_AMF0:
+	case MSG_SHARED_OBJECT_AMF3:
+		ver = amf.AMF3
+	default:
+		return nil, amf.Error("rtmp: message type %d is not a shared object", m.Type)
+	}
+
+	if d == nil {
+		d = amf.NewDecoder()
+	}
+	d.Reset()
+
+	payload := m.Payload
+	if ver == amf.AMF3 && len(payload) > 0 && payload[0] == 0 {
+		payload = payload[1:]
+	}
+
+	r := bytes.NewReader(payload)
+	so := new(SharedObjectMessage)
+
+	var err error
+	if so.Name, err = readShortString(r); err != nil {
+		return nil, amf.Error("rtmp: unable to read shared object name: %s", err)
+	}
+
+	header := make([]byte, 12)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, amf.Error("rtmp: unable to read shared object header: %s", err)
+	}
+
+	so.Version = binary.BigEndian.Uint32(header[0:4])
+	so.Persistent = binary.BigEndian.Uint32(header[4:8])&2 != 0
+
+	for r.Len() > 0 {
+		eh := make([]byte, 5)
+		if _, err = io.ReadFull(r, eh); err != nil {
+			return nil, amf.Error("rtmp: unable to read shared object event: %s", err)
+		}
+
+		length := binary.BigEndian.Uint32(eh[1:5])
+		if int64(length) > int64(r.Len()) {
+			return nil, amf.Error("rtmp: shared object event of %d bytes exceeds the %d left in the message", length, r.Len())
+		}
+
+		data := make([]byte, length)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, amf.Error("rtmp: unable to read shared object event data: %s", err)
+		}
+
+		ev, err := decodeSharedObjectEvent(d, ver, eh[0], bytes.NewReader(data))
+		if err != nil {
+			return nil, amf.Error("rtmp: unable to decode shared object %s event %d: %s", so.Name, eh[0], err)
+		}
+
+		so.Events = append(so.Events, ev)
+	}
+
+	return so, nil
+}
+
+func decodeSharedObjectEvent(d *amf.Decoder, ver amf.Version, typeId uint8, r *bytes.Reader) (ev SharedObjectEvent, err error) {
+	ev.Type = typeId
+
+	switch typeId {
+	case SO_REQUEST_CHANGE, SO_CHANGE:
+		if ev.Key, err = readShortString(r); err != nil {
+			return
+		}
+		ev.Value, err = d.Decode(r, ver)
+
+	case SO_SUCCESS, SO_REMOVE, SO_REQUEST_REMOVE:
+		ev.Key, err = readShortString(r)
+
+	case SO_SEND_MESSAGE:
+		var method interface{}
+		if method, err = d.Decode(r, ver); err != nil {
+			return
+		}
+
+		var ok bool
+		if ev.Method, ok = method.(string); !ok {
+			return ev, amf.Error("expected method name, got %T", method)
+		}
+
+		for r.Len() > 0 {
+			var arg interface{}
+			if arg, err = d.Decode(r, ver); err != nil {
+				return
+			}
+			ev.Args = append(ev.Args, arg)
+		}
+
+	case SO_STATUS:
+		if ev.Code, err = readShortString(r); err != nil {
+			return
+		}
+		ev.Level, err = readShortString(r)
+	}
+
+	return
+}
+
+// EncodeSharedObject encodes so with e, which may be nil, as a shared
+// object message of version ver.
+func EncodeSharedObject(e *amf.Encoder, ver amf.Version, so *SharedObjectMessage) (*Message, error) {
+	if e == nil {
+		e = new(amf.Encoder)
+	}
+	e.Reset()
+
+	typeId := uint8(MSG_SHARED_OBJECT_AMF0)
+	buf := new(bytes.Buffer)
+
+	if ver == amf.AMF3 {
+		typeId = MSG_SHARED_OBJECT_AMF3
+		buf.WriteByte(0)
+	}
+
+	if err := writeShortString(buf, so.Name); err != nil {
+		return nil, amf.Error("rtmp: unable to write shared object name: %s", err)
+	}
+
+	var flags uint32
+	if so.Persistent {
+		flags = 2
+	}
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[0:4], so.Version)
+	binary.BigEndian.PutUint32(header[4:8], flags)
+	buf.Write(header)
+
+	for _, ev := range so.Events {
+		data := new(bytes.Buffer)
+		if err := encodeSharedObjectEvent(e, ver, data, ev); err != nil {
+			return nil, amf.Error("rtmp: unable to encode shared object %s event %d: %s", so.Name, ev.Type, err)
+		}
+
+		eh := make([]byte, 5)
+		eh[0] = ev.Type
+		binary.BigEndian.PutUint32(eh[1:5], uint32(data.Len()))
+
+		buf.Write(eh)
+		buf.Write(data.Bytes())
+	}
+
+	return &Message{
+		ChunkStreamId: CHUNK_STREAM_COMMAND,
+		Type:          typeId,
+		Payload:       buf.Bytes(),
+	}, nil
+}
+
+func encodeSharedObjectEvent(e *amf.Encoder, ver amf.Version, w *bytes.Buffer, ev SharedObjectEvent) (err error) {
+	switch ev.Type {
+	case SO_REQUEST_CHANGE, SO_CHANGE:
+		if err = writeShortString(w, ev.Key); err != nil {
+			return
+		}
+		_, err = e.Encode(w, ev.Value, ver)
+
+	case SO_SUCCESS, SO_REMOVE, SO_REQUEST_REMOVE:
+		err = writeShortString(w, ev.Key)
+
+	case SO_SEND_MESSAGE:
+		if _, err = e.Encode(w, ev.Method, ver); err != nil {
+			return
+		}
+
+		for _, arg := range ev.Args {
+			if _, err = e.Encode(w, arg, ver); err != nil {
+				return
+			}
+		}
+
+	case SO_STATUS:
+		if err = writeShortString(w, ev.Code); err != nil {
+			return
+		}
+		err = writeShortString(w, ev.Level)
+	}
+
+	return
+}
+
+func readShortString(r io.Reader) (string, error) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	s := make([]byte, binary.BigEndian.Uint16(b))
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+
+	return string(s), nil
+}
+
+func writeShortString(w io.Writer, s string) error {
+	if len(s) > 0xffff {
+		return amf.Error("string of %d bytes too long", len(s))
+	}
+
+	b := make([]byte, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	copy(b[2:], s)
+
+	_, err := w.Write(b)
+
+	return err
+}
+
+// MessageWriter sends messages, like a Writer or a ServerConn.
+type MessageWriter interface {
+	WriteMessage(msg *Message) error
+}
+
+// SharedObjectStore holds remote shared objects in memory for a server.
+// Each change to the slots of a shared object increments its version and
+// is sent to the clients using it, in the encoding they use it with.
+type SharedObjectStore struct {
+	mu      sync.Mutex
+	objects map[string]*sharedObject
+}
+
+type sharedObject struct {
+	name       string
+	persistent bool
+	version    uint32
+	slots      map[string]interface{}
+
+	// encoding of each client using the shared object
+	clients map[MessageWriter]amf.Version
+
+	// held from building the messages of a change until they are written,
+	// so that clients get changes in the order of their versions
+	send sync.Mutex
+}
+
+func NewSharedObjectStore() *SharedObjectStore {
+	return &SharedObjectStore{objects: make(map[string]*sharedObject)}
+}
+
+func (s *SharedObjectStore) object(name string) *sharedObject {
+	so := s.objects[name]
+	if so == nil {
+		so = &sharedObject{
+			name:    name,
+			slots:   make(map[string]interface{}),
+			clients: make(map[MessageWriter]amf.Version),
+		}
+		s.objects[name] = so
+	}
+
+	return so
+}
+
+// lockObject returns shared object name with its send lock held and the
+// store locked, creating the object if create is set. It returns nil, with
+// nothing locked, if there is no such object.
+func (s *SharedObjectStore) lockObject(name string, create bool) *sharedObject {
+	for {
+		s.mu.Lock()
+		so := s.objects[name]
+		if so == nil && create {
+			so = s.object(name)
+		}
+		s.mu.Unlock()
+
+		if so == nil {
+			return nil
+		}
+
+		// the send lock is taken without the store locked, so that waiting
+		// on a slow client holds up only the users of the same object
+		so.send.Lock()
+		s.mu.Lock()
+
+		if s.objects[name] == so {
+			return so
+		}
+
+		// released in the meantime
+		s.mu.Unlock()
+		so.send.Unlock()
+	}
+}
+
+// Serve applies the events of a shared object message sent by client w,
+// and sends the resulting events to the clients concerned. Changes and
+// messages from clients that do not use the shared object are ignored.
+// Only errors sending to w are returned; other clients that cannot be sent
+// to are disconnected.
+func (s *SharedObjectStore) Serve(w MessageWriter, msg *Message) error {
+	m, err := DecodeSharedObject(nil, msg)
+	if err != nil {
+		return err
+	}
+
+	ver := amf.Version(amf.AMF0)
+	if msg.Type == MSG_SHARED_OBJECT_AMF3 {
+		ver = amf.AMF3
+	}
+
+	// only clients starting to use a shared object may create it
+	create := false
+	for _, ev := range m.Events {
+		create = create || ev.Type == SO_USE
+	}
+
+	so := s.lockObject(m.Name, create)
+	if so == nil {
+		return nil
+	}
+	defer so.send.Unlock()
+
+	out := newSharedObjectOutbox()
+
+	for _, ev := range m.Events {
+		switch ev.Type {
+		case SO_USE:
+			so.clients[w] = ver
+			so.persistent = so.persistent || m.Persistent
+
+			// a new client gets the whole shared object
+			out.add(w, SharedObjectEvent{Type: SO_USE_SUCCESS})
+			out.add(w, SharedObjectEvent{Type: SO_CLEAR})
+			for _, key := range so.keys() {
+				out.add(w, SharedObjectEvent{Type: SO_CHANGE, Key: key, Value: so.slots[key]})
+			}
+
+		case SO_RELEASE:
+			delete(so.clients, w)
+
+		case SO_REQUEST_CHANGE:
+			if _, ok := so.clients[w]; !ok {
+				continue
+			}
+
+			so.version++
+			so.slots[ev.Key] = ev.Value
+
+			for c := range so.clients {
+				if c == w {
+					out.add(c, SharedObjectEvent{Type: SO_SUCCESS, Key: ev.Key})
+				} else {
+					out.add(c, SharedObjectEvent{Type: SO_CHANGE, Key: ev.Key, Value: ev.Value})
+				}
+			}
+
+		case SO_REQUEST_REMOVE:
+			if _, ok := so.clients[w]; !ok {
+				continue
+			}
+
+			if _, ok := so.slots[ev.Key]; !ok {
+				continue
+			}
+
+			so.version++
+			delete(so.slots, ev.Key)
+
+			for c := range so.clients {
+				out.add(c, SharedObjectEvent{Type: SO_REMOVE, Key: ev.Key})
+			}
+
+		case SO_SEND_MESSAGE:
+			if _, ok := so.clients[w]; !ok {
+				continue
+			}
+
+			// messages are broadcast to every client, the sender included
+			for c := range so.clients {
+				out.add(c, ev)
+			}
+		}
+	}
+
+	s.release(so)
+
+	msgs, err := out.messages(so)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return s.deliver(w, msgs)
+}
+
+// Set changes slot key of shared object name, creating it if needed.
+// Clients that cannot be sent the change are disconnected.
+func (s *SharedObjectStore) Set(name, key string, value interface{}) error {
+	so := s.lockObject(name, true)
+	defer so.send.Unlock()
+
+	so.version++
+	so.slots[key] = value
+
+	out := newSharedObjectOutbox()
+	for c := range so.clients {
+		out.add(c, SharedObjectEvent{Type: SO_CHANGE, Key: key, Value: value})
+	}
+
+	msgs, err := out.messages(so)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return s.deliver(nil, msgs)
+}
+
+// Get returns slot key of shared object name.
+func (s *SharedObjectStore) Get(name, key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	so := s.objects[name]
+	if so == nil {
+		return nil, false
+	}
+
+	v, ok := so.slots[key]
+
+	return v, ok
+}
+
+// Version returns the version of shared object name.
+func (s *SharedObjectStore) Version(name string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if so := s.objects[name]; so != nil {
+		return so.version
+	}
+
+	return 0
+}
+
+// Send calls method on the clients of shared object name. Clients that
+// cannot be sent the call are disconnected.
+func (s *SharedObjectStore) Send(name, method string, args ...interface{}) error {
+	so := s.lockObject(name, false)
+	if so == nil {
+		return nil
+	}
+	defer so.send.Unlock()
+
+	out := newSharedObjectOutbox()
+	for c := range so.clients {
+		out.add(c, SharedObjectEvent{Type: SO_SEND_MESSAGE, Method: method, Args: args})
+	}
+
+	msgs, err := out.messages(so)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return s.deliver(nil, msgs)
+}
+
+// deliver writes messages outside the lock of the store, so that a slow
+// client holds up only the users of the same shared object, whose send
+// lock is held. It returns the error writing to sender, and disconnects
+// the other clients it fails to write to.
+func (s *SharedObjectStore) deliver(sender MessageWriter, msgs []sharedObjectDelivery) error {
+	var err error
+
+	for _, d := range msgs {
+		e := d.client.WriteMessage(d.msg)
+		if e == nil {
+			continue
+		}
+
+		if d.client == sender {
+			err = e
+			continue
+		}
+
+		s.Disconnect(d.client)
+		if c, ok := d.client.(io.Closer); ok {
+			c.Close()
+		}
+	}
+
+	return err
+}
+
+// Disconnect releases the shared objects used by client w.
+func (s *SharedObjectStore) Disconnect(w MessageWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, so := range s.objects {
+		delete(so.clients, w)
+		s.release(so)
+	}
+}
+
+// release drops a shared object that is no longer used, unless it is
+// persistent or holds data set by the server.
+func (s *SharedObjectStore) release(so *sharedObject) {
+	if len(so.clients) == 0 && !so.persistent && len(so.slots) == 0 {
+		delete(s.objects, so.name)
+	}
+}
+
+func (so *sharedObject) keys() []string {
+	keys := make([]string, 0, len(so.slots))
+	for k := range so.slots {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sharedObjectOutbox collects the events for each client, so that each gets
+// a single message.
+type sharedObjectOutbox struct {
+	clients []MessageWriter
+	events  map[MessageWriter][]SharedObjectEvent
+}
+
+func newSharedObjectOutbox() *sharedObjectOutbox {
+	return &sharedObjectOutbox{events: make(map[MessageWriter][]SharedObjectEvent)}
+}
+
+func (o *sharedObjectOutbox) add(c MessageWriter, ev SharedObjectEvent) {
+	if _, ok := o.events[c]; !ok {
+		o.clients = append(o.clients, c)
+	}
+
+	o.events[c] = append(o.events[c], ev)
+}
+
+type sharedObjectDelivery struct {
+	client MessageWriter
+	msg    *Message
+}
+
+// messages encodes the events of each client still using so, in the
+// encoding it uses so with.
+func (o *sharedObjectOutbox) messages(so *sharedObject) ([]sharedObjectDelivery, error) {
+	msgs := make([]sharedObjectDelivery, 0, len(o.clients))
+
+	for _, c := range o.clients {
+		ver, ok := so.clients[c]
+		if !ok {
+			continue
+		}
+
+		msg, err := EncodeSharedObject(nil, ver, &SharedObjectMessage{
+			Name:       so.name,
+			Version:    so.version,
+			Persistent: so.persistent,
+			Events:     o.events[c],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, sharedObjectDelivery{c, msg})
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,329 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	amf "github.com/elobuff/goamf"
+)
+
+func TestSharedObjectRoundTrip(t *testing.T) {
+	so := &SharedObjectMessage{
+		Name:       "chat",
+		Version:    7,
+		Persistent: true,
+		Events: []SharedObjectEvent{
+			{Type: SO_USE},
+			{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "hello"},
+			{Type: SO_CHANGE, Key: "users", Value: amf.Object{"alfie": float64(1)}},
+			{Type: SO_SUCCESS, Key: "topic"},
+			{Type: SO_SEND_MESSAGE, Method: "say", Args: []interface{}{"hi", float64(2)}},
+			{Type: SO_STATUS, Code: "SharedObject.NoWriteAccess", Level: "error"},
+			{Type: SO_CLEAR},
+			{Type: SO_REMOVE, Key: "topic"},
+			{Type: SO_REQUEST_REMOVE, Key: "users"},
+			{Type: SO_USE_SUCCESS},
+		},
+	}
+
+	for _, ver := range []amf.Version{amf.AMF0, amf.AMF3} {
+		msg, err := EncodeSharedObject(nil, ver, so)
+		if err != nil {
+			t.Fatalf("encode: %s", err)
+		}
+
+		decoded, err := DecodeSharedObject(nil, msg)
+		if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+
+		if !reflect.DeepEqual(decoded, so) {
+			t.Errorf("version %d: expected %#v, got %#v", ver, so, decoded)
+		}
+	}
+}
+
+func TestEncodeSharedObject(t *testing.T) {
+	msg, err := EncodeSharedObject(nil, amf.AMF0, &SharedObjectMessage{
+		Name:    "so",
+		Version: 1,
+		Events:  []SharedObjectEvent{{Type: SO_CHANGE, Key: "a", Value: true}},
+	})
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	expected := []byte{
+		0x00, 0x02, 's', 'o',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		SO_CHANGE, 0x00, 0x00, 0x00, 0x05,
+		0x00, 0x01, 'a', amf.AMF0_BOOLEAN_MARKER, 0x01,
+	}
+
+	if msg.Type != MSG_SHARED_OBJECT_AMF0 || !bytes.Equal(msg.Payload, expected) {
+		t.Errorf("expected % x, got % x", expected, msg.Payload)
+	}
+}
+
+func TestDecodeSharedObjectEventLength(t *testing.T) {
+	payload := []byte{
+		0x00, 0x02, 's', 'o',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		SO_CHANGE, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x01, 'a', amf.AMF0_BOOLEAN_MARKER, 0x01,
+	}
+
+	msg := &Message{Type: MSG_SHARED_OBJECT_AMF0, Payload: payload}
+	if so, err := DecodeSharedObject(nil, msg); err == nil {
+		t.Errorf("expected error for event longer than the message, got %#v", so)
+	}
+}
+
+type soRecorder struct {
+	msgs []*SharedObjectMessage
+}
+
+func (r *soRecorder) WriteMessage(msg *Message) error {
+	so, err := DecodeSharedObject(nil, msg)
+	if err != nil {
+		return err
+	}
+
+	r.msgs = append(r.msgs, so)
+	return nil
+}
+
+func (r *soRecorder) last() *SharedObjectMessage {
+	if len(r.msgs) == 0 {
+		return nil
+	}
+
+	return r.msgs[len(r.msgs)-1]
+}
+
+func soRequest(t *testing.T, s *SharedObjectStore, w MessageWriter, ver amf.Version, events ...SharedObjectEvent) {
+	msg, err := EncodeSharedObject(nil, ver, &SharedObjectMessage{Name: "chat", Events: events})
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	if err = s.Serve(w, msg); err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+}
+
+func TestSharedObjectStore(t *testing.T) {
+	s := NewSharedObjectStore()
+	a, b := new(soRecorder), new(soRecorder)
+
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_USE})
+	if m := a.last(); m == nil || len(m.Events) != 2 || m.Events[0].Type != SO_USE_SUCCESS || m.Events[1].Type != SO_CLEAR {
+		t.Fatalf("unexpected use reply %#v", m)
+	}
+
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "hello"})
+	if m := a.last(); m.Version != 1 || len(m.Events) != 1 || m.Events[0].Type != SO_SUCCESS || m.Events[0].Key != "topic" {
+		t.Errorf("unexpected change reply %#v", m)
+	}
+
+	// a new client gets the current slots
+	soRequest(t, s, b, amf.AMF3, SharedObjectEvent{Type: SO_USE})
+	m := b.last()
+	if len(m.Events) != 3 || m.Events[2].Type != SO_CHANGE || m.Events[2].Key != "topic" || m.Events[2].Value != "hello" {
+		t.Errorf("unexpected use reply %#v", m)
+	}
+
+	// changes by one client reach the others
+	soRequest(t, s, b, amf.AMF3, SharedObjectEvent{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "bye"})
+	if m = a.last(); m.Version != 2 || m.Events[0].Type != SO_CHANGE || m.Events[0].Value != "bye" {
+		t.Errorf("unexpected change %#v", m)
+	}
+	if m = b.last(); m.Events[0].Type != SO_SUCCESS {
+		t.Errorf("unexpected change reply %#v", m)
+	}
+
+	// messages are sent to everyone
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_SEND_MESSAGE, Method: "say", Args: []interface{}{"hi"}})
+	for _, r := range []*soRecorder{a, b} {
+		if m = r.last(); m.Events[0].Type != SO_SEND_MESSAGE || m.Events[0].Method != "say" || m.Events[0].Args[0] != "hi" {
+			t.Errorf("unexpected message %#v", m)
+		}
+	}
+
+	if err := s.Set("chat", "count", 3); err != nil {
+		t.Fatalf("set: %s", err)
+	}
+	if v, ok := s.Get("chat", "count"); !ok || v != 3 {
+		t.Errorf("unexpected slot %#v", v)
+	}
+
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_REQUEST_REMOVE, Key: "count"})
+	for _, r := range []*soRecorder{a, b} {
+		if m = r.last(); m.Version != 4 || m.Events[0].Type != SO_REMOVE || m.Events[0].Key != "count" {
+			t.Errorf("unexpected remove %#v", m)
+		}
+	}
+
+	// released clients get nothing more
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_RELEASE})
+	s.Disconnect(b)
+
+	count := len(a.msgs) + len(b.msgs)
+	s.Set("chat", "topic", "anyone?")
+	if len(a.msgs)+len(b.msgs) != count {
+		t.Errorf("released clients got messages")
+	}
+
+	if s.Version("chat") != 5 {
+		t.Errorf("expected version 5, got %d", s.Version("chat"))
+	}
+}
+
+// soBlocker is a client whose writes fail, or wait for release if set,
+// telling writing that they do.
+type soBlocker struct {
+	writing chan struct{}
+	release chan struct{}
+	closed  bool
+}
+
+func (b *soBlocker) WriteMessage(msg *Message) error {
+	if b.release == nil {
+		return errors.New("broken pipe")
+	}
+
+	if b.writing != nil {
+		b.writing <- struct{}{}
+	}
+	<-b.release
+
+	return nil
+}
+
+func (b *soBlocker) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSharedObjectStoreFailingClient(t *testing.T) {
+	s := NewSharedObjectStore()
+	a, broken := new(soRecorder), &soBlocker{release: make(chan struct{})}
+	close(broken.release)
+
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_USE})
+	soRequest(t, s, broken, amf.AMF0, SharedObjectEvent{Type: SO_USE})
+	broken.release = nil
+
+	// the failure of another client is not the sender's
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "hello"})
+
+	if !broken.closed {
+		t.Errorf("expected failing client to be closed")
+	}
+
+	count := len(a.msgs)
+	if err := s.Send("chat", "say", "hi"); err != nil {
+		t.Errorf("send: %s", err)
+	}
+	if len(a.msgs) != count+1 {
+		t.Errorf("expected message for remaining client")
+	}
+
+	// errors sending to the sender are its own
+	msg, _ := EncodeSharedObject(nil, amf.AMF0, &SharedObjectMessage{Name: "chat", Events: []SharedObjectEvent{{Type: SO_USE}}})
+	if err := s.Serve(broken, msg); err == nil {
+		t.Errorf("expected error sending to the sender")
+	}
+}
+
+func TestSharedObjectStoreSlowClient(t *testing.T) {
+	s := NewSharedObjectStore()
+	a := new(soRecorder)
+	slow := &soBlocker{writing: make(chan struct{}), release: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		msg, _ := EncodeSharedObject(nil, amf.AMF0, &SharedObjectMessage{Name: "lobby", Events: []SharedObjectEvent{{Type: SO_USE}}})
+		s.Serve(slow, msg)
+		close(done)
+	}()
+
+	// the slow client is stuck writing its reply
+	<-slow.writing
+
+	served := make(chan struct{})
+	go func() {
+		soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_USE})
+		close(served)
+	}()
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Errorf("a slow client held up other shared objects")
+	}
+
+	close(slow.release)
+	<-done
+}
+
+func TestSharedObjectStoreOrder(t *testing.T) {
+	s := NewSharedObjectStore()
+	a := new(soRecorder)
+
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_USE})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Set("chat", "n", i)
+		}(i)
+	}
+	wg.Wait()
+
+	// changes arrive in the order of their versions
+	for i, m := range a.msgs[1:] {
+		if m.Version != uint32(i+1) {
+			t.Errorf("expected version %d, got %d", i+1, m.Version)
+		}
+	}
+}
+
+func TestSharedObjectStoreNotUsed(t *testing.T) {
+	s := NewSharedObjectStore()
+	a, b := new(soRecorder), new(soRecorder)
+
+	// no object is created for clients that do not use it
+	soRequest(t, s, b, amf.AMF0, SharedObjectEvent{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "spam"})
+	if len(s.objects) != 0 {
+		t.Errorf("expected no shared object, got %d", len(s.objects))
+	}
+
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_USE})
+	soRequest(t, s, a, amf.AMF0, SharedObjectEvent{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "hello"})
+
+	count := len(a.msgs)
+	soRequest(t, s, b, amf.AMF0,
+		SharedObjectEvent{Type: SO_REQUEST_CHANGE, Key: "topic", Value: "spam"},
+		SharedObjectEvent{Type: SO_REQUEST_REMOVE, Key: "topic"},
+		SharedObjectEvent{Type: SO_SEND_MESSAGE, Method: "say", Args: []interface{}{"spam"}},
+	)
+
+	if v, _ := s.Get("chat", "topic"); v != "hello" || s.Version("chat") != 1 {
+		t.Errorf("expected slot to be unchanged, got %#v at version %d", v, s.Version("chat"))
+	}
+
+	if len(a.msgs) != count || len(b.msgs) != 0 {
+		t.Errorf("expected no messages for events of a client not using the object")
+	}
+}
@@ -0,0 +1,570 @@
+package amf
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// selector filters messages on their headers, with the sql-like syntax of
+// jms message selectors, e.g. "priority > 2 AND region IN ('euw', 'na')".
+// Comparisons, AND, OR, NOT, IS [NOT] NULL, [NOT] IN, [NOT] LIKE and
+// [NOT] BETWEEN are supported; arithmetic is not. Missing headers compare
+// as unknown, which never matches.
+type selector func(headers Object) interface{}
+
+// selectors come from clients, and are parsed and evaluated recursively, so
+// their length and nesting are bounded
+const (
+	SELECTOR_MAX_LENGTH = 4096
+	SELECTOR_MAX_DEPTH  = 100
+)
+
+// parseSelector compiles s, returning a nil selector for an empty s.
+func parseSelector(s string) (selector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	if len(s) > SELECTOR_MAX_LENGTH {
+		return nil, Error("invalid selector: longer than %d bytes", SELECTOR_MAX_LENGTH)
+	}
+
+	tokens, err := lexSelector(s)
+	if err != nil {
+		return nil, Error("invalid selector %q: %s", s, err)
+	}
+
+	p := &selectorParser{tokens: tokens}
+
+	sel, err := p.parseOr()
+	if err != nil {
+		return nil, Error("invalid selector %q: %s", s, err)
+	}
+
+	if t := p.peek(); t.kind != selectorEOF {
+		return nil, Error("invalid selector %q: unexpected %q", s, t.text)
+	}
+
+	return sel, nil
+}
+
+// matches reports whether headers satisfy the selector. A nil selector
+// matches everything.
+func (sel selector) matches(headers Object) bool {
+	return sel == nil || sel(headers) == true
+}
+
+const (
+	selectorEOF = iota
+	selectorIdent
+	selectorString
+	selectorNumber
+	selectorOp
+)
+
+type selectorToken struct {
+	kind  int
+	text  string
+	value interface{}
+}
+
+func lexSelector(s string) ([]selectorToken, error) {
+	var tokens []selectorToken
+
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return nil, Error("unterminated string")
+				}
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						b.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			tokens = append(tokens, selectorToken{selectorString, s[i : j+1], b.String()})
+			i = j + 1
+
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || strings.IndexByte(".eE", s[j]) >= 0 ||
+				((s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			f, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, Error("invalid number %s", s[i:j])
+			}
+			tokens = append(tokens, selectorToken{selectorNumber, s[i:j], f})
+			i = j
+
+		case unicode.IsLetter(c) || c == '_' || c == '$':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '$' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, selectorToken{selectorIdent, s[i:j], nil})
+			i = j
+
+		default:
+			op := s[i : i+1]
+			if i+1 < len(s) && (s[i:i+2] == "<>" || s[i:i+2] == "<=" || s[i:i+2] == ">=") {
+				op = s[i : i+2]
+			}
+			if strings.Index("=<>(),-", op[:1]) < 0 {
+				return nil, Error("unexpected character %q", c)
+			}
+			tokens = append(tokens, selectorToken{selectorOp, op, nil})
+			i += len(op)
+		}
+	}
+
+	return tokens, nil
+}
+
+type selectorParser struct {
+	tokens []selectorToken
+	pos    int
+	depth  int
+}
+
+func (p *selectorParser) peek() selectorToken {
+	if p.pos >= len(p.tokens) {
+		return selectorToken{kind: selectorEOF, text: "end of selector"}
+	}
+
+	return p.tokens[p.pos]
+}
+
+// keyword consumes the next token if it is keyword kw.
+func (p *selectorParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == selectorIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// op consumes the next token if it is operator op.
+func (p *selectorParser) op(op string) bool {
+	if t := p.peek(); t.kind == selectorOp && t.text == op {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *selectorParser) parseOr() (selector, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		x = selectorOr(x, y)
+	}
+
+	return x, nil
+}
+
+func (p *selectorParser) parseAnd() (selector, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		x = selectorAnd(x, y)
+	}
+
+	return x, nil
+}
+
+// parseNot is where NOT and parentheses recurse, so it bounds nesting.
+func (p *selectorParser) parseNot() (selector, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > SELECTOR_MAX_DEPTH {
+		return nil, Error("nested deeper than %d", SELECTOR_MAX_DEPTH)
+	}
+
+	if p.keyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return selectorNot(x), nil
+	}
+
+	return p.parsePredicate()
+}
+
+func (p *selectorParser) parsePredicate() (selector, error) {
+	x, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"=", "<>", "<", ">", "<=", ">="} {
+		if p.op(op) {
+			y, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+
+			return selectorCompare(op, x, y), nil
+		}
+	}
+
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, Error("expected NULL, got %q", p.peek().text)
+		}
+
+		sel := func(headers Object) interface{} { return x(headers) == nil }
+		if not {
+			return selectorNot(sel), nil
+		}
+
+		return sel, nil
+	}
+
+	not := p.keyword("NOT")
+
+	var sel selector
+	switch {
+	case p.keyword("IN"):
+		sel, err = p.parseIn(x)
+	case p.keyword("LIKE"):
+		sel, err = p.parseLike(x)
+	case p.keyword("BETWEEN"):
+		sel, err = p.parseBetween(x)
+	case not:
+		return nil, Error("expected IN, LIKE or BETWEEN, got %q", p.peek().text)
+	default:
+		return x, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if not {
+		return selectorNot(sel), nil
+	}
+
+	return sel, nil
+}
+
+func (p *selectorParser) parseIn(x selector) (selector, error) {
+	if !p.op("(") {
+		return nil, Error("expected ( after IN, got %q", p.peek().text)
+	}
+
+	var values []selector
+	for {
+		v, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if p.op(")") {
+			break
+		}
+		if !p.op(",") {
+			return nil, Error("expected , or ) in IN list, got %q", p.peek().text)
+		}
+	}
+
+	return func(headers Object) interface{} {
+		a := x(headers)
+		if a == nil {
+			return nil
+		}
+
+		for _, v := range values {
+			if c, ok := selectorCmp(a, v(headers)); ok && c == 0 {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func (p *selectorParser) parseLike(x selector) (selector, error) {
+	t := p.peek()
+	if t.kind != selectorString {
+		return nil, Error("expected pattern after LIKE, got %q", t.text)
+	}
+	p.pos++
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range t.value.(string) {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return func(headers Object) interface{} {
+		s, ok := x(headers).(string)
+		if !ok {
+			return nil
+		}
+
+		return re.MatchString(s)
+	}, nil
+}
+
+func (p *selectorParser) parseBetween(x selector) (selector, error) {
+	lo, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.keyword("AND") {
+		return nil, Error("expected AND in BETWEEN, got %q", p.peek().text)
+	}
+
+	hi, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return selectorAnd(selectorCompare(">=", x, lo), selectorCompare("<=", x, hi)), nil
+}
+
+func (p *selectorParser) parseOperand() (selector, error) {
+	if p.op("(") {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.op(")") {
+			return nil, Error("expected ), got %q", p.peek().text)
+		}
+
+		return x, nil
+	}
+
+	neg := p.op("-")
+
+	t := p.peek()
+	p.pos++
+
+	switch {
+	case t.kind == selectorNumber:
+		f := t.value.(float64)
+		if neg {
+			f = -f
+		}
+		return selectorValue(f), nil
+
+	case neg:
+		return nil, Error("expected number after -, got %q", t.text)
+
+	case t.kind == selectorString:
+		return selectorValue(t.value), nil
+
+	case t.kind == selectorIdent && strings.EqualFold(t.text, "TRUE"):
+		return selectorValue(true), nil
+
+	case t.kind == selectorIdent && strings.EqualFold(t.text, "FALSE"):
+		return selectorValue(false), nil
+
+	case t.kind == selectorIdent && strings.EqualFold(t.text, "NULL"):
+		return selectorValue(nil), nil
+
+	case t.kind == selectorIdent:
+		name := t.text
+		return func(headers Object) interface{} { return selectorNormalize(headers[name]) }, nil
+	}
+
+	p.pos--
+
+	return nil, Error("unexpected %q", t.text)
+}
+
+func selectorValue(v interface{}) selector {
+	return func(Object) interface{} { return v }
+}
+
+// logical operators use three valued logic, with nil as unknown
+
+func selectorNot(x selector) selector {
+	return func(headers Object) interface{} {
+		if b, ok := x(headers).(bool); ok {
+			return !b
+		}
+
+		return nil
+	}
+}
+
+func selectorAnd(x, y selector) selector {
+	return func(headers Object) interface{} {
+		a, aok := x(headers).(bool)
+		if aok && !a {
+			return false
+		}
+
+		b, bok := y(headers).(bool)
+		if bok && !b {
+			return false
+		}
+
+		if aok && bok {
+			return true
+		}
+
+		return nil
+	}
+}
+
+func selectorOr(x, y selector) selector {
+	return func(headers Object) interface{} {
+		a, aok := x(headers).(bool)
+		if aok && a {
+			return true
+		}
+
+		b, bok := y(headers).(bool)
+		if bok && b {
+			return true
+		}
+
+		if aok && bok {
+			return false
+		}
+
+		return nil
+	}
+}
+
+func selectorCompare(op string, x, y selector) selector {
+	return func(headers Object) interface{} {
+		a, b := x(headers), y(headers)
+
+		c, ok := selectorCmp(a, b)
+		if !ok {
+			return nil
+		}
+
+		if _, isBool := a.(bool); isBool && op != "=" && op != "<>" {
+			return nil
+		}
+
+		switch op {
+		case "=":
+			return c == 0
+		case "<>":
+			return c != 0
+		case "<":
+			return c < 0
+		case ">":
+			return c > 0
+		case "<=":
+			return c <= 0
+		}
+
+		return c >= 0
+	}
+}
+
+// selectorCmp compares two numbers, strings or booleans, reporting false
+// if a and b cannot be compared.
+func selectorCmp(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+
+	case bool:
+		if b, ok := b.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			return 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// selectorNormalize returns numeric header values as float64.
+func selectorNormalize(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+
+	return v
+}
@@ -0,0 +1,83 @@
+package amf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	headers := Object{
+		"priority": 3,
+		"region":   "euw",
+		"ranked":   true,
+		"score":    12.5,
+	}
+
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"priority = 3", true},
+		{"priority <> 3", false},
+		{"priority > 2 AND region = 'euw'", true},
+		{"priority > 5 OR region = 'na'", false},
+		{"NOT (priority > 5)", true},
+		{"ranked = TRUE", true},
+		{"score BETWEEN 10 AND 20", true},
+		{"score NOT BETWEEN 10 AND 20", false},
+		{"region IN ('na', 'euw')", true},
+		{"region NOT IN ('na', 'euw')", false},
+		{"region LIKE 'eu_'", true},
+		{"region LIKE 'n%'", false},
+		{"missing IS NULL", true},
+		{"missing IS NOT NULL", false},
+		{"missing = 1", false},
+		{"NOT missing = 1", false},
+		{"missing = 1 OR priority = 3", true},
+		{"priority > -1", true},
+		{"region = 'it''s'", false},
+	}
+
+	for _, test := range tests {
+		sel, err := parseSelector(test.selector)
+		if err != nil {
+			t.Errorf("%s: %s", test.selector, err)
+			continue
+		}
+
+		if sel.matches(headers) != test.matches {
+			t.Errorf("%s: expected match %v", test.selector, test.matches)
+		}
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, s := range []string{"priority >", "region = 'euw", "(a = 1", "a NOT = 1", "a = 1 b", "a # 1"} {
+		if _, err := parseSelector(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestSelectorLimits(t *testing.T) {
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "a = 1" + strings.Repeat(")", n)
+	}
+
+	if _, err := parseSelector(nested(SELECTOR_MAX_DEPTH - 1)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	long := strings.Repeat("a = 1 AND ", SELECTOR_MAX_LENGTH/10+1) + "a = 1"
+	for _, s := range []string{nested(SELECTOR_MAX_DEPTH), strings.Repeat("NOT ", SELECTOR_MAX_DEPTH) + "a = 1", long} {
+		if _, err := parseSelector(s); err == nil {
+			t.Errorf("expected error for selector of %d bytes", len(s))
+		}
+	}
+
+	// far beyond the limits, errors are returned rather than the stack overflowing
+	if _, err := parseSelector(nested(1 << 20)); err == nil {
+		t.Errorf("expected error for deeply nested selector")
+	}
+}
@@ -0,0 +1,229 @@
+package amf
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// status codes sent with remoting faults
+const (
+	STATUS_RESOURCE_UNAVAILABLE = "Server.ResourceUnavailable"
+	STATUS_PROCESSING           = "Server.Processing"
+	STATUS_ARGUMENT_MISMATCH    = "Server.Processing.ArgumentMismatch"
+	STATUS_AUTHENTICATION       = "Client.Authentication"
+)
+
+// StatusError is the status object of a failed remoting call, sent to
+// /onStatus. Service methods may return one to choose the code sent.
+type StatusError struct {
+	Level       string `amf:"level"`
+	Code        string `amf:"code"`
+	Description string `amf:"description"`
+	Details     string `amf:"details,omitempty"`
+}
+
+func NewStatusError(code string, f string, v ...interface{}) *StatusError {
+	return &StatusError{
+		Level:       "error",
+		Code:        code,
+		Description: fmt.Sprintf(f, v...),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// statusOf returns err as a status, wrapping errors that are not one.
+func statusOf(err error) *StatusError {
+	if se, ok := err.(*StatusError); ok {
+		return se
+	}
+
+	return NewStatusError(STATUS_PROCESSING, "%s", err)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// serviceMap holds go objects whose exported methods are exposed to
+// remoting clients, keyed by service name.
+type serviceMap struct {
+	sync.RWMutex
+	services map[string]*service
+}
+
+type service struct {
+	rcvr    reflect.Value
+	methods map[string]reflect.Method
+}
+
+func (sm *serviceMap) register(name string, rcvr interface{}) error {
+	v := reflect.ValueOf(rcvr)
+	if !v.IsValid() {
+		return Error("amf: cannot register nil service %s", name)
+	}
+
+	if name == "" {
+		return Error("amf: cannot register service %s without a name", v.Type())
+	}
+
+	s := &service{rcvr: v, methods: make(map[string]reflect.Method)}
+
+	for i := 0; i < v.Type().NumMethod(); i++ {
+		m := v.Type().Method(i)
+		if m.PkgPath != "" || !validResults(m.Type) {
+			continue
+		}
+
+		s.methods[m.Name] = m
+	}
+
+	if len(s.methods) == 0 {
+		return Error("amf: service %s has no exported methods that can be called", name)
+	}
+
+	sm.Lock()
+	defer sm.Unlock()
+
+	if sm.services == nil {
+		sm.services = make(map[string]*service)
+	}
+
+	sm.services[name] = s
+
+	return nil
+}
+
+// validResults reports whether a method returns nothing, a value, an error,
+// or a value and an error.
+func validResults(t reflect.Type) bool {
+	switch t.NumOut() {
+	case 0:
+		return true
+	case 1:
+		return true
+	case 2:
+		return t.Out(1) == errorType
+	}
+
+	return false
+}
+
+// callTarget calls the method named by target, a service name and a method
+// name joined by a dot, e.g. "com.example.SummonerService.getSummoner".
+func (sm *serviceMap) callTarget(target string, args interface{}) (interface{}, error) {
+	i := strings.LastIndex(target, ".")
+	if i < 0 {
+		return nil, NewStatusError(STATUS_RESOURCE_UNAVAILABLE, "invalid target %s", target)
+	}
+
+	return sm.call(target[:i], target[i+1:], args)
+}
+
+// call converts the decoded args to the parameter types of the method and
+// calls it. args holds the arguments as an Array, or is a lone argument.
+func (sm *serviceMap) call(name string, method string, args interface{}) (result interface{}, err error) {
+	sm.RLock()
+	s, ok := sm.services[name]
+	sm.RUnlock()
+
+	if !ok {
+		return nil, NewStatusError(STATUS_RESOURCE_UNAVAILABLE, "unknown service %s", name)
+	}
+
+	m, ok := s.methods[method]
+	if !ok && method != "" {
+		// actionscript method names are usually lower camel case
+		m, ok = s.methods[strings.ToUpper(method[:1])+method[1:]]
+	}
+
+	if !ok {
+		return nil, NewStatusError(STATUS_RESOURCE_UNAVAILABLE, "unknown method %s on service %s", method, name)
+	}
+
+	var params Array
+	switch val := args.(type) {
+	case nil:
+	case Array:
+		params = val
+	default:
+		params = Array{val}
+	}
+
+	in, err := convertArgs(m.Type, params)
+	if err != nil {
+		return nil, NewStatusError(STATUS_ARGUMENT_MISMATCH, "%s.%s: %s", name, method, err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewStatusError(STATUS_PROCESSING, "%s.%s: %v", name, method, r)
+		}
+	}()
+
+	var out []reflect.Value
+	if m.Type.IsVariadic() {
+		out = s.rcvr.Method(m.Index).CallSlice(in)
+	} else {
+		out = s.rcvr.Method(m.Index).Call(in)
+	}
+
+	switch len(out) {
+	case 1:
+		if m.Type.Out(0) == errorType {
+			err, _ = out[0].Interface().(error)
+			return nil, err
+		}
+		return out[0].Interface(), nil
+	case 2:
+		err, _ = out[1].Interface().(error)
+		return out[0].Interface(), err
+	}
+
+	return nil, nil
+}
+
+// convertArgs converts params to the parameter types of method type t,
+// which includes the receiver. missing trailing arguments are zero values.
+func convertArgs(t reflect.Type, params Array) ([]reflect.Value, error) {
+	numIn := t.NumIn() - 1
+	variadic := t.IsVariadic()
+
+	if len(params) > numIn && !variadic {
+		return nil, Error("expected %d arguments, got %d", numIn, len(params))
+	}
+
+	in := make([]reflect.Value, 0, numIn)
+
+	for i := 0; i < numIn; i++ {
+		pt := t.In(i + 1)
+
+		if variadic && i == numIn-1 {
+			rest := Array{}
+			if len(params) > i {
+				rest = params[i:]
+			}
+
+			v := reflect.New(pt)
+			if err := Convert(rest, v.Interface()); err != nil {
+				return nil, Error("argument %d: %s", i, err)
+			}
+
+			in = append(in, v.Elem())
+			break
+		}
+
+		v := reflect.New(pt)
+		if i < len(params) {
+			if err := Convert(params[i], v.Interface()); err != nil {
+				return nil, Error("argument %d: %s", i, err)
+			}
+		}
+
+		in = append(in, v.Elem())
+	}
+
+	return in, nil
+}
@@ -0,0 +1,273 @@
+package amf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// the header of a local shared object file
+const (
+	SOL_MAGIC     = 0x00bf
+	SOL_SIGNATURE = "TCSO"
+)
+
+var solReserved = []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+
+// SOL is a local shared object as flash player persists it in a .sol file.
+// Slots keep the order they were read in, and a SOL read with ReadSOL is
+// written back byte for byte as long as its slots hold what was read.
+// Once they are changed, by any means, they are encoded anew.
+type SOL struct {
+	Name    string
+	Version Version
+	Slots   []SOLSlot
+
+	// the slots as read, with the version they were encoded in
+	raw        []byte
+	rawVersion Version
+}
+
+type SOLSlot struct {
+	Name  string
+	Value interface{}
+}
+
+// ReadSOL reads a local shared object from r with a new Decoder.
+func ReadSOL(r io.Reader) (*SOL, error) {
+	return NewDecoder().ReadSOL(r)
+}
+
+// WriteSOL writes s to w with a new Encoder.
+func WriteSOL(w io.Writer, s *SOL) (int, error) {
+	return new(Encoder).WriteSOL(w, s)
+}
+
+// Values returns the values of the slots by name.
+func (s *SOL) Values() Object {
+	obj := make(Object, len(s.Slots))
+	for _, slot := range s.Slots {
+		obj[slot.Name] = slot.Value
+	}
+
+	return obj
+}
+
+// Get returns the value of the slot name, and whether there is one.
+func (s *SOL) Get(name string) (interface{}, bool) {
+	for _, slot := range s.Slots {
+		if slot.Name == name {
+			return slot.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Set replaces the value of the slot name, or appends a slot if there is
+// none.
+func (s *SOL) Set(name string, value interface{}) {
+	for i := range s.Slots {
+		if s.Slots[i].Name == name {
+			s.Slots[i].Value = value
+			return
+		}
+	}
+
+	s.Slots = append(s.Slots, SOLSlot{Name: name, Value: value})
+}
+
+// Delete removes the slot name.
+func (s *SOL) Delete(name string) {
+	for i := range s.Slots {
+		if s.Slots[i].Name == name {
+			s.Slots = append(s.Slots[:i], s.Slots[i+1:]...)
+			return
+		}
+	}
+}
+
+// unchanged reports whether the slots of s are still those it was read
+// with, decoding them again to compare, so that changes made directly to
+// Slots or to the values in them are not lost.
+func (s *SOL) unchanged() bool {
+	if s.raw == nil || s.rawVersion != s.Version {
+		return false
+	}
+
+	slots, err := NewDecoder().readSOLSlots(bytes.NewReader(s.raw), s.Version)
+	if err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(slots, s.Slots)
+}
+
+// format:
+// - 2 byte big endian uint16 0x00bf
+// - 4 byte big endian uint32 length of the rest of the file
+// - 4 byte signature "TCSO"
+// - 6 bytes 0x00 0x04 0x00 0x00 0x00 0x00
+// - 2 byte big endian uint16 length of name followed by the name
+// - 4 byte big endian uint32 version
+// - slots until the end of the file:
+//   - name as an amf0 string without marker, or amf3 string without marker
+//   - encoded amf0 or amf3 value
+//   - 1 byte 0x00
+//
+// in amf3 files the reference tables are shared by all slots.
+func (d *Decoder) ReadSOL(r io.Reader) (*SOL, error) {
+	var magic uint16
+	if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+		return nil, Error("decode sol: unable to read magic: %s", err)
+	}
+
+	if magic != SOL_MAGIC {
+		return nil, Error("decode sol: unexpected magic 0x%04x", magic)
+	}
+
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, Error("decode sol: unable to read length: %s", err)
+	}
+
+	lr := &io.LimitedReader{R: r, N: int64(length)}
+
+	header, err := ReadBytes(lr, len(SOL_SIGNATURE)+len(solReserved))
+	if err != nil {
+		return nil, Error("decode sol: unable to read header: %s", err)
+	}
+
+	if string(header[:len(SOL_SIGNATURE)]) != SOL_SIGNATURE {
+		return nil, Error("decode sol: unexpected signature %q", header[:len(SOL_SIGNATURE)])
+	}
+
+	s := new(SOL)
+
+	if s.Name, err = d.DecodeAmf0String(lr, false); err != nil {
+		return nil, Error("decode sol: unable to read name: %s", err)
+	}
+
+	var version uint32
+	if err = binary.Read(lr, binary.BigEndian, &version); err != nil {
+		return nil, Error("decode sol: unable to read version: %s", err)
+	}
+
+	if version != AMF0 && version != AMF3 {
+		return nil, Error("decode sol: unsupported version %d", version)
+	}
+	s.Version = Version(version)
+
+	if s.raw, err = ioutil.ReadAll(lr); err != nil {
+		return nil, Error("decode sol: unable to read slots: %s", err)
+	}
+	s.rawVersion = s.Version
+
+	if lr.N > 0 {
+		return nil, Error("decode sol: file is %d bytes short", lr.N)
+	}
+
+	if s.Slots, err = d.readSOLSlots(bytes.NewReader(s.raw), s.Version); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (d *Decoder) readSOLSlots(br *bytes.Reader, ver Version) (slots []SOLSlot, err error) {
+	d.Reset()
+
+	for br.Len() > 0 {
+		var slot SOLSlot
+
+		if ver == AMF3 {
+			slot.Name, err = d.DecodeAmf3String(br, false)
+		} else {
+			slot.Name, err = d.DecodeAmf0String(br, false)
+		}
+		if err != nil {
+			return nil, Error("decode sol: unable to read slot name: %s", err)
+		}
+
+		if slot.Value, err = d.Decode(br, ver); err != nil {
+			return nil, Error("decode sol: unable to read slot %s: %s", slot.Name, err)
+		}
+
+		if _, err = ReadByte(br); err != nil {
+			return nil, Error("decode sol: unable to read slot %s terminator: %s", slot.Name, err)
+		}
+
+		slots = append(slots, slot)
+	}
+
+	return slots, nil
+}
+
+// same format as ReadSOL
+func (e *Encoder) WriteSOL(w io.Writer, s *SOL) (n int, err error) {
+	if s.Version != AMF0 && s.Version != AMF3 {
+		return 0, Error("encode sol: unsupported version %d", s.Version)
+	}
+
+	// the body is buffered to learn its length
+	buf := new(bytes.Buffer)
+	buf.WriteString(SOL_SIGNATURE)
+	buf.Write(solReserved)
+
+	if _, err = e.EncodeAmf0String(buf, s.Name, false); err != nil {
+		return 0, Error("encode sol: unable to write name: %s", err)
+	}
+
+	binary.Write(buf, binary.BigEndian, uint32(s.Version))
+
+	if s.unchanged() {
+		buf.Write(s.raw)
+	} else if err = e.writeSOLSlots(buf, s); err != nil {
+		return 0, err
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint16(SOL_MAGIC)); err != nil {
+		return n, Error("encode sol: unable to write magic: %s", err)
+	}
+	n += 2
+
+	if err = binary.Write(w, binary.BigEndian, uint32(buf.Len())); err != nil {
+		return n, Error("encode sol: unable to write length: %s", err)
+	}
+	n += 4
+
+	m, err := w.Write(buf.Bytes())
+	n += m
+	if err != nil {
+		return n, Error("encode sol: unable to write body: %s", err)
+	}
+
+	return
+}
+
+func (e *Encoder) writeSOLSlots(w io.Writer, s *SOL) (err error) {
+	e.Reset()
+
+	for _, slot := range s.Slots {
+		if s.Version == AMF3 {
+			_, err = e.EncodeAmf3String(w, slot.Name, false)
+		} else {
+			_, err = e.EncodeAmf0String(w, slot.Name, false)
+		}
+		if err != nil {
+			return Error("encode sol: unable to write slot name: %s", err)
+		}
+
+		if _, err = e.Encode(w, slot.Value, s.Version); err != nil {
+			return Error("encode sol: unable to write slot %s: %s", slot.Name, err)
+		}
+
+		if err = WriteByte(w, 0x00); err != nil {
+			return Error("encode sol: unable to write slot %s terminator: %s", slot.Name, err)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,191 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var solAmf0 = []byte{
+	0x00, 0xbf, 0x00, 0x00, 0x00, 0x29,
+	'T', 'C', 'S', 'O', 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x04, 't', 'e', 's', 't',
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x01, 'a', 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x01, 'b', 0x02, 0x00, 0x01, 'x', 0x00,
+}
+
+// the second value and the third name are references into the string table
+// left by the first slot
+var solAmf3 = []byte{
+	0x00, 0xbf, 0x00, 0x00, 0x00, 0x29,
+	'T', 'C', 'S', 'O', 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x04, 't', 'e', 's', 't',
+	0x00, 0x00, 0x00, 0x03,
+	0x09, 'n', 'a', 'm', 'e', 0x06, 0x03, 'x', 0x00,
+	0x0b, 'o', 't', 'h', 'e', 'r', 0x06, 0x02, 0x00,
+	0x02, 0x03, 0x00,
+}
+
+func TestReadSOLAmf0(t *testing.T) {
+	s, err := ReadSOL(bytes.NewReader(solAmf0))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if s.Name != "test" || s.Version != AMF0 || len(s.Slots) != 2 {
+		t.Fatalf("unexpected sol %+v", s)
+	}
+
+	values := s.Values()
+	if values["a"] != float64(1) || values["b"] != "x" {
+		t.Errorf("unexpected values %#v", values)
+	}
+}
+
+func TestReadSOLAmf3(t *testing.T) {
+	s, err := ReadSOL(bytes.NewReader(solAmf3))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if s.Name != "test" || s.Version != AMF3 {
+		t.Fatalf("unexpected sol %+v", s)
+	}
+
+	expect := []SOLSlot{{"name", "x"}, {"other", "x"}, {"x", true}}
+	if len(s.Slots) != len(expect) {
+		t.Fatalf("expected %d slots, got %d", len(expect), len(s.Slots))
+	}
+
+	for i, slot := range expect {
+		if s.Slots[i] != slot {
+			t.Errorf("expected slot %+v, got %+v", slot, s.Slots[i])
+		}
+	}
+}
+
+func TestWriteSOL(t *testing.T) {
+	for _, file := range [][]byte{solAmf0, solAmf3} {
+		s, err := ReadSOL(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		buf := new(bytes.Buffer)
+		n, err := WriteSOL(buf, s)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if n != len(file) || bytes.Compare(buf.Bytes(), file) != 0 {
+			t.Errorf("expected buffer: %#v, got: %#v", file, buf.Bytes())
+		}
+
+		// storing the same value again leaves the file as it was
+		s.Set(s.Slots[0].Name, s.Slots[0].Value)
+
+		buf.Reset()
+		if _, err = WriteSOL(buf, s); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if bytes.Compare(buf.Bytes(), file) != 0 {
+			t.Errorf("expected buffer: %#v, got: %#v", file, buf.Bytes())
+		}
+	}
+}
+
+func TestWriteSOLChanged(t *testing.T) {
+	s, err := ReadSOL(bytes.NewReader(solAmf3))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	s.Set("other", "y")
+	s.Set("added", "x")
+	s.Delete("x")
+
+	buf := new(bytes.Buffer)
+	if _, err = WriteSOL(buf, s); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	got, err := ReadSOL(buf)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expect := []SOLSlot{{"name", "x"}, {"other", "y"}, {"added", "x"}}
+	if len(got.Slots) != len(expect) {
+		t.Fatalf("expected %d slots, got %d", len(expect), len(got.Slots))
+	}
+
+	for i, slot := range expect {
+		if got.Slots[i] != slot {
+			t.Errorf("expected slot %+v, got %+v", slot, got.Slots[i])
+		}
+	}
+
+	if v, ok := got.Get("x"); ok {
+		t.Errorf("expected deleted slot, got %#v", v)
+	}
+}
+
+func TestWriteSOLChangedDirectly(t *testing.T) {
+	for _, file := range [][]byte{solAmf0, solAmf3} {
+		s, err := ReadSOL(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		s.Slots[0].Value = "changed"
+		s.Slots = append(s.Slots, SOLSlot{Name: "added", Value: Object{"a": "b"}})
+
+		buf := new(bytes.Buffer)
+		if _, err = WriteSOL(buf, s); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		got, err := ReadSOL(buf)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if v, _ := got.Get(s.Slots[0].Name); v != "changed" {
+			t.Errorf("expected changed slot, got %#v", v)
+		}
+
+		// values changed in place are kept too
+		got.Slots[len(got.Slots)-1].Value.(Object)["a"] = "c"
+
+		buf.Reset()
+		if _, err = WriteSOL(buf, got); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if got, err = ReadSOL(buf); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if v, _ := got.Get("added"); !reflect.DeepEqual(v, Object{"a": "c"}) {
+			t.Errorf("expected value changed in place, got %#v", v)
+		}
+	}
+}
+
+func TestReadSOLErrors(t *testing.T) {
+	bad := append([]byte(nil), solAmf0...)
+	bad[1] = 0xbe
+	if _, err := ReadSOL(bytes.NewReader(bad)); err == nil {
+		t.Errorf("expected error for bad magic")
+	}
+
+	bad = append([]byte(nil), solAmf0...)
+	bad[25] = 0x02
+	if _, err := ReadSOL(bytes.NewReader(bad)); err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+
+	if _, err := ReadSOL(bytes.NewReader(solAmf0[:len(solAmf0)-3])); err == nil {
+		t.Errorf("expected error for short file")
+	}
+}
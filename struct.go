@@ -0,0 +1,325 @@
+package amf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// a single serializable field of a struct, as described by its amf tag
+type structField struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	tagged    bool
+	omitEmpty bool
+}
+
+var structFieldCache struct {
+	sync.RWMutex
+	m map[reflect.Type][]structField
+}
+
+// cachedStructFields returns the serializable fields of struct type t in
+// declaration order, computing them on first use.
+func cachedStructFields(t reflect.Type) []structField {
+	structFieldCache.RLock()
+	fields, ok := structFieldCache.m[t]
+	structFieldCache.RUnlock()
+	if ok {
+		return fields
+	}
+
+	fields = typeStructFields(t)
+
+	structFieldCache.Lock()
+	if structFieldCache.m == nil {
+		structFieldCache.m = make(map[reflect.Type][]structField)
+	}
+	structFieldCache.m[t] = fields
+	structFieldCache.Unlock()
+
+	return fields
+}
+
+// parseTag splits an amf struct tag into its name and options.
+// format: `amf:"name,omitempty"`, or `amf:"-"` to skip the field.
+func parseTag(tag string) (name string, omitEmpty bool) {
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return
+}
+
+// typeStructFields walks t breadth first, promoting the fields of embedded
+// structs that have no explicit tag name. when several fields share a name,
+// the shallowest wins, with tagged fields breaking ties at the same depth.
+// if the tie cannot be broken, all of them are dropped.
+func typeStructFields(t reflect.Type) []structField {
+	type candidate struct {
+		structField
+		depth int
+	}
+
+	var found []candidate
+
+	type walk struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var current []walk
+	next := []walk{{typ: t}}
+	visited := map[reflect.Type]bool{}
+
+	for depth := 0; len(next) > 0; depth++ {
+		current, next = next, nil
+
+		for _, w := range current {
+			if visited[w.typ] {
+				continue
+			}
+			visited[w.typ] = true
+
+			for i := 0; i < w.typ.NumField(); i++ {
+				sf := w.typ.Field(i)
+
+				if sf.PkgPath != "" && !sf.Anonymous {
+					continue
+				}
+
+				tag := sf.Tag.Get("amf")
+				if tag == "-" {
+					continue
+				}
+
+				name, omitEmpty := parseTag(tag)
+
+				index := make([]int, len(w.index)+1)
+				copy(index, w.index)
+				index[len(w.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, walk{typ: ft, index: index})
+					continue
+				}
+
+				if sf.PkgPath != "" {
+					continue
+				}
+
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+
+				found = append(found, candidate{
+					structField: structField{
+						name:      name,
+						index:     index,
+						typ:       sf.Type,
+						tagged:    tagged,
+						omitEmpty: omitEmpty,
+					},
+					depth: depth,
+				})
+			}
+		}
+	}
+
+	byName := make(map[string][]candidate)
+	for _, c := range found {
+		byName[c.name] = append(byName[c.name], c)
+	}
+
+	var dominant []candidate
+	for _, cs := range byName {
+		sort.SliceStable(cs, func(i, j int) bool {
+			if cs[i].depth != cs[j].depth {
+				return cs[i].depth < cs[j].depth
+			}
+			return cs[i].tagged && !cs[j].tagged
+		})
+
+		if len(cs) > 1 && cs[0].depth == cs[1].depth && cs[0].tagged == cs[1].tagged {
+			continue
+		}
+
+		dominant = append(dominant, cs[0])
+	}
+
+	// restore declaration order, which for embedded structs means the
+	// position of the embedding field rather than the depth it was found at
+	sort.Slice(dominant, func(i, j int) bool {
+		a, b := dominant[i].index, dominant[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	fields := make([]structField, len(dominant))
+	for i, c := range dominant {
+		fields[i] = c.structField
+	}
+
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but reports false instead
+// of panicking when it walks through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// structProperties returns the names and values of the fields of struct
+// value v that should be serialized, honoring omitempty.
+func structProperties(v reflect.Value) (names []string, values []interface{}) {
+	for _, f := range cachedStructFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
+
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		names = append(names, f.name)
+		values = append(values, fv.Interface())
+	}
+
+	return
+}
+
+// mapToObject copies a map with string keys into an Object.
+func mapToObject(v reflect.Value) (Object, bool) {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+
+	obj := make(Object, v.Len())
+	for _, k := range v.MapKeys() {
+		obj[k.String()] = v.MapIndex(k).Interface()
+	}
+
+	return obj, true
+}
+
+var (
+	typedObjectType  = reflect.TypeOf(TypedObject{})
+	mixedArrayType   = reflect.TypeOf(MixedArray{})
+	vectorIntType    = reflect.TypeOf(VectorInt{})
+	vectorUintType   = reflect.TypeOf(VectorUint{})
+	vectorDoubleType = reflect.TypeOf(VectorDouble{})
+	vectorObjectType = reflect.TypeOf(VectorObject{})
+	dictionaryType   = reflect.TypeOf(Dictionary{})
+)
+
+// marshaledAsStruct reports whether values of t are written field by field,
+// rather than by an encoder dedicated to the type.
+func marshaledAsStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	switch t {
+	case timeType, typedObjectType, mixedArrayType, vectorIntType, vectorUintType, vectorDoubleType, vectorObjectType, dictionaryType:
+		return false
+	}
+
+	return true
+}
+
+// identity of a value that is shared by reference in go: the address of a
+// pointer or map, or the backing array and length of a slice
+type refKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// dates are immutable, so they are referenced by value
+type dateRefKey float64
+
+// mixed arrays are referenced by both of their parts, which may be shared
+// between arrays separately
+type mixedRefKey struct {
+	assoc uintptr
+	dense uintptr
+	len   int
+}
+
+// identityOf returns a key under which v can be found again in a reference
+// table, or nil if v has no identity worth tracking.
+func identityOf(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Map, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return refKey{v.Type(), v.Pointer(), 0}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		return refKey{v.Type(), v.Pointer(), v.Len()}
+	}
+
+	return nil
+}
+
+// identityAs is like identityOf, but files the key under type t, so that a
+// wrapper type is not mistaken for a plain value sharing its storage.
+func identityAs(t reflect.Type, v reflect.Value) interface{} {
+	key, ok := identityOf(v).(refKey)
+	if !ok {
+		return nil
+	}
+
+	key.typ = t
+
+	return key
+}
@@ -0,0 +1,406 @@
+package amf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	objectType = reflect.TypeOf(Object{})
+)
+
+// Unmarshal decodes a single value of the given amf version from data and
+// stores it in the value pointed to by v.
+func Unmarshal(data []byte, v interface{}, ver Version) error {
+	return NewDecoder().DecodeInto(bytes.NewReader(data), v, ver)
+}
+
+// DecodeInto reads the next value of the given amf version from r and
+// stores it in the value pointed to by v.
+func (d *Decoder) DecodeInto(r io.Reader, v interface{}, ver Version) error {
+	val, err := d.Decode(r, ver)
+	if err != nil {
+		return err
+	}
+
+	return Convert(val, v)
+}
+
+// Convert stores a value tree produced by the decoder (Object, Array, float64,
+// int32 and friends) in the value pointed to by v. Struct fields are matched
+// by their amf tag name, falling back to a case-insensitive match on the
+// field name. Numbers are converted between Go numeric types as long as no
+// precision is lost.
+func Convert(src interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return Error("unmarshal: destination must be a non-nil pointer, got %T", v)
+	}
+
+	return new(converter).assign(rv.Elem(), src, "")
+}
+
+// converter remembers the destinations it built for decoded objects, so
+// that cyclic object graphs convert to cyclic go values instead of
+// recursing forever.
+type converter struct {
+	seen map[convertKey]reflect.Value
+}
+
+type convertKey struct {
+	src refKey
+	dst reflect.Type
+}
+
+// lookup returns the destination previously built from the object src for
+// type t. if there is none, build is called to create it, and the result is
+// remembered before being filled by the caller.
+func (c *converter) lookup(src interface{}, t reflect.Type, build func() reflect.Value) (v reflect.Value, found bool) {
+	obj, ok := objectOf(src)
+	if !ok || obj == nil {
+		return build(), false
+	}
+
+	key := convertKey{refKey{objectType, reflect.ValueOf(obj).Pointer(), 0}, t}
+	if v, found = c.seen[key]; found {
+		return
+	}
+
+	if c.seen == nil {
+		c.seen = make(map[convertKey]reflect.Value)
+	}
+
+	v = build()
+	c.seen[key] = v
+
+	return v, false
+}
+
+func (c *converter) assign(dst reflect.Value, src interface{}, path string) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		ptr, found := c.lookup(src, dst.Type(), func() reflect.Value {
+			if dst.IsNil() {
+				return reflect.New(dst.Type().Elem())
+			}
+			return dst
+		})
+
+		dst.Set(ptr)
+		if found {
+			return nil
+		}
+
+		return c.assign(dst.Elem(), src, path)
+	}
+
+	// pointers to decoded values can be stored in non-pointer destinations
+	if sv.Kind() == reflect.Ptr {
+		if sv.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		return c.assign(dst, sv.Elem().Interface(), path)
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		if sv.Kind() == reflect.Bool {
+			dst.SetBool(sv.Bool())
+			return nil
+		}
+
+	case reflect.String:
+		if sv.Kind() == reflect.String {
+			dst.SetString(sv.String())
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f, ok := numberOf(sv); ok {
+			n := int64(f)
+			if f != math.Trunc(f) || float64(n) != f || dst.OverflowInt(n) {
+				return Error("unmarshal: number %v overflows %s%s", f, dst.Type(), describePath(path))
+			}
+			dst.SetInt(n)
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if f, ok := numberOf(sv); ok {
+			n := uint64(f)
+			if f < 0 || f != math.Trunc(f) || float64(n) != f || dst.OverflowUint(n) {
+				return Error("unmarshal: number %v overflows %s%s", f, dst.Type(), describePath(path))
+			}
+			dst.SetUint(n)
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if f, ok := numberOf(sv); ok {
+			dst.SetFloat(f)
+			return nil
+		}
+
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			// amf0 dates decode to milliseconds since epoch
+			if f, ok := numberOf(sv); ok {
+				ms := int64(f)
+				dst.Set(reflect.ValueOf(time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC()))
+				return nil
+			}
+			break
+		}
+
+		if obj, ok := objectOf(src); ok {
+			return c.assignStruct(dst, obj, path)
+		}
+
+	case reflect.Map:
+		if dict, ok := src.(Dictionary); ok {
+			return c.assignDictionary(dst, dict, path)
+		}
+
+		if obj, ok := objectOf(src); ok {
+			return c.assignMap(dst, obj, path)
+		}
+
+	case reflect.Slice:
+		if elems, ok := elementsOf(src); ok {
+			slice := reflect.MakeSlice(dst.Type(), elems.Len(), elems.Len())
+			for i := 0; i < elems.Len(); i++ {
+				if err := c.assign(slice.Index(i), elems.Index(i).Interface(), indexPath(path, i)); err != nil {
+					return err
+				}
+			}
+			dst.Set(slice)
+			return nil
+		}
+
+	case reflect.Array:
+		if elems, ok := elementsOf(src); ok {
+			if elems.Len() > dst.Len() {
+				return Error("unmarshal: array of length %d does not fit in %s%s", elems.Len(), dst.Type(), describePath(path))
+			}
+			for i := 0; i < dst.Len(); i++ {
+				var elem interface{}
+				if i < elems.Len() {
+					elem = elems.Index(i).Interface()
+				}
+				if err := c.assign(dst.Index(i), elem, indexPath(path, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	return Error("unmarshal: cannot assign %s to %s%s", sv.Type(), dst.Type(), describePath(path))
+}
+
+func (c *converter) assignStruct(dst reflect.Value, obj Object, path string) error {
+	for key, val := range obj {
+		if err := c.assignField(dst, key, val, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// assignField stores val in the field of struct dst matching key, if any.
+func (c *converter) assignField(dst reflect.Value, key string, val interface{}, path string) error {
+	f, ok := findStructField(cachedStructFields(dst.Type()), key)
+	if !ok {
+		return nil
+	}
+
+	fv := fieldByIndexAlloc(dst, f.index)
+
+	return c.assign(fv, val, fieldPath(path, key))
+}
+
+func (c *converter) assignMap(dst reflect.Value, obj Object, path string) error {
+	t := dst.Type()
+	if t.Key().Kind() != reflect.String {
+		return Error("unmarshal: cannot assign object to %s, keys must be strings%s", t, describePath(path))
+	}
+
+	m, found := c.lookup(obj, t, func() reflect.Value {
+		if dst.IsNil() {
+			return reflect.MakeMap(t)
+		}
+		return dst
+	})
+
+	dst.Set(m)
+	if found {
+		return nil
+	}
+
+	for key, val := range obj {
+		elem := reflect.New(t.Elem()).Elem()
+		if err := c.assign(elem, val, fieldPath(path, key)); err != nil {
+			return err
+		}
+		dst.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+	}
+
+	return nil
+}
+
+// assignDictionary stores the entries of dict in map dst, converting keys
+// and values to the map's types.
+func (c *converter) assignDictionary(dst reflect.Value, dict Dictionary, path string) error {
+	t := dst.Type()
+	if dst.IsNil() {
+		dst.Set(reflect.MakeMap(t))
+	}
+
+	for i, entry := range dict.Entries {
+		key := reflect.New(t.Key()).Elem()
+		if err := c.assign(key, entry.Key, indexPath(path, i)); err != nil {
+			return err
+		}
+
+		elem := reflect.New(t.Elem()).Elem()
+		if err := c.assign(elem, entry.Value, fieldPath(path, fmt.Sprint(entry.Key))); err != nil {
+			return err
+		}
+
+		dst.SetMapIndex(key, elem)
+	}
+
+	return nil
+}
+
+// elementsOf returns the elements of the decoded sequence src, which may be
+// an Array, the dense portion of a MixedArray or the values of a vector.
+func elementsOf(src interface{}) (reflect.Value, bool) {
+	switch val := src.(type) {
+	case Array:
+		return reflect.ValueOf(val), true
+	case MixedArray:
+		return reflect.ValueOf(val.Dense), true
+	case VectorInt:
+		return reflect.ValueOf(val.Values), true
+	case VectorUint:
+		return reflect.ValueOf(val.Values), true
+	case VectorDouble:
+		return reflect.ValueOf(val.Values), true
+	case VectorObject:
+		return reflect.ValueOf(val.Values), true
+	}
+
+	return reflect.Value{}, false
+}
+
+func findStructField(fields []structField, key string) (structField, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+	}
+
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+
+	return structField{}, false
+}
+
+// fieldByIndexAlloc is like reflect.Value.FieldByIndex, but allocates any nil
+// embedded pointers along the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v
+}
+
+func numberOf(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
+
+func objectOf(src interface{}) (Object, bool) {
+	switch val := src.(type) {
+	case Object:
+		return val, true
+	case TypedObject:
+		return val.Object, true
+	case *TypedObject:
+		return val.Object, true
+	case MixedArray:
+		return val.Associative, true
+	}
+
+	// structs convert to other structs through their properties
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Struct && v.Type() != timeType {
+		keys, values := structProperties(v)
+
+		obj := make(Object, len(keys))
+		for i, key := range keys {
+			obj[key] = values[i]
+		}
+
+		return obj, true
+	}
+
+	return nil, false
+}
+
+func fieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	return " at " + path
+}
@@ -0,0 +1,147 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type unmarshalPoint struct {
+	X int     `amf:"x"`
+	Y float32 `amf:"y"`
+}
+
+type unmarshalShape struct {
+	Name    string                 `amf:"name"`
+	Origin  *unmarshalPoint        `amf:"origin"`
+	Points  []unmarshalPoint       `amf:"points"`
+	Labels  map[string]string      `amf:"labels"`
+	Created time.Time              `amf:"created"`
+	Extra   interface{}            `amf:"extra"`
+	Counts  [2]uint8               `amf:"counts"`
+	Visible bool                   // matched case-insensitively
+	Ignored map[string]interface{} `amf:"-"`
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	created := time.Date(2014, 2, 2, 10, 0, 0, 0, time.UTC)
+
+	for _, ver := range []Version{AMF0, AMF3} {
+		val := Object{
+			"name":    "shape",
+			"origin":  Object{"x": 1, "y": 2.5},
+			"points":  Array{Object{"x": 3.0, "y": 4}},
+			"labels":  Object{"color": "red"},
+			"created": created,
+			"extra":   Array{"a", true},
+			"counts":  Array{1, 2},
+			"visible": true,
+			"unknown": "ignored",
+		}
+
+		buf := new(bytes.Buffer)
+		if _, err := new(Encoder).Encode(buf, val, ver); err != nil {
+			t.Fatalf("amf%d encode: %s", ver, err)
+		}
+
+		var got unmarshalShape
+		if err := Unmarshal(buf.Bytes(), &got, ver); err != nil {
+			t.Fatalf("amf%d unmarshal: %s", ver, err)
+		}
+
+		expect := unmarshalShape{
+			Name:    "shape",
+			Origin:  &unmarshalPoint{1, 2.5},
+			Points:  []unmarshalPoint{{3, 4}},
+			Labels:  map[string]string{"color": "red"},
+			Created: created,
+			Extra:   Array{"a", true},
+			Counts:  [2]uint8{1, 2},
+			Visible: true,
+		}
+
+		if !reflect.DeepEqual(expect, got) {
+			t.Errorf("amf%d unmarshal: expected %+v, got %+v", ver, expect, got)
+		}
+	}
+}
+
+func TestUnmarshalNumbers(t *testing.T) {
+	var i int64
+	if err := Convert(int32(-7), &i); err != nil || i != -7 {
+		t.Errorf("expected -7, got %d (%v)", i, err)
+	}
+
+	var f float64
+	if err := Convert(int32(7), &f); err != nil || f != 7 {
+		t.Errorf("expected 7, got %v (%v)", f, err)
+	}
+
+	var u uint16
+	if err := Convert(float64(65535), &u); err != nil || u != 65535 {
+		t.Errorf("expected 65535, got %d (%v)", u, err)
+	}
+
+	if err := Convert(float64(65536), &u); err == nil {
+		t.Errorf("expected overflow error for uint16")
+	}
+
+	if err := Convert(float64(-1), &u); err == nil {
+		t.Errorf("expected overflow error for negative uint16")
+	}
+
+	if err := Convert(float64(1.5), &i); err == nil {
+		t.Errorf("expected error converting fraction to int64")
+	}
+}
+
+func TestUnmarshalTypeMismatch(t *testing.T) {
+	var p unmarshalShape
+
+	err := Convert(Object{"points": Array{Object{"x": "three"}}}, &p)
+	if err == nil {
+		t.Fatalf("expected type mismatch error")
+	}
+
+	if !strings.Contains(err.Error(), "points[0].x") {
+		t.Errorf("expected error to mention field path, got: %s", err)
+	}
+
+	if err = Convert("foo", p); err == nil {
+		t.Errorf("expected error for non-pointer destination")
+	}
+}
+
+func TestUnmarshalNil(t *testing.T) {
+	p := &unmarshalPoint{1, 2}
+	if err := Convert(nil, &p); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if p != nil {
+		t.Errorf("expected nil pointer, got %+v", p)
+	}
+}
+
+func TestUnmarshalVectorAndDictionary(t *testing.T) {
+	var ints []int
+	if err := Convert(VectorInt{Values: []int32{1, 2}}, &ints); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if !reflect.DeepEqual(ints, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", ints)
+	}
+
+	var names map[int]string
+	dict := Dictionary{Entries: []DictionaryEntry{{Key: int32(7), Value: "seven"}}}
+	if err := Convert(dict, &names); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if names[7] != "seven" {
+		t.Errorf("expected map with 7: seven, got %v", names)
+	}
+}
@@ -1,6 +1,7 @@
 package amf
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,9 @@ import (
 	"io"
 )
 
+// reads longer than this grow their buffer as the bytes arrive
+const READ_CHUNK_SIZE = 64 << 10
+
 var log logger.Logger = *logger.NewLogger(logger.LOG_LEVEL_WARN, "amf")
 
 func DumpBytes(label string, buf []byte, size int) {
@@ -54,10 +58,26 @@ func ReadByte(r io.Reader) (byte, error) {
 	return bytes[0], nil
 }
 
+// ReadBytes reads n bytes from r. n may be a length read from the input,
+// which may claim anything, so large reads grow their buffer as the bytes
+// arrive rather than allocating all of it up front.
 func ReadBytes(r io.Reader, n int) ([]byte, error) {
+	if n > READ_CHUNK_SIZE {
+		buf := new(bytes.Buffer)
+		buf.Grow(READ_CHUNK_SIZE)
+
+		m, err := io.CopyN(buf, r, int64(n))
+		if err == io.EOF && m > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return buf.Bytes(), err
+	}
+
 	bytes := make([]byte, n)
 
-	m, err := r.Read(bytes)
+	// a single read may return less than asked for on streams
+	m, err := io.ReadFull(r, bytes)
 	if err != nil {
 		return bytes, err
 	}
@@ -69,6 +89,34 @@ func ReadBytes(r io.Reader, n int) ([]byte, error) {
 	return bytes, nil
 }
 
+// capacityFor bounds the capacity preallocated for n elements, n being a
+// length read from the input, which may claim anything.
+func capacityFor(n uint32) int {
+	if n > 1024 {
+		return 1024
+	}
+
+	return int(n)
+}
+
+// lengthFor checks n, a count of values read from the input, against the
+// bytes left in r when r can tell, each value taking one at least. It
+// returns the number of values to preallocate: n once checked, or a
+// bounded capacity otherwise.
+func lengthFor(r io.Reader, n uint32) (int, error) {
+	if l, ok := r.(interface {
+		Len() int
+	}); ok {
+		if int64(n) > int64(l.Len()) {
+			return 0, Error("decode length failed: %d values in %d bytes", n, l.Len())
+		}
+
+		return int(n), nil
+	}
+
+	return capacityFor(n), nil
+}
+
 func WriteMarker(w io.Writer, m byte) error {
 	return WriteByte(w, m)
 }